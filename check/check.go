@@ -0,0 +1,343 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+type AnomalyTp string
+
+// anomalies defined by Adya, G2 here only contains the cycles with more than one RW edge
+var (
+	G0      AnomalyTp = "G0"
+	G1a     AnomalyTp = "G1a"
+	G1b     AnomalyTp = "G1b"
+	G1c     AnomalyTp = "G1c"
+	GSingle AnomalyTp = "G-single"
+	G2      AnomalyTp = "G2"
+)
+
+// Edge is a dependency between txns, From and To are the indexes in History.Txns
+type Edge struct {
+	From  int
+	To    int
+	Tp    graph.DependTp
+	Key   int
+	Value int
+}
+
+// Anomaly is a found anomaly, Cycle is the minimal cycle of it,
+// for G1a and G1b, Reader reads the Op of Writer and Cycle is empty
+type Anomaly struct {
+	Tp     AnomalyTp
+	Cycle  []Edge
+	Reader int
+	Writer int
+	Op     Op
+	txns   []Txn
+}
+
+type write struct {
+	txn int
+	op  int
+}
+
+type version struct {
+	txn   int
+	value int
+}
+
+// Check infers the WW, WR and RW dependencies between txns from the observed values, and finds the anomalies.
+// The version order of a key is the commit order of txns, because writes hold the locks until their txns end,
+// besides, a txn which reads a value and then writes the same key must be after the writer of that value.
+// The values of deletes are not unique, the reads of them are not used in inference.
+func Check(h *History) []Anomaly {
+	var (
+		anomalies []Anomaly
+		edges     []Edge
+		writes    = make(map[int]map[int]write)
+		// last is the index of the last write on each key in txn
+		last     = make([]map[int]int, len(h.Txns))
+		versions = make(map[int][]version)
+	)
+	for i := range h.Txns {
+		last[i] = make(map[int]int)
+		for j, op := range h.Txns[i].Ops {
+			if op.Tp != Write {
+				continue
+			}
+			last[i][op.Key] = j
+			if op.Value == kv.NULL_VALUE_ID {
+				continue
+			}
+			if writes[op.Key] == nil {
+				writes[op.Key] = make(map[int]write)
+			}
+			writes[op.Key][op.Value] = write{i, j}
+		}
+	}
+	for i := range h.Txns {
+		if !h.Txns[i].Committed {
+			continue
+		}
+		for key, j := range last[i] {
+			versions[key] = append(versions[key], version{i, h.Txns[i].Ops[j].Value})
+		}
+	}
+	keys := make([]int, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		vs := versions[key]
+		sort.SliceStable(vs, func(i, j int) bool {
+			a, b := h.Txns[vs[i].txn], h.Txns[vs[j].txn]
+			if !a.End.Equal(b.End) {
+				return a.End.Before(b.End)
+			}
+			return vs[i].txn < vs[j].txn
+		})
+		for i := 1; i < len(vs); i++ {
+			edges = append(edges, Edge{vs[i-1].txn, vs[i].txn, graph.WW, key, vs[i].value})
+		}
+	}
+
+	for i := range h.Txns {
+		for j, op := range h.Txns[i].Ops {
+			if op.Tp != Read || op.Value == UNKNOWN_VALUE || op.Value == kv.NULL_VALUE_ID {
+				continue
+			}
+			w, ok := writes[op.Key][op.Value]
+			if !ok || w.txn == i {
+				continue
+			}
+			if !h.Txns[w.txn].Committed {
+				anomalies = append(anomalies, Anomaly{Tp: G1a, Reader: i, Writer: w.txn, Op: op, txns: h.Txns})
+				continue
+			}
+			if last[w.txn][op.Key] != w.op {
+				anomalies = append(anomalies, Anomaly{Tp: G1b, Reader: i, Writer: w.txn, Op: op, txns: h.Txns})
+				continue
+			}
+			edges = append(edges, Edge{w.txn, i, graph.WR, op.Key, op.Value})
+			if k, ok := last[i][op.Key]; ok && k > j && h.Txns[i].Committed {
+				edges = append(edges, Edge{w.txn, i, graph.WW, op.Key, h.Txns[i].Ops[k].Value})
+			}
+			vs := versions[op.Key]
+			for p := range vs {
+				if vs[p].txn == w.txn {
+					if p+1 < len(vs) && vs[p+1].txn != i {
+						edges = append(edges, Edge{i, vs[p+1].txn, graph.RW, op.Key, vs[p+1].value})
+					}
+					break
+				}
+			}
+		}
+	}
+
+	for _, cycle := range findCycles(len(h.Txns), edges) {
+		cycle.txns = h.Txns
+		anomalies = append(anomalies, cycle)
+	}
+	return anomalies
+}
+
+// findCycles finds the minimal cycle of each kind in every strongly connected component
+func findCycles(n int, edges []Edge) []Anomaly {
+	outs := make([][]Edge, n)
+	for _, edge := range edges {
+		if edge.From != edge.To {
+			outs[edge.From] = append(outs[edge.From], edge)
+		}
+	}
+	var anomalies []Anomaly
+	for _, component := range components(outs) {
+		if len(component) < 2 {
+			continue
+		}
+		in := make(map[int]struct{}, len(component))
+		for _, v := range component {
+			in[v] = struct{}{}
+		}
+		var inner []Edge
+		for _, v := range component {
+			for _, edge := range outs[v] {
+				if _, ok := in[edge.To]; ok {
+					inner = append(inner, edge)
+				}
+			}
+		}
+		find := func(first graph.DependTp, allowed ...graph.DependTp) []Edge {
+			var shortest []Edge
+			for _, edge := range inner {
+				if edge.Tp != first {
+					continue
+				}
+				path := shortestPath(outs, in, edge.To, edge.From, allowed)
+				if path == nil {
+					continue
+				}
+				if cycle := append([]Edge{edge}, path...); shortest == nil || len(cycle) < len(shortest) {
+					shortest = cycle
+				}
+			}
+			return shortest
+		}
+		if cycle := find(graph.WW, graph.WW); cycle != nil {
+			anomalies = append(anomalies, Anomaly{Tp: G0, Cycle: cycle})
+		}
+		if cycle := find(graph.WR, graph.WW, graph.WR); cycle != nil {
+			anomalies = append(anomalies, Anomaly{Tp: G1c, Cycle: cycle})
+		}
+		if cycle := find(graph.RW, graph.WW, graph.WR); cycle != nil {
+			anomalies = append(anomalies, Anomaly{Tp: GSingle, Cycle: cycle})
+		} else if cycle := find(graph.RW, graph.WW, graph.WR, graph.RW); cycle != nil {
+			anomalies = append(anomalies, Anomaly{Tp: G2, Cycle: cycle})
+		}
+	}
+	return anomalies
+}
+
+// shortestPath finds the path from one txn to another by BFS, only the allowed edges inside the component are used
+func shortestPath(outs [][]Edge, in map[int]struct{}, from, to int, allowed []graph.DependTp) []Edge {
+	if from == to {
+		return []Edge{}
+	}
+	prev := map[int]Edge{}
+	visited := map[int]struct{}{from: {}}
+	queue := []int{from}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, edge := range outs[v] {
+			if _, ok := in[edge.To]; !ok || !containsTp(allowed, edge.Tp) {
+				continue
+			}
+			if _, ok := visited[edge.To]; ok {
+				continue
+			}
+			visited[edge.To] = struct{}{}
+			prev[edge.To] = edge
+			if edge.To == to {
+				var path []Edge
+				for v := to; v != from; v = prev[v].From {
+					path = append([]Edge{prev[v]}, path...)
+				}
+				return path
+			}
+			queue = append(queue, edge.To)
+		}
+	}
+	return nil
+}
+
+func containsTp(tps []graph.DependTp, tp graph.DependTp) bool {
+	for _, t := range tps {
+		if t == tp {
+			return true
+		}
+	}
+	return false
+}
+
+// components returns the strongly connected components by Tarjan's algorithm
+func components(outs [][]Edge) [][]int {
+	var (
+		index    = 0
+		indexes  = make([]int, len(outs))
+		lowlinks = make([]int, len(outs))
+		onStack  = make([]bool, len(outs))
+		stack    []int
+		result   [][]int
+		connect  func(int)
+	)
+	for i := range indexes {
+		indexes[i] = -1
+	}
+	connect = func(v int) {
+		indexes[v], lowlinks[v] = index, index
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, edge := range outs[v] {
+			w := edge.To
+			if indexes[w] == -1 {
+				connect(w)
+				if lowlinks[w] < lowlinks[v] {
+					lowlinks[v] = lowlinks[w]
+				}
+			} else if onStack[w] && indexes[w] < lowlinks[v] {
+				lowlinks[v] = indexes[w]
+			}
+		}
+		if lowlinks[v] == indexes[v] {
+			var component []int
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Ints(component)
+			result = append(result, component)
+		}
+	}
+	for v := range outs {
+		if indexes[v] == -1 {
+			connect(v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i][0] < result[j][0]
+	})
+	return result
+}
+
+// Prohibited reports if the anomaly is not allowed by the weakest isolation level of the txns in it,
+// read-committed and repeatable-read prohibit G0 and G1, serializable prohibits all
+func (a *Anomaly) Prohibited() bool {
+	isolation := graph.Serializable
+	weaker := func(i graph.Isolation) {
+		if i != graph.Serializable {
+			isolation = i
+		}
+	}
+	if a.Cycle == nil {
+		weaker(a.txns[a.Reader].Isolation)
+	}
+	for _, edge := range a.Cycle {
+		weaker(a.txns[edge.From].Isolation)
+	}
+	if isolation == graph.Serializable {
+		return true
+	}
+	return a.Tp != GSingle && a.Tp != G2
+}
+
+func (a *Anomaly) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: ", a.Tp)
+	if a.Cycle == nil {
+		reader, writer := a.txns[a.Reader], a.txns[a.Writer]
+		switch a.Tp {
+		case G1a:
+			fmt.Fprintf(&b, "%s reads %s written by aborted %s", reader.Name(), a.Op, writer.Name())
+		case G1b:
+			fmt.Fprintf(&b, "%s reads %s overwritten by %s later", reader.Name(), a.Op, writer.Name())
+		}
+		return b.String()
+	}
+	b.WriteString(a.txns[a.Cycle[0].From].Name())
+	for _, edge := range a.Cycle {
+		fmt.Fprintf(&b, " -%s(%d, %d)-> %s", edge.Tp, edge.Key, edge.Value, a.txns[edge.To].Name())
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package check
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+var base = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTxn creates a txn in its own thread which commits at end seconds
+func newTxn(thread, end int, committed bool, ops ...Op) Txn {
+	return Txn{
+		Thread:    thread,
+		Isolation: graph.RepeatableRead,
+		Committed: committed,
+		Start:     base,
+		End:       base.Add(time.Duration(end) * time.Second),
+		Ops:       ops,
+	}
+}
+
+func r(key, value int) Op {
+	return Op{Tp: Read, Key: key, Value: value}
+}
+
+func w(key, value int) Op {
+	return Op{Tp: Write, Key: key, Value: value}
+}
+
+func anomalyTps(anomalies []Anomaly) []AnomalyTp {
+	var tps []AnomalyTp
+	for _, anomaly := range anomalies {
+		tps = append(tps, anomaly.Tp)
+	}
+	return tps
+}
+
+func TestCheckSerial(t *testing.T) {
+	h := History{Txns: []Txn{
+		newTxn(0, 1, true, w(0, 1), w(1, 2)),
+		newTxn(1, 2, true, r(0, 1), w(0, 3), r(1, 2)),
+		newTxn(2, 3, true, r(0, 3), r(1, UNKNOWN_VALUE), r(1, kv.NULL_VALUE_ID)),
+		newTxn(3, 4, false, w(1, 4)),
+	}}
+	require.Empty(t, Check(&h))
+}
+
+func TestCheckReadAnomalies(t *testing.T) {
+	h := History{Txns: []Txn{
+		newTxn(0, 1, false, w(0, 1)),
+		newTxn(1, 2, true, w(1, 2), w(1, 3)),
+		newTxn(2, 3, true, r(0, 1), r(1, 2)),
+	}}
+	anomalies := Check(&h)
+	require.Equal(t, anomalyTps(anomalies), []AnomalyTp{G1a, G1b})
+	require.Equal(t, anomalies[0].String(), "G1a: T(2, 0) reads r(0, 1) written by aborted T(0, 0)")
+	require.Equal(t, anomalies[1].String(), "G1b: T(2, 0) reads r(1, 2) overwritten by T(1, 0) later")
+	require.True(t, anomalies[0].Prohibited())
+}
+
+func TestCheckCycles(t *testing.T) {
+	// T0 overwrites the value written by T1, but commits before T1
+	h := History{Txns: []Txn{
+		newTxn(0, 1, true, w(0, 1), r(1, 4), w(1, 2)),
+		newTxn(1, 2, true, w(1, 4)),
+	}}
+	anomalies := Check(&h)
+	require.Equal(t, anomalyTps(anomalies), []AnomalyTp{G0, G1c})
+	require.Equal(t, anomalies[0].String(), "G0: T(0, 0) -WW(1, 4)-> T(1, 0) -WW(1, 2)-> T(0, 0)")
+	require.Equal(t, anomalies[1].String(), "G1c: T(1, 0) -WR(1, 4)-> T(0, 0) -WW(1, 4)-> T(1, 0)")
+
+	// T0 and T1 read the values of each other
+	h = History{Txns: []Txn{
+		newTxn(0, 1, true, w(0, 1), r(1, 2)),
+		newTxn(1, 2, true, w(1, 2), r(0, 1)),
+	}}
+	anomalies = Check(&h)
+	require.Equal(t, anomalyTps(anomalies), []AnomalyTp{G1c})
+	require.True(t, anomalies[0].Prohibited())
+
+	// lost update, T0 and T1 read the same version and both write
+	h = History{Txns: []Txn{
+		newTxn(0, 1, true, w(0, 1)),
+		newTxn(1, 2, true, r(0, 1), w(0, 2)),
+		newTxn(2, 3, true, r(0, 1), w(0, 3)),
+	}}
+	anomalies = Check(&h)
+	require.Equal(t, anomalyTps(anomalies), []AnomalyTp{GSingle})
+	require.Equal(t, anomalies[0].String(), "G-single: T(2, 0) -RW(0, 2)-> T(1, 0) -WW(0, 3)-> T(2, 0)")
+	require.False(t, anomalies[0].Prohibited())
+	h.Txns[2].Isolation = graph.Serializable
+	require.False(t, anomalies[0].Prohibited())
+	h.Txns[1].Isolation = graph.Serializable
+	require.True(t, anomalies[0].Prohibited())
+
+	// write skew
+	h = History{Txns: []Txn{
+		newTxn(0, 1, true, w(0, 1), w(1, 2)),
+		newTxn(1, 2, true, r(0, 1), r(1, 2), w(0, 3)),
+		newTxn(2, 3, true, r(0, 1), r(1, 2), w(1, 4)),
+	}}
+	anomalies = Check(&h)
+	require.Equal(t, anomalyTps(anomalies), []AnomalyTp{G2})
+	require.Equal(t, anomalies[0].String(), "G2: T(1, 0) -RW(1, 4)-> T(2, 0) -RW(0, 3)-> T(1, 0)")
+}
@@ -0,0 +1,68 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+// UNKNOWN_VALUE is the value of reads whose results are not recorded, they are ignored by the checker
+const UNKNOWN_VALUE = kv.INVALID_VALUE_ID
+
+type OpTp string
+
+var (
+	Read  OpTp = "r"
+	Write OpTp = "w"
+)
+
+// Op is a read or write on a key, the value is the written value id or the observed one,
+// kv.NULL_VALUE_ID means the key is deleted or not found
+type Op struct {
+	Tp    OpTp
+	Key   int
+	Value int
+	SQL   string
+}
+
+// Txn is an executed transaction, Committed is false if it's rolled back, aborted or unfinished
+type Txn struct {
+	Thread    int
+	ID        int
+	Isolation graph.Isolation
+	Committed bool
+	Start     time.Time
+	End       time.Time
+	Ops       []Op
+}
+
+// History is the recorded transactions of all threads
+type History struct {
+	Txns []Txn
+}
+
+func (t *Txn) Name() string {
+	return fmt.Sprintf("T(%d, %d)", t.Thread, t.ID)
+}
+
+func (o Op) String() string {
+	return fmt.Sprintf("%s(%d, %d)", o.Tp, o.Key, o.Value)
+}
+
+func (t *Txn) String() string {
+	var b strings.Builder
+	b.WriteString(t.Name())
+	for _, op := range t.Ops {
+		b.WriteByte(' ')
+		b.WriteString(op.String())
+	}
+	if t.Committed {
+		b.WriteString(" Commit")
+	} else {
+		b.WriteString(" Abort")
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/you06/go-mikadzuki/check"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/manager"
+)
+
+var (
+	checkCfgFile string
+	checkLogPath string
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "check the recorded history for anomalies",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		if checkLogPath == "" {
+			fmt.Println("log path must be specified")
+			return
+		}
+		cfg := config.NewConfig()
+		if err := cfg.Load(checkCfgFile); err != nil {
+			panic(err)
+		}
+		mgr := manager.NewManager(manager.Option{
+			Cfg: &cfg,
+		})
+		h, err := mgr.LoadHistory(checkLogPath)
+		if err != nil {
+			fmt.Println("load history failed", err)
+			return
+		}
+		anomalies := check.Check(h)
+		if len(anomalies) == 0 {
+			fmt.Printf("no anomaly found in %d txns\n", len(h.Txns))
+			return
+		}
+		for _, anomaly := range anomalies {
+			if anomaly.Prohibited() {
+				fmt.Println("[PROHIBITED]", anomaly.String())
+			} else {
+				fmt.Println("[ALLOWED]", anomaly.String())
+			}
+		}
+	},
+}
+
+func init() {
+	checkCmd.Flags().StringVar(&checkCfgFile, "config", "config.toml", "config file")
+	checkCmd.Flags().StringVar(&checkLogPath, "log-path", "", "log directory contains graph.json and thread logs")
+}
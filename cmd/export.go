@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/manager"
+)
+
+var (
+	exportCfgFile string
+	exportLogPath string
+	exportFormat  string
+	exportOutput  string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export the interleaving of thread logs as SQL script or mysql-test case",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		if exportLogPath == "" {
+			fmt.Println("log file path must be specified")
+			return
+		}
+		if exportFormat == manager.EXPORT_MYSQLTEST {
+			exportMySQLTest()
+			return
+		}
+		if exportFormat != manager.EXPORT_SQLZ && exportFormat != manager.EXPORT_SQL {
+			fmt.Println("unsupported export format", exportFormat)
+			return
+		}
+		var w io.Writer = os.Stdout
+		if exportOutput != "" {
+			file, err := os.Create(exportOutput)
+			if err != nil {
+				fmt.Println("create output failed", err)
+				return
+			}
+			defer func() {
+				if err := file.Close(); err != nil {
+					fmt.Println(err)
+				}
+			}()
+			w = file
+		}
+		if err := manager.Export(exportLogPath, exportFormat, w); err != nil {
+			fmt.Println("export failed", err)
+		}
+	},
+}
+
+// exportMySQLTest writes the .test and .result files of the case
+func exportMySQLTest() {
+	cfg := config.NewConfig()
+	if err := cfg.Load(exportCfgFile); err != nil {
+		panic(err)
+	}
+	mgr := manager.NewManager(manager.Option{
+		Cfg: &cfg,
+	})
+	mysqlTest, err := mgr.ExportMySQLTest(exportLogPath)
+	if err != nil {
+		fmt.Println("export failed", err)
+		return
+	}
+	output := exportOutput
+	if output == "" {
+		output = path.Join(exportLogPath, "mikadzuki")
+	}
+	if err := ioutil.WriteFile(output+".test", []byte(mysqlTest.Test), 0644); err != nil {
+		fmt.Println("write test file failed", err)
+		return
+	}
+	if err := ioutil.WriteFile(output+".result", []byte(mysqlTest.Result), 0644); err != nil {
+		fmt.Println("write result file failed", err)
+		return
+	}
+	fmt.Printf("mysql-test case is written to %s.test and %s.result\n", output, output)
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportCfgFile, "config", "config.toml", "config file, used by mysqltest format to load the graph")
+	exportCmd.Flags().StringVar(&exportLogPath, "log-path", "", "path of log files")
+	exportCmd.Flags().StringVar(&exportFormat, "format", manager.EXPORT_SQLZ, "export format, sqlz, sql or mysqltest")
+	exportCmd.Flags().StringVar(&exportOutput, "output", "", "output file, stdout by default, the mysqltest format writes OUTPUT.test and OUTPUT.result, log-path/mikadzuki by default")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/manager"
+)
+
+var (
+	graphCfgFile string
+	graphFile    string
+	graphSeed    int64
+	graphFormat  string
+	graphOutput  string
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "export the dependency graph",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.NewConfig()
+		if err := cfg.Load(graphCfgFile); err != nil {
+			panic(err)
+		}
+		if graphSeed != 0 {
+			cfg.Global.Seed = graphSeed
+		}
+		mgr := manager.NewManager(manager.Option{
+			Cfg: &cfg,
+		})
+		var g *graph.Graph
+		if graphFile != "" {
+			file := graphFile
+			if info, err := os.Stat(graphFile); err == nil && info.IsDir() {
+				file = path.Join(graphFile, "graph.json")
+			}
+			var err error
+			if g, err = mgr.LoadGraph(file); err != nil {
+				fmt.Println("load graph failed", err)
+				return
+			}
+		} else {
+			var err error
+			if g, err = mgr.NewGraph(); err != nil {
+				fmt.Println("generate graph failed", err)
+				return
+			}
+		}
+		out, err := g.Export(graphFormat)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if graphOutput == "" {
+			fmt.Print(out)
+			return
+		}
+		if err := ioutil.WriteFile(graphOutput, []byte(out), 0644); err != nil {
+			fmt.Println("write graph failed", err)
+		}
+	},
+}
+
+func init() {
+	graphCmd.Flags().StringVar(&graphCfgFile, "config", "config.toml", "config file")
+	graphCmd.Flags().StringVar(&graphFile, "graph", "", "graph.json or the log directory contains it, a new graph is generated if not specified")
+	graphCmd.Flags().Int64Var(&graphSeed, "seed", 0, "seed of random graph, overwrites the one in config file")
+	graphCmd.Flags().StringVar(&graphFormat, "format", graph.FormatDOT, "export format, dot or mermaid")
+	graphCmd.Flags().StringVar(&graphOutput, "output", "", "output file, stdout by default")
+}
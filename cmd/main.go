@@ -12,6 +12,11 @@ var rootCmd = &cobra.Command{}
 func main() {
 	rootCmd.AddCommand(mikadzukiCmd)
 	rootCmd.AddCommand(parseCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(minimizeCmd)
+	rootCmd.AddCommand(graphCmd)
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(exportCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
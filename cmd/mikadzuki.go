@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/you06/go-mikadzuki/config"
@@ -13,8 +14,11 @@ import (
 )
 
 var (
-	cfgFile string
-	dryrun  bool
+	cfgFile  string
+	dryrun   bool
+	seed     int64
+	rounds   int
+	duration time.Duration
 )
 
 var mikadzukiCmd = &cobra.Command{
@@ -26,6 +30,9 @@ var mikadzukiCmd = &cobra.Command{
 		if err := cfg.Load(cfgFile); err != nil {
 			panic(err)
 		}
+		if seed != 0 {
+			cfg.Global.Seed = seed
+		}
 		mgr := manager.NewManager(manager.Option{
 			Cfg:    &cfg,
 			Dryrun: dryrun,
@@ -43,14 +50,29 @@ var mikadzukiCmd = &cobra.Command{
 			fmt.Printf("Got signal %d to exit.\n", <-sc)
 			cancel()
 		}()
-		// mgr.Run(ctx)
-		if err := mgr.Once(ctx); err != nil {
-			fmt.Println(err)
+		if rounds == 1 && duration == 0 {
+			fmt.Println("seed:", mgr.Seed())
+			if err := mgr.Once(ctx); err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		// the rounds are unlimited when only the duration is given
+		if !cmd.Flags().Changed("rounds") {
+			rounds = 0
 		}
+		summary := mgr.Run(ctx, manager.RunOption{
+			Rounds:   rounds,
+			Duration: duration,
+		})
+		fmt.Print(summary)
 	},
 }
 
 func init() {
 	mikadzukiCmd.Flags().StringVar(&cfgFile, "config", "config.toml", "config file")
 	mikadzukiCmd.Flags().BoolVar(&dryrun, "dryrun", false, "dry run mode will generate graph only")
+	mikadzukiCmd.Flags().Int64Var(&seed, "seed", 0, "seed of random graph, overwrites the one in config file")
+	mikadzukiCmd.Flags().IntVar(&rounds, "rounds", 1, "number of rounds to run, 0 for unlimited")
+	mikadzukiCmd.Flags().DurationVar(&duration, "duration", 0, "stop starting new rounds after the duration, like 2h, the rounds are unlimited if only it's given")
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/manager"
+)
+
+var (
+	minimizeCfgFile string
+	minimizeGraph   string
+	minimizeDSN     string
+	minimizeTarget  string
+	minimizeOutput  string
+	minimizeRuns    int
+	minimizeTimeout time.Duration
+)
+
+var minimizeCmd = &cobra.Command{
+	Use:   "minimize",
+	Short: "minimize a failed graph",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		if minimizeGraph == "" {
+			fmt.Println("graph path must be specified")
+			return
+		}
+		if minimizeTimeout < 0 {
+			fmt.Println("timeout must not be negative")
+			return
+		}
+		cfg := config.NewConfig()
+		if err := cfg.Load(minimizeCfgFile); err != nil {
+			panic(err)
+		}
+		if minimizeDSN != "" {
+			cfg.Global.DSN = minimizeDSN
+		}
+		if minimizeTarget != "" {
+			cfg.Global.Target = minimizeTarget
+		}
+		// candidates are not logged
+		cfg.Global.LogPath = ""
+		graphFile := minimizeGraph
+		if info, err := os.Stat(minimizeGraph); err == nil && info.IsDir() {
+			graphFile = path.Join(minimizeGraph, "graph.json")
+		}
+		if minimizeOutput == "" {
+			minimizeOutput = path.Join(path.Dir(graphFile), "minimized")
+		}
+
+		mgr := manager.NewManager(manager.Option{
+			Cfg: &cfg,
+		})
+		g, err := mgr.LoadGraph(graphFile)
+		if err != nil {
+			fmt.Println("load graph failed", err)
+			return
+		}
+		g, err = mgr.Minimize(context.Background(), g, manager.MinimizeOption{
+			Runs:    minimizeRuns,
+			Timeout: minimizeTimeout,
+		})
+		if err != nil {
+			fmt.Println("minimize failed", err)
+			return
+		}
+		if err := os.MkdirAll(minimizeOutput, 0755); err != nil {
+			fmt.Println("error create output dir", err)
+			return
+		}
+		manager.WriteGraph(g, minimizeOutput)
+		fmt.Println("minimized graph is written to", minimizeOutput)
+	},
+}
+
+func init() {
+	minimizeCmd.Flags().StringVar(&minimizeCfgFile, "config", "config.toml", "config file")
+	minimizeCmd.Flags().StringVar(&minimizeGraph, "graph", "", "graph.json or the log directory contains it")
+	minimizeCmd.Flags().StringVar(&minimizeDSN, "dsn", "", "dsn of database, overwrites the one in config file")
+	minimizeCmd.Flags().StringVar(&minimizeTarget, "target", "", "target database, overwrites the one in config file")
+	minimizeCmd.Flags().StringVar(&minimizeOutput, "output", "", "directory of the minimized graph, \"minimized\" next to the graph by default")
+	minimizeCmd.Flags().IntVar(&minimizeRuns, "runs", 3, "executions of each candidate, flaky failures need more")
+	minimizeCmd.Flags().DurationVar(&minimizeTimeout, "timeout", 10*time.Second, "timeout of each execution, 0 means no timeout")
+}
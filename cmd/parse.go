@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
 
 	"github.com/you06/go-mikadzuki/manager"
 
@@ -9,7 +13,11 @@ import (
 )
 
 var (
-	logPath string
+	logPath     string
+	parseOutput string
+	parseFilter manager.LogFilter
+	parseSince  string
+	parseUntil  string
 )
 
 var parseCmd = &cobra.Command{
@@ -21,13 +29,59 @@ var parseCmd = &cobra.Command{
 			fmt.Println("log file path must be specified")
 			return
 		}
-		if err := manager.ParseLog(logPath); err != nil {
+		var err error
+		if parseFilter.Since, err = parseLogTime(parseSince); err != nil {
+			fmt.Println("invalid since time", err)
+			return
+		}
+		if parseFilter.Until, err = parseLogTime(parseUntil); err != nil {
+			fmt.Println("invalid until time", err)
+			return
+		}
+		var w io.Writer = os.Stdout
+		if parseOutput != "-" {
+			output := parseOutput
+			if output == "" {
+				output = path.Join(logPath, "combine.log")
+			}
+			file, err := os.Create(output)
+			if err != nil {
+				fmt.Println("create output failed", err)
+				return
+			}
+			defer func() {
+				if err := file.Close(); err != nil {
+					fmt.Println(err)
+				}
+			}()
+			w = file
+		}
+		if err := manager.ParseLog(logPath, &parseFilter, w); err != nil {
 			fmt.Printf("log parse failed %v\n", err)
 			return
 		}
 	},
 }
 
+// parseLogTime parses the time in local time zone like the times in logs, the fraction is optional
+func parseLogTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(manager.LOGTIME_FORMAT, s, time.Local)
+	if err != nil {
+		return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+	}
+	return t, nil
+}
+
 func init() {
 	parseCmd.Flags().StringVar(&logPath, "log-path", "", "path of log files")
+	parseCmd.Flags().StringVar(&parseOutput, "output", "", "output file, log-path/combine.log by default, - for stdout")
+	parseCmd.Flags().IntSliceVar(&parseFilter.Threads, "thread", nil, "only the statements of these threads")
+	parseCmd.Flags().IntSliceVar(&parseFilter.Txns, "txn", nil, "only the statements of these txn ids, JSONL logs only")
+	parseCmd.Flags().IntSliceVar(&parseFilter.Keys, "key", nil, "only the statements of these key ids, JSONL logs only")
+	parseCmd.Flags().StringVar(&parseSince, "since", "", "only the statements start at or after the time, like 2006-01-02 15:04:05.00000")
+	parseCmd.Flags().StringVar(&parseUntil, "until", "", "only the statements start before the time")
+	parseCmd.Flags().BoolVar(&parseFilter.FailedOnly, "failed", false, "only the failed statements")
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/manager"
+)
+
+var (
+	replayCfgFile string
+	graphPath     string
+	replayDSN     string
+	replayTarget  string
+	interleave    bool
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "replay a saved graph",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		if graphPath == "" {
+			fmt.Println("graph path must be specified")
+			return
+		}
+		cfg := config.NewConfig()
+		if err := cfg.Load(replayCfgFile); err != nil {
+			panic(err)
+		}
+		if replayDSN != "" {
+			cfg.Global.DSN = replayDSN
+		}
+		if replayTarget != "" {
+			cfg.Global.Target = replayTarget
+		}
+		if err := cfg.Validate(); err != nil {
+			fmt.Println("invalid config", err)
+			return
+		}
+		// the log directory contains graph.json and thread logs
+		logPath, graphFile := path.Dir(graphPath), graphPath
+		if info, err := os.Stat(graphPath); err == nil && info.IsDir() {
+			logPath, graphFile = graphPath, path.Join(graphPath, "graph.json")
+		}
+
+		mgr := manager.NewManager(manager.Option{
+			Cfg: &cfg,
+		})
+		g, err := mgr.LoadGraph(graphFile)
+		if err != nil {
+			fmt.Println("load graph failed", err)
+			return
+		}
+		fmt.Println("seed:", g.Seed())
+		var interleaving *manager.Interleaving
+		if interleave {
+			if interleaving, err = manager.LoadInterleaving(logPath); err != nil {
+				fmt.Println("load interleaving failed", err)
+				return
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			sc := make(chan os.Signal, 1)
+			signal.Notify(sc,
+				os.Interrupt,
+				syscall.SIGHUP,
+				syscall.SIGINT,
+				syscall.SIGTERM,
+				syscall.SIGQUIT)
+
+			fmt.Printf("Got signal %d to exit.\n", <-sc)
+			cancel()
+		}()
+		if err := mgr.Replay(ctx, g, interleaving); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayCfgFile, "config", "config.toml", "config file")
+	replayCmd.Flags().StringVar(&graphPath, "graph", "", "graph.json or the log directory contains it")
+	replayCmd.Flags().StringVar(&replayDSN, "dsn", "", "dsn of database, overwrites the one in config file")
+	replayCmd.Flags().StringVar(&replayTarget, "target", "", "target database, overwrites the one in config file")
+	replayCmd.Flags().BoolVar(&interleave, "interleave", false, "start statements in the order recorded by thread logs in the log directory")
+}
@@ -21,6 +21,13 @@ func NewConfig() Config {
 
 // Load config from file
 func (c *Config) Load(file string) error {
-	_, err := toml.DecodeFile(file, c)
-	return errors.Trace(err)
+	if _, err := toml.DecodeFile(file, c); err != nil {
+		return errors.Trace(err)
+	}
+	return c.Validate()
+}
+
+// Validate checks the config, it should be called again after overwriting the loaded config
+func (c *Config) Validate() error {
+	return errors.Trace(c.Global.validate())
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -15,6 +16,12 @@ func TestDefaultConfig(t *testing.T) {
 	require.Equal(t, config.Global.Thread, 8)
 	require.Equal(t, config.Global.Action, 20)
 	require.Equal(t, config.Global.LogPath, "")
+	require.Equal(t, config.Global.LogFormat, "text")
+	require.Equal(t, config.Global.StmtTimeout.Duration, time.Duration(0))
+	require.False(t, config.Global.LockViews)
+	require.Equal(t, config.Global.InvalidGraph, "ignore")
+	require.Equal(t, config.Global.Isolation, "repeatable-read")
+	require.Equal(t, config.Global.ThreadIsolation(3), "repeatable-read")
 	// graph fields
 	require.Equal(t, config.Graph.Begin, 20)
 	require.Equal(t, config.Graph.Commit, 20)
@@ -40,6 +47,13 @@ func TestLoadConfig(t *testing.T) {
 	require.Equal(t, config.Global.Thread, 4)
 	require.Equal(t, config.Global.Action, 10)
 	require.Equal(t, config.Global.LogPath, "./logs")
+	require.Equal(t, config.Global.LogFormat, "jsonl")
+	require.Equal(t, config.Global.StmtTimeout.Duration, 90*time.Second)
+	require.True(t, config.Global.LockViews)
+	require.Equal(t, config.Global.InvalidGraph, "repair")
+	require.Equal(t, config.Global.Isolation, "read-committed")
+	require.Equal(t, config.Global.ThreadIsolation(0), "repeatable-read")
+	require.Equal(t, config.Global.ThreadIsolation(3), "serializable")
 	// graph fields
 	require.Equal(t, config.Graph.Begin, 2)
 	require.Equal(t, config.Graph.Commit, 2)
@@ -72,3 +86,14 @@ func TestLoadConfig(t *testing.T) {
 		"RW": 1,
 	})
 }
+
+func TestValidateConfig(t *testing.T) {
+	config := NewConfig()
+	require.Nil(t, config.Validate())
+	// the overwritten fields are checked again
+	config.Global.Target = "oracle"
+	require.NotNil(t, config.Validate())
+	config.Global.Target = TARGET_SQLITE
+	config.Global.Isolation = READ_COMMITTED
+	require.NotNil(t, config.Validate())
+}
@@ -1,5 +1,40 @@
 package config
 
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	REPEATABLE_READ = "repeatable-read"
+	READ_COMMITTED  = "read-committed"
+	SERIALIZABLE    = "serializable"
+)
+
+var isolations = []string{REPEATABLE_READ, READ_COMMITTED, SERIALIZABLE}
+
+// the targets are referred as kv.TARGET_*, they are defined here because kv depends on config
+const (
+	TARGET_MYSQL    = "mysql"
+	TARGET_TIDB     = "tidb"
+	TARGET_POSTGRES = "postgres"
+	TARGET_SQLITE   = "sqlite"
+	TARGET_MEMORY   = "memory"
+)
+
+// formats of thread logs
+const (
+	LOG_FORMAT_TEXT  = "text"
+	LOG_FORMAT_JSONL = "jsonl"
+)
+
+// the ways of handling the invalid graphs before execution
+const (
+	INVALID_GRAPH_IGNORE     = "ignore"
+	INVALID_GRAPH_REGENERATE = "regenerate"
+	INVALID_GRAPH_REPAIR     = "repair"
+)
+
 type Global struct {
 	DSN      string `toml:"dsn"`
 	Database string `toml:"database"`
@@ -7,17 +42,96 @@ type Global struct {
 	Thread   int    `toml:"thread"`
 	Action   int    `toml:"action"`
 	LogPath  string `toml:"log-path"`
-	Anomaly  bool   `toml:"anomaly"`
+	// LogFormat is the format of thread logs, text for reading and jsonl for tools
+	LogFormat string `toml:"log-format"`
+	Anomaly   bool   `toml:"anomaly"`
+	// Isolation is the transaction isolation level of all threads,
+	// it's overwritten by IsolationMix if the later one is not empty
+	Isolation    string   `toml:"isolation"`
+	IsolationMix []string `toml:"isolation-mix"`
+	// Seed generates the first graph, the following graphs use seed+1, seed+2...
+	// a random seed is used if it's 0
+	Seed int64 `toml:"seed"`
+	// StmtTimeout fails the graph with hang diagnostics when a statement, COMMIT or ROLLBACK runs longer than it,
+	// it should be longer than the expected lock waits, 0 means no timeout
+	StmtTimeout Duration `toml:"stmt-timeout"`
+	// LockViews adds the lock views of server into hang diagnostics
+	LockViews bool `toml:"lock-views"`
+	// InvalidGraph is how the generated graphs failing validation are handled,
+	// ignore executes them, regenerate uses the next seed and repair removes the problem actions
+	InvalidGraph string `toml:"invalid-graph"`
+}
+
+// Duration is time.Duration written like "30s" in config file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
 
 func NewGlobal() Global {
 	return Global{
-		DSN:      "root:@tcp(172.17.0.1:4000)/",
-		Database: "mikadzuki",
-		Target:   "mysql",
-		Thread:   8,
-		Action:   20,
-		LogPath:  "",
-		Anomaly:  false,
+		DSN:          "root:@tcp(172.17.0.1:4000)/",
+		Database:     "mikadzuki",
+		Target:       TARGET_MYSQL,
+		Thread:       8,
+		Action:       20,
+		LogPath:      "",
+		LogFormat:    LOG_FORMAT_TEXT,
+		Anomaly:      false,
+		Isolation:    REPEATABLE_READ,
+		IsolationMix: []string{},
+		Seed:         0,
+		StmtTimeout:  Duration{0},
+		LockViews:    false,
+		InvalidGraph: INVALID_GRAPH_IGNORE,
+	}
+}
+
+// ThreadIsolation returns the isolation level of nth thread
+func (g *Global) ThreadIsolation(n int) string {
+	if len(g.IsolationMix) == 0 {
+		return g.Isolation
+	}
+	return g.IsolationMix[n%len(g.IsolationMix)]
+}
+
+func (g *Global) validate() error {
+	switch g.Target {
+	case TARGET_MYSQL, TARGET_TIDB, TARGET_POSTGRES, TARGET_SQLITE, TARGET_MEMORY:
+	default:
+		return fmt.Errorf("unsupported target %s", g.Target)
+	}
+	if g.LogFormat != LOG_FORMAT_TEXT && g.LogFormat != LOG_FORMAT_JSONL {
+		return fmt.Errorf("invalid log format %s", g.LogFormat)
+	}
+	switch g.InvalidGraph {
+	case INVALID_GRAPH_IGNORE, INVALID_GRAPH_REGENERATE, INVALID_GRAPH_REPAIR:
+	default:
+		return fmt.Errorf("invalid way of handling invalid graph %s", g.InvalidGraph)
+	}
+	if g.StmtTimeout.Duration < 0 {
+		return fmt.Errorf("invalid statement timeout %s", g.StmtTimeout)
+	}
+	for _, isolation := range append([]string{g.Isolation}, g.IsolationMix...) {
+		valid := false
+		for _, i := range isolations {
+			if isolation == i {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid isolation level %s", isolation)
+		}
+		// SQLite reads from snapshot and writes with database lock
+		if g.Target == TARGET_SQLITE && isolation != REPEATABLE_READ {
+			return fmt.Errorf("isolation level %s is not supported by sqlite", isolation)
+		}
 	}
+	return nil
 }
@@ -1,9 +1,12 @@
 package db
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
 
 type DB interface {
-	Begin() (Txn, error)
+	Begin(*sql.TxOptions) (Txn, error)
 	Close() error
 	Exec(string) (*sql.Result, error)
 	Query(string) (*sql.Rows, error)
@@ -12,6 +15,9 @@ type DB interface {
 type Txn interface {
 	Exec(string) (*sql.Result, error)
 	Query(string) (*sql.Rows, error)
+	// ExecContext and QueryContext stop the statement when ctx is done
+	ExecContext(context.Context, string) (*sql.Result, error)
+	QueryContext(context.Context, string) (*sql.Rows, error)
 	Commit() error
 	Rollback() error
 }
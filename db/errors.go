@@ -0,0 +1,25 @@
+package db
+
+import (
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/juju/errors"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrorCode returns the error code reported by the database driver,
+// it's empty if err is not from a driver
+func ErrorCode(err error) string {
+	switch e := errors.Cause(err).(type) {
+	case *mysql.MySQLError:
+		return strconv.Itoa(int(e.Number))
+	case *pq.Error:
+		return string(e.Code)
+	case sqlite3.Error:
+		return strconv.Itoa(int(e.ExtendedCode))
+	default:
+		return ""
+	}
+}
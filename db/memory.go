@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/juju/errors"
+)
+
+// Memory is an in-process database which understands the SQL generated by kv.Schema,
+// it's a known-correct model for comparing and makes tests hermetic
+type Memory struct {
+	dsn string
+	db  *sql.DB
+}
+
+type MemoryTxn struct {
+	txn *sql.Tx
+}
+
+func NewMemory(dsn string) (*Memory, error) {
+	db, err := sql.Open(MEMORY_DRIVER, dsn)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Memory{
+		dsn: dsn,
+		db:  db,
+	}, nil
+}
+
+func (m *Memory) Begin(opts *sql.TxOptions) (Txn, error) {
+	txn, err := m.db.BeginTx(context.Background(), opts)
+	return &MemoryTxn{txn}, errors.Trace(err)
+}
+
+func (m *Memory) Close() error {
+	return errors.Trace(m.db.Close())
+}
+
+func (m *Memory) Exec(sql string) (*sql.Result, error) {
+	r, err := m.db.Exec(sql)
+	return &r, errors.Trace(err)
+}
+
+func (m *Memory) Query(sql string) (*sql.Rows, error) {
+	r, err := m.db.Query(sql)
+	return r, errors.Trace(err)
+}
+
+func (m *MemoryTxn) Exec(sql string) (*sql.Result, error) {
+	return m.ExecContext(context.Background(), sql)
+}
+
+func (m *MemoryTxn) Query(sql string) (*sql.Rows, error) {
+	return m.QueryContext(context.Background(), sql)
+}
+
+func (m *MemoryTxn) ExecContext(ctx context.Context, sql string) (*sql.Result, error) {
+	r, err := m.txn.ExecContext(ctx, sql)
+	return &r, errors.Trace(err)
+}
+
+func (m *MemoryTxn) QueryContext(ctx context.Context, sql string) (*sql.Rows, error) {
+	r, err := m.txn.QueryContext(ctx, sql)
+	return r, err
+}
+
+func (m *MemoryTxn) Commit() error {
+	return errors.Trace(m.txn.Commit())
+}
+
+func (m *MemoryTxn) Rollback() error {
+	return errors.Trace(m.txn.Rollback())
+}
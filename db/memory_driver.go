@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// MEMORY_DRIVER is the database/sql driver name of in-memory database
+const MEMORY_DRIVER = "mikadzuki-memory"
+
+func init() {
+	sql.Register(MEMORY_DRIVER, memDriver{})
+}
+
+type memDriver struct{}
+
+// Open connects to the store named by the part before the last "/" of dsn,
+// the part after it is the selected database, which can be empty
+func (memDriver) Open(dsn string) (driver.Conn, error) {
+	name, database := dsn, ""
+	if i := strings.LastIndex(dsn, "/"); i >= 0 {
+		name, database = dsn[:i], dsn[i+1:]
+	}
+	return &memConn{
+		store:    getMemStore(name),
+		database: database,
+	}, nil
+}
+
+type memConn struct {
+	store    *memStore
+	database string
+	txn      *memTxn
+}
+
+func (c *memConn) Prepare(query string) (driver.Stmt, error) {
+	return &memStmt{c, query}, nil
+}
+
+func (c *memConn) Close() error {
+	if c.txn != nil {
+		c.store.mu.Lock()
+		c.txn.rollback()
+		c.store.mu.Unlock()
+		c.txn = nil
+	}
+	return nil
+}
+
+func (c *memConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *memConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.txn != nil {
+		return nil, errors.New("transaction already started")
+	}
+	isolation := sql.IsolationLevel(opts.Isolation)
+	if isolation == sql.LevelDefault {
+		isolation = sql.LevelRepeatableRead
+	}
+	c.txn = c.store.begin(isolation)
+	return &memTx{c}, nil
+}
+
+// run executes the statement in current txn, or in an auto-commit txn, the lock waits stop when ctx is done
+func (c *memConn) run(ctx context.Context, query string) (*memRows, int64, error) {
+	stmt, err := parseMemSQL(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	txn, autocommit := c.txn, c.txn == nil
+	if autocommit {
+		txn = c.store.begin(sql.LevelRepeatableRead)
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	txn.ctx = ctx
+	rows, affected, err := txn.run(c.database, stmt)
+	txn.ctx = nil
+	if autocommit {
+		if err != nil {
+			txn.rollback()
+		} else {
+			txn.commit()
+		}
+	}
+	return rows, affected, err
+}
+
+func (c *memConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	_, affected, err := c.run(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *memConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	rows, _, err := c.run(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = &memRows{}
+	}
+	return rows, nil
+}
+
+type memTx struct {
+	conn *memConn
+}
+
+func (t *memTx) Commit() error {
+	c := t.conn
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.txn.commit()
+	c.txn = nil
+	return nil
+}
+
+func (t *memTx) Rollback() error {
+	c := t.conn
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.txn.rollback()
+	c.txn = nil
+	return nil
+}
+
+type memStmt struct {
+	conn  *memConn
+	query string
+}
+
+func (s *memStmt) Close() error {
+	return nil
+}
+
+func (s *memStmt) NumInput() int {
+	return 0
+}
+
+func (s *memStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, nil)
+}
+
+func (s *memStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, nil)
+}
+
+type memRows struct {
+	columns []string
+	types   []string
+	rows    [][]interface{}
+	pos     int
+}
+
+func (r *memRows) Columns() []string {
+	return r.columns
+}
+
+func (r *memRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.types[index]
+}
+
+func (r *memRows) Close() error {
+	return nil
+}
+
+func (r *memRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	for i, v := range r.rows[r.pos] {
+		dest[i] = v
+	}
+	r.pos++
+	return nil
+}
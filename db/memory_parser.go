@@ -0,0 +1,548 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+type memTokenTp int
+
+const (
+	memIdent memTokenTp = iota
+	memNumber
+	memString
+	memSymbol
+	memEOF
+)
+
+type memToken struct {
+	tp  memTokenTp
+	val string
+}
+
+type memLiteral struct {
+	null bool
+	val  string
+}
+
+type memCond struct {
+	column string
+	value  memLiteral
+}
+
+type memColumnDef struct {
+	name    string
+	tp      string
+	notNull bool
+}
+
+type memUniqueDef struct {
+	name    string
+	columns []string
+}
+
+type memNoopStmt struct{}
+
+// memSetLockWaitTimeoutStmt sets innodb_lock_wait_timeout in seconds, it's shared by the sessions of store
+type memSetLockWaitTimeoutStmt struct {
+	seconds int
+}
+
+type memCreateDatabaseStmt struct {
+	name string
+}
+
+type memDropDatabaseStmt struct {
+	name     string
+	ifExists bool
+}
+
+type memCreateTableStmt struct {
+	name    string
+	columns []memColumnDef
+	primary []string
+	uniques []memUniqueDef
+}
+
+type memDropTableStmt struct {
+	name     string
+	ifExists bool
+}
+
+// memWhere is the conjunction of equal conditions, never is true for the always false condition
+type memWhere struct {
+	conds []memCond
+	never bool
+}
+
+type memSelectStmt struct {
+	table     string
+	where     memWhere
+	forUpdate bool
+}
+
+type memInsertStmt struct {
+	table   string
+	values  []memLiteral
+	replace bool
+	// onDup is the ON DUPLICATE KEY UPDATE assignments
+	onDup []memCond
+}
+
+type memUpdateStmt struct {
+	table string
+	sets  []memCond
+	where memWhere
+}
+
+type memDeleteStmt struct {
+	table string
+	where memWhere
+}
+
+func memLex(sql string) ([]memToken, error) {
+	var tokens []memToken
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '@' || c == '.' || c == '$' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\n' || c == '\t' || c == '\r':
+			i++
+		case isDigit(c) || (c == '-' && i+1 < len(sql) && isDigit(sql[i+1])):
+			j := i + 1
+			for j < len(sql) && isDigit(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, memToken{memNumber, sql[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; ; j++ {
+				if j >= len(sql) {
+					return nil, errors.Errorf("unclosed string in %s", sql)
+				}
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						b.WriteByte(c)
+						j++
+						continue
+					}
+					break
+				}
+				if sql[j] == '\\' && j+1 < len(sql) {
+					j++
+					switch sql[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					case '0':
+						b.WriteByte(0)
+					default:
+						b.WriteByte(sql[j])
+					}
+					continue
+				}
+				b.WriteByte(sql[j])
+			}
+			tokens = append(tokens, memToken{memString, b.String()})
+			i = j + 1
+		case c == '`':
+			j := strings.IndexByte(sql[i+1:], '`')
+			if j < 0 {
+				return nil, errors.Errorf("unclosed identifier in %s", sql)
+			}
+			tokens = append(tokens, memToken{memIdent, sql[i+1 : i+1+j]})
+			i += j + 2
+		case isIdent(c):
+			j := i + 1
+			for j < len(sql) && isIdent(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, memToken{memIdent, sql[i:j]})
+			i = j
+		case strings.IndexByte("(),=;*", c) >= 0:
+			tokens = append(tokens, memToken{memSymbol, string(c)})
+			i++
+		default:
+			return nil, errors.Errorf("unexpected character %q in %s", c, sql)
+		}
+	}
+	return append(tokens, memToken{tp: memEOF}), nil
+}
+
+type memParser struct {
+	sql    string
+	tokens []memToken
+	pos    int
+}
+
+func (p *memParser) peek() memToken {
+	return p.tokens[p.pos]
+}
+
+func (p *memParser) next() memToken {
+	t := p.tokens[p.pos]
+	if t.tp != memEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *memParser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("You have an error in your SQL syntax: %s, near '%s' in %s",
+		fmt.Sprintf(format, args...), p.peek().val, p.sql)
+}
+
+// keyword consumes the given keywords if all of them match
+func (p *memParser) keyword(kws ...string) bool {
+	for i, kw := range kws {
+		if p.pos+i >= len(p.tokens) {
+			return false
+		}
+		t := p.tokens[p.pos+i]
+		if t.tp != memIdent || !strings.EqualFold(t.val, kw) {
+			return false
+		}
+	}
+	p.pos += len(kws)
+	return true
+}
+
+func (p *memParser) expect(kws ...string) error {
+	if !p.keyword(kws...) {
+		return p.errorf("expect %s", strings.Join(kws, " "))
+	}
+	return nil
+}
+
+func (p *memParser) symbol(s string) bool {
+	if t := p.peek(); t.tp == memSymbol && t.val == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *memParser) expectSymbol(s string) error {
+	if !p.symbol(s) {
+		return p.errorf("expect %s", s)
+	}
+	return nil
+}
+
+func (p *memParser) ident() (string, error) {
+	t := p.peek()
+	if t.tp != memIdent {
+		return "", p.errorf("expect identifier")
+	}
+	p.pos++
+	return strings.ToLower(t.val), nil
+}
+
+func (p *memParser) idents() ([]string, error) {
+	var names []string
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if p.symbol(")") {
+			return names, nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *memParser) literal() (memLiteral, error) {
+	t := p.peek()
+	switch {
+	case t.tp == memNumber || t.tp == memString:
+		p.pos++
+		return memLiteral{val: t.val}, nil
+	case p.keyword("NULL"):
+		return memLiteral{null: true}, nil
+	case p.keyword("TRUE"):
+		return memLiteral{val: "1"}, nil
+	case p.keyword("FALSE"):
+		return memLiteral{val: "0"}, nil
+	}
+	return memLiteral{}, p.errorf("expect literal")
+}
+
+// assigns parses "col=literal" list separated by sep
+func (p *memParser) assigns(sep string) ([]memCond, error) {
+	var conds []memCond
+	for {
+		column, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		value, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, memCond{column, value})
+		if sep == "," && !p.symbol(",") {
+			return conds, nil
+		}
+		if sep != "," && !p.keyword(sep) {
+			return conds, nil
+		}
+	}
+}
+
+func (p *memParser) where() (memWhere, error) {
+	if err := p.expect("WHERE"); err != nil {
+		return memWhere{}, err
+	}
+	if t := p.peek(); t.tp == memNumber || (t.tp == memIdent &&
+		(strings.EqualFold(t.val, "TRUE") || strings.EqualFold(t.val, "FALSE"))) {
+		lit, err := p.literal()
+		if err != nil {
+			return memWhere{}, err
+		}
+		return memWhere{never: lit.val == "0"}, nil
+	}
+	conds, err := p.assigns("AND")
+	return memWhere{conds: conds}, err
+}
+
+func (p *memParser) ifExists() bool {
+	return p.keyword("IF", "EXISTS")
+}
+
+func (s *memCreateTableStmt) unique(p *memParser, name string) error {
+	columns, err := p.idents()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = columns[0]
+	}
+	s.uniques = append(s.uniques, memUniqueDef{name, columns})
+	return nil
+}
+
+func (p *memParser) createTable() (*memCreateTableStmt, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt := memCreateTableStmt{name: name}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.keyword("PRIMARY", "KEY"):
+			if stmt.primary, err = p.idents(); err != nil {
+				return nil, err
+			}
+		case p.keyword("CONSTRAINT"):
+			// CONSTRAINT name UNIQUE(...)
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("UNIQUE"); err != nil {
+				return nil, err
+			}
+			if err := stmt.unique(p, name); err != nil {
+				return nil, err
+			}
+		case p.keyword("UNIQUE"):
+			_ = p.keyword("KEY") || p.keyword("INDEX")
+			name := ""
+			if p.peek().tp == memIdent {
+				if name, err = p.ident(); err != nil {
+					return nil, err
+				}
+			}
+			if err := stmt.unique(p, name); err != nil {
+				return nil, err
+			}
+		default:
+			column := memColumnDef{}
+			if column.name, err = p.ident(); err != nil {
+				return nil, err
+			}
+			tp, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			column.tp = strings.ToUpper(tp)
+			if memKindOf(column.tp) == memUnknown {
+				return nil, p.errorf("unsupported type %s", column.tp)
+			}
+			if p.symbol("(") {
+				if t := p.next(); t.tp != memNumber {
+					return nil, p.errorf("expect column size")
+				}
+				if err := p.expectSymbol(")"); err != nil {
+					return nil, err
+				}
+			}
+			if p.keyword("NOT", "NULL") {
+				column.notNull = true
+			} else {
+				_ = p.keyword("NULL")
+			}
+			stmt.columns = append(stmt.columns, column)
+		}
+		if p.symbol(")") {
+			return &stmt, nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *memParser) statement() (interface{}, error) {
+	switch {
+	case p.keyword("SET"):
+		return p.set()
+	case p.keyword("CREATE", "DATABASE"):
+		name, err := p.ident()
+		return &memCreateDatabaseStmt{name}, err
+	case p.keyword("DROP", "DATABASE"):
+		ifExists := p.ifExists()
+		name, err := p.ident()
+		return &memDropDatabaseStmt{name, ifExists}, err
+	case p.keyword("CREATE", "TABLE"):
+		return p.createTable()
+	case p.keyword("DROP", "TABLE"):
+		ifExists := p.ifExists()
+		name, err := p.ident()
+		return &memDropTableStmt{name, ifExists}, err
+	case p.keyword("SELECT"):
+		if err := p.expectSymbol("*"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("FROM"); err != nil {
+			return nil, err
+		}
+		table, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		where, err := p.where()
+		if err != nil {
+			return nil, err
+		}
+		return &memSelectStmt{table, where, p.keyword("FOR", "UPDATE")}, nil
+	case p.keyword("INSERT", "INTO"), p.keyword("REPLACE", "INTO"):
+		stmt := memInsertStmt{replace: strings.EqualFold(p.tokens[p.pos-2].val, "REPLACE")}
+		var err error
+		if stmt.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("VALUES"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		for {
+			value, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			stmt.values = append(stmt.values, value)
+			if p.symbol(")") {
+				break
+			}
+			if err := p.expectSymbol(","); err != nil {
+				return nil, err
+			}
+		}
+		if !stmt.replace && p.keyword("ON", "DUPLICATE", "KEY", "UPDATE") {
+			if stmt.onDup, err = p.assigns(","); err != nil {
+				return nil, err
+			}
+		}
+		return &stmt, nil
+	case p.keyword("UPDATE"):
+		table, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("SET"); err != nil {
+			return nil, err
+		}
+		sets, err := p.assigns(",")
+		if err != nil {
+			return nil, err
+		}
+		where, err := p.where()
+		return &memUpdateStmt{table, sets, where}, err
+	case p.keyword("DELETE", "FROM"):
+		table, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		where, err := p.where()
+		return &memDeleteStmt{table, where}, err
+	}
+	return nil, p.errorf("unsupported statement")
+}
+
+// parseMemSQL parses the statements generated by kv.Schema and the ones used to init database
+// set parses SET statement, only the lock wait timeout is supported,
+// the other session and global variables make no sense here
+func (p *memParser) set() (interface{}, error) {
+	_ = p.keyword("GLOBAL") || p.keyword("SESSION")
+	name := strings.ToLower(strings.TrimPrefix(p.peek().val, "@@"))
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "global."), "session.")
+	if name != "innodb_lock_wait_timeout" {
+		p.pos = len(p.tokens) - 1
+		return memNoopStmt{}, nil
+	}
+	p.pos++
+	if err := p.expectSymbol("="); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	seconds, err := strconv.Atoi(t.val)
+	if t.tp != memNumber || err != nil || seconds < 1 {
+		return nil, errors.Errorf("Incorrect argument type to variable 'innodb_lock_wait_timeout'")
+	}
+	return &memSetLockWaitTimeoutStmt{seconds}, nil
+}
+
+func parseMemSQL(sql string) (interface{}, error) {
+	tokens, err := memLex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := memParser{sql: sql, tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.symbol(";")
+	if p.peek().tp != memEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return stmt, nil
+}
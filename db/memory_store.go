@@ -0,0 +1,928 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/juju/errors"
+)
+
+// MEMORY_LOCK_WAIT_TIMEOUT is the same as innodb_lock_wait_timeout by default
+const MEMORY_LOCK_WAIT_TIMEOUT = 50 * time.Second
+
+const (
+	memDateFormat     = "2006-01-02"
+	memDatetimeFormat = "2006-01-02 15:04:05"
+	// memKeySep separates the values in index key, it never appears in generated values
+	memKeySep = "\x00"
+)
+
+var (
+	// the lock errors have the same codes as MySQL, so the expected errors of cycles can be matched
+	errMemDeadlock        = &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}
+	errMemLockWaitTimeout = &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded; try restarting transaction"}
+	errMemNoDatabase      = errors.New("No database selected")
+)
+
+type memKind int
+
+const (
+	memUnknown memKind = iota
+	memInt
+	memDate
+	memDatetime
+	memText
+)
+
+func memKindOf(tp string) memKind {
+	switch tp {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT":
+		return memInt
+	case "DATE":
+		return memDate
+	case "DATETIME", "TIMESTAMP":
+		return memDatetime
+	case "CHAR", "VARCHAR", "TEXT":
+		return memText
+	default:
+		return memUnknown
+	}
+}
+
+type memColumn struct {
+	name    string
+	tp      string
+	kind    memKind
+	notNull bool
+}
+
+// convert parses literal into int64, time.Time or string by the column type
+func (c *memColumn) convert(lit memLiteral) (interface{}, error) {
+	if lit.null {
+		if c.notNull {
+			return nil, errors.Errorf("Column '%s' cannot be null", c.name)
+		}
+		return nil, nil
+	}
+	switch c.kind {
+	case memInt:
+		v, err := strconv.ParseInt(lit.val, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("Incorrect integer value: '%s' for column '%s'", lit.val, c.name)
+		}
+		return v, nil
+	case memDate, memDatetime:
+		layout := memDatetimeFormat
+		if len(lit.val) == len(memDateFormat) {
+			layout = memDateFormat
+		}
+		v, err := time.Parse(layout, lit.val)
+		if err != nil {
+			return nil, errors.Errorf("Incorrect %s value: '%s' for column '%s'", strings.ToLower(c.tp), lit.val, c.name)
+		}
+		if c.kind == memDate {
+			v = v.Truncate(24 * time.Hour)
+		}
+		return v, nil
+	default:
+		return lit.val, nil
+	}
+}
+
+// memCanonical is the canonical string of a value, it's used for comparing and building keys
+func memCanonical(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.Format(memDatetimeFormat)
+	default:
+		return v.(string)
+	}
+}
+
+type memVersion struct {
+	ts uint64
+	// values is nil when the row is deleted
+	values []interface{}
+}
+
+type memTable struct {
+	name    string
+	columns []memColumn
+	primary []int
+	uniques [][]int
+	// uniqueNames is used in duplicate entry error
+	uniqueNames []string
+	// lockPrefix makes lock keys unique among databases and tables
+	lockPrefix string
+	// rows are versions of rows, keyed by primary key
+	rows    map[string][]memVersion
+	allocID int
+}
+
+func newMemTable(database string, stmt *memCreateTableStmt) (*memTable, error) {
+	t := memTable{
+		name:       stmt.name,
+		lockPrefix: fmt.Sprintf("%s.%s/", database, stmt.name),
+		rows:       make(map[string][]memVersion),
+	}
+	for _, def := range stmt.columns {
+		t.columns = append(t.columns, memColumn{
+			name:    def.name,
+			tp:      def.tp,
+			kind:    memKindOf(def.tp),
+			notNull: def.notNull,
+		})
+	}
+	positions := func(names []string) ([]int, error) {
+		var pos []int
+		for _, name := range names {
+			i := t.column(name)
+			if i < 0 {
+				return nil, errors.Errorf("Key column '%s' doesn't exist in table", name)
+			}
+			pos = append(pos, i)
+		}
+		return pos, nil
+	}
+	var err error
+	if t.primary, err = positions(stmt.primary); err != nil {
+		return nil, err
+	}
+	for _, pos := range t.primary {
+		t.columns[pos].notNull = true
+	}
+	for _, unique := range stmt.uniques {
+		pos, err := positions(unique.columns)
+		if err != nil {
+			return nil, err
+		}
+		t.uniques = append(t.uniques, pos)
+		t.uniqueNames = append(t.uniqueNames, unique.name)
+	}
+	return &t, nil
+}
+
+func (t *memTable) column(name string) int {
+	for i, column := range t.columns {
+		if column.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *memTable) indexKey(values []interface{}, index []int) (string, bool) {
+	parts := make([]string, len(index))
+	for i, pos := range index {
+		// NULL values never conflict with each other
+		if values[pos] == nil {
+			return "", false
+		}
+		parts[i] = memCanonical(values[pos])
+	}
+	return strings.Join(parts, memKeySep), true
+}
+
+// rowKey returns the primary key of values, a hidden id is allocated if there is no primary key
+func (t *memTable) rowKey(values []interface{}) string {
+	if len(t.primary) == 0 {
+		t.allocID++
+		return fmt.Sprintf("#%d", t.allocID)
+	}
+	key, _ := t.indexKey(values, t.primary)
+	return "p:" + key
+}
+
+// uniqueKeys returns the lock keys of unique indexes which are not NULL
+func (t *memTable) uniqueKeys(values []interface{}) []string {
+	var keys []string
+	for i, unique := range t.uniques {
+		if key, ok := t.indexKey(values, unique); ok {
+			keys = append(keys, fmt.Sprintf("u%d:%s", i, key))
+		}
+	}
+	return keys
+}
+
+// conditions converts where conditions into canonical strings by column positions
+func (t *memTable) conditions(where memWhere) (map[int]string, error) {
+	conds := make(map[int]string, len(where.conds))
+	for _, cond := range where.conds {
+		pos := t.column(cond.column)
+		if pos < 0 {
+			return nil, errors.Errorf("Unknown column '%s' in 'where clause'", cond.column)
+		}
+		if cond.value.null {
+			// col=NULL is never true
+			return nil, nil
+		}
+		column := t.columns[pos]
+		column.notNull = false
+		v, err := column.convert(cond.value)
+		if err != nil {
+			return nil, err
+		}
+		conds[pos] = memCanonical(v)
+	}
+	return conds, nil
+}
+
+// condIndexKey returns the lock key of the index which the conditions exactly cover
+func (t *memTable) condIndexKey(conds map[int]string) string {
+	covered := func(index []int) (string, bool) {
+		if len(index) == 0 || len(index) != len(conds) {
+			return "", false
+		}
+		parts := make([]string, len(index))
+		for i, pos := range index {
+			v, ok := conds[pos]
+			if !ok {
+				return "", false
+			}
+			parts[i] = v
+		}
+		return strings.Join(parts, memKeySep), true
+	}
+	if key, ok := covered(t.primary); ok {
+		return "p:" + key
+	}
+	for i, unique := range t.uniques {
+		if key, ok := covered(unique); ok {
+			return fmt.Sprintf("u%d:%s", i, key)
+		}
+	}
+	return ""
+}
+
+func (t *memTable) match(values []interface{}, conds map[int]string) bool {
+	for pos, v := range conds {
+		if values[pos] == nil || memCanonical(values[pos]) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// row converts literals of a full row
+func (t *memTable) row(literals []memLiteral) ([]interface{}, error) {
+	if len(literals) != len(t.columns) {
+		return nil, errors.New("Column count doesn't match value count at row 1")
+	}
+	values := make([]interface{}, len(literals))
+	for i, lit := range literals {
+		v, err := t.columns[i].convert(lit)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// apply returns a copy of values patched by the assignments
+func (t *memTable) apply(values []interface{}, sets []memCond) ([]interface{}, error) {
+	patched := make([]interface{}, len(values))
+	copy(patched, values)
+	for _, set := range sets {
+		pos := t.column(set.column)
+		if pos < 0 {
+			return nil, errors.Errorf("Unknown column '%s' in 'field list'", set.column)
+		}
+		v, err := t.columns[pos].convert(set.value)
+		if err != nil {
+			return nil, err
+		}
+		patched[pos] = v
+	}
+	return patched, nil
+}
+
+func (t *memTable) result(rows [][]interface{}) *memRows {
+	r := memRows{
+		columns: make([]string, len(t.columns)),
+		types:   make([]string, len(t.columns)),
+		rows:    rows,
+	}
+	for i, column := range t.columns {
+		r.columns[i] = column.name
+		r.types[i] = column.tp
+	}
+	return &r
+}
+
+type memLock struct {
+	exclusive bool
+	owners    map[*memTxn]struct{}
+}
+
+// memStore is a MVCC storage with pessimistic row locks, it follows InnoDB's behavior:
+// plain reads are snapshot reads, locking reads and writes read the latest committed data
+type memStore struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	ts        uint64
+	databases map[string]map[string]*memTable
+	locks     map[string]*memLock
+	// lockWaitTimeout is MEMORY_LOCK_WAIT_TIMEOUT unless it's set by innodb_lock_wait_timeout
+	lockWaitTimeout time.Duration
+	// waits is the wait-for graph used in deadlock detection
+	waits map[*memTxn][]*memTxn
+}
+
+var memStores = struct {
+	sync.Mutex
+	stores map[string]*memStore
+}{stores: make(map[string]*memStore)}
+
+// getMemStore returns the store of given name, stores live until the process exits
+func getMemStore(name string) *memStore {
+	memStores.Lock()
+	defer memStores.Unlock()
+	if s, ok := memStores.stores[name]; ok {
+		return s
+	}
+	s := &memStore{
+		databases:       make(map[string]map[string]*memTable),
+		locks:           make(map[string]*memLock),
+		lockWaitTimeout: MEMORY_LOCK_WAIT_TIMEOUT,
+		waits:           make(map[*memTxn][]*memTxn),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	memStores.stores[name] = s
+	return s
+}
+
+func (s *memStore) begin(isolation sql.IsolationLevel) *memTxn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &memTxn{
+		store:     s,
+		isolation: isolation,
+		snapshot:  s.ts,
+		writes:    make(map[*memTable]map[string][]interface{}),
+	}
+}
+
+func (s *memStore) table(database, name string) (*memTable, error) {
+	if database == "" {
+		return nil, errMemNoDatabase
+	}
+	tables, ok := s.databases[database]
+	if !ok {
+		return nil, errors.Errorf("Unknown database '%s'", database)
+	}
+	t, ok := tables[name]
+	if !ok {
+		return nil, errors.Errorf("Table '%s.%s' doesn't exist", database, name)
+	}
+	return t, nil
+}
+
+// deadlock reports if txn waits for itself
+func (s *memStore) deadlock(txn *memTxn) bool {
+	visited := make(map[*memTxn]struct{})
+	var dfs func(*memTxn) bool
+	dfs = func(t *memTxn) bool {
+		for _, next := range s.waits[t] {
+			if next == txn {
+				return true
+			}
+			if _, ok := visited[next]; ok {
+				continue
+			}
+			visited[next] = struct{}{}
+			if dfs(next) {
+				return true
+			}
+		}
+		return false
+	}
+	return dfs(txn)
+}
+
+// exec runs DDL statements, which are not transactional
+func (s *memStore) exec(database string, stmt interface{}) error {
+	switch stmt := stmt.(type) {
+	case memNoopStmt:
+	case *memSetLockWaitTimeoutStmt:
+		s.lockWaitTimeout = time.Duration(stmt.seconds) * time.Second
+	case *memCreateDatabaseStmt:
+		if _, ok := s.databases[stmt.name]; ok {
+			return errors.Errorf("Can't create database '%s'; database exists", stmt.name)
+		}
+		s.databases[stmt.name] = make(map[string]*memTable)
+	case *memDropDatabaseStmt:
+		if _, ok := s.databases[stmt.name]; !ok && !stmt.ifExists {
+			return errors.Errorf("Can't drop database '%s'; database doesn't exist", stmt.name)
+		}
+		delete(s.databases, stmt.name)
+	case *memCreateTableStmt:
+		if _, err := s.table(database, stmt.name); err == nil {
+			return errors.Errorf("Table '%s' already exists", stmt.name)
+		} else if err == errMemNoDatabase {
+			return err
+		}
+		tables, ok := s.databases[database]
+		if !ok {
+			return errors.Errorf("Unknown database '%s'", database)
+		}
+		t, err := newMemTable(database, stmt)
+		if err != nil {
+			return err
+		}
+		tables[stmt.name] = t
+	case *memDropTableStmt:
+		if _, err := s.table(database, stmt.name); err != nil {
+			if stmt.ifExists && err != errMemNoDatabase {
+				return nil
+			}
+			return err
+		}
+		delete(s.databases[database], stmt.name)
+	default:
+		panic("unreachable")
+	}
+	return nil
+}
+
+type memTxn struct {
+	store     *memStore
+	isolation sql.IsolationLevel
+	snapshot  uint64
+	// writes are the uncommitted rows, nil values means deleted
+	writes map[*memTable]map[string][]interface{}
+	locks  []string
+	done   bool
+	// ctx is the context of running statement
+	ctx context.Context
+}
+
+// get returns the visible values of row, current read sees the latest committed one
+func (t *memTxn) get(tbl *memTable, key string, current bool) []interface{} {
+	if values, ok := t.writes[tbl][key]; ok {
+		return values
+	}
+	ts := t.snapshot
+	if current {
+		ts = t.store.ts
+	}
+	versions := tbl.rows[key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].ts <= ts {
+			return versions[i].values
+		}
+	}
+	return nil
+}
+
+// scan returns the visible rows matching conditions in order of primary key
+func (t *memTxn) scan(tbl *memTable, conds map[int]string, current bool) ([]string, [][]interface{}) {
+	keySet := make(map[string]struct{}, len(tbl.rows))
+	for key := range tbl.rows {
+		keySet[key] = struct{}{}
+	}
+	for key := range t.writes[tbl] {
+		keySet[key] = struct{}{}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var (
+		matchKeys []string
+		rows      [][]interface{}
+	)
+	for _, key := range keys {
+		if values := t.get(tbl, key, current); values != nil && tbl.match(values, conds) {
+			matchKeys = append(matchKeys, key)
+			rows = append(rows, values)
+		}
+	}
+	return matchKeys, rows
+}
+
+func (t *memTxn) write(tbl *memTable, key string, values []interface{}) {
+	if _, ok := t.writes[tbl]; !ok {
+		t.writes[tbl] = make(map[string][]interface{})
+	}
+	t.writes[tbl][key] = values
+}
+
+// lock acquires lock on key and reports if it has waited for other txns
+func (t *memTxn) lock(key string, exclusive bool) (bool, error) {
+	s := t.store
+	var (
+		waited  bool
+		timeout bool
+		timer   *time.Timer
+	)
+	defer func() {
+		delete(s.waits, t)
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		l, ok := s.locks[key]
+		if !ok {
+			l = &memLock{owners: make(map[*memTxn]struct{})}
+			s.locks[key] = l
+		}
+		var blockers []*memTxn
+		if exclusive || l.exclusive {
+			for owner := range l.owners {
+				if owner != t {
+					blockers = append(blockers, owner)
+				}
+			}
+		}
+		if len(blockers) == 0 {
+			if _, ok := l.owners[t]; !ok {
+				l.owners[t] = struct{}{}
+				t.locks = append(t.locks, key)
+			}
+			l.exclusive = l.exclusive || exclusive
+			return waited, nil
+		}
+		s.waits[t] = blockers
+		if s.deadlock(t) {
+			t.rollback()
+			return waited, errMemDeadlock
+		}
+		if timeout {
+			return waited, errMemLockWaitTimeout
+		}
+		if t.ctx != nil && t.ctx.Err() != nil {
+			return waited, t.ctx.Err()
+		}
+		if timer == nil {
+			timer = time.AfterFunc(s.lockWaitTimeout, func() {
+				s.mu.Lock()
+				timeout = true
+				s.cond.Broadcast()
+				s.mu.Unlock()
+			})
+			if t.ctx != nil && t.ctx.Done() != nil {
+				stop := make(chan struct{})
+				defer close(stop)
+				go func(done <-chan struct{}) {
+					select {
+					case <-done:
+						s.mu.Lock()
+						s.cond.Broadcast()
+						s.mu.Unlock()
+					case <-stop:
+					}
+				}(t.ctx.Done())
+			}
+		}
+		waited = true
+		s.cond.Wait()
+	}
+}
+
+func (t *memTxn) lockKeys(tbl *memTable, keys []string, exclusive bool) (bool, error) {
+	waited := false
+	for _, key := range keys {
+		w, err := t.lock(tbl.lockPrefix+key, exclusive)
+		if err != nil {
+			return waited, err
+		}
+		waited = waited || w
+	}
+	return waited, nil
+}
+
+// lockRows locks the index covered by conditions and the matched rows,
+// it retries until the matched rows are stable after locking
+func (t *memTxn) lockRows(tbl *memTable, conds map[int]string, exclusive, withUnique bool) ([]string, [][]interface{}, error) {
+	if key := tbl.condIndexKey(conds); key != "" {
+		if _, err := t.lockKeys(tbl, []string{key}, exclusive); err != nil {
+			return nil, nil, err
+		}
+	}
+	for {
+		keys, rows := t.scan(tbl, conds, true)
+		var lockKeys []string
+		for i, key := range keys {
+			lockKeys = append(lockKeys, key)
+			if withUnique {
+				lockKeys = append(lockKeys, tbl.uniqueKeys(rows[i])...)
+			}
+		}
+		waited, err := t.lockKeys(tbl, lockKeys, exclusive)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !waited {
+			return keys, rows, nil
+		}
+	}
+}
+
+// conflicts returns the rows conflict with values on primary key or unique keys,
+// primary key conflict comes first
+func (t *memTxn) conflicts(tbl *memTable, key string, values []interface{}) ([]string, [][]interface{}) {
+	var (
+		keys []string
+		rows [][]interface{}
+	)
+	if len(tbl.primary) > 0 {
+		if old := t.get(tbl, key, true); old != nil {
+			keys = append(keys, key)
+			rows = append(rows, old)
+		}
+	}
+	for _, unique := range tbl.uniques {
+		uniqueKey, ok := tbl.indexKey(values, unique)
+		if !ok {
+			continue
+		}
+		allKeys, allRows := t.scan(tbl, nil, true)
+	NEXT:
+		for i, k := range allKeys {
+			if other, ok := tbl.indexKey(allRows[i], unique); !ok || other != uniqueKey {
+				continue
+			}
+			for _, exist := range keys {
+				if exist == k {
+					continue NEXT
+				}
+			}
+			keys = append(keys, k)
+			rows = append(rows, allRows[i])
+		}
+	}
+	return keys, rows
+}
+
+// duplicate checks if values conflict with rows other than self
+func (t *memTxn) duplicate(tbl *memTable, key string, values []interface{}, self string) error {
+	if len(tbl.primary) > 0 && key != self && t.get(tbl, key, true) != nil {
+		primaryKey, _ := tbl.indexKey(values, tbl.primary)
+		return errors.Errorf("Duplicate entry '%s' for key 'PRIMARY'", strings.ReplaceAll(primaryKey, memKeySep, "-"))
+	}
+	keys, rows := t.scan(tbl, nil, true)
+	for i, unique := range tbl.uniques {
+		uniqueKey, ok := tbl.indexKey(values, unique)
+		if !ok {
+			continue
+		}
+		for j, k := range keys {
+			if k == self {
+				continue
+			}
+			if other, ok := tbl.indexKey(rows[j], unique); ok && other == uniqueKey {
+				return errors.Errorf("Duplicate entry '%s' for key '%s'", strings.ReplaceAll(uniqueKey, memKeySep, "-"), tbl.uniqueNames[i])
+			}
+		}
+	}
+	return nil
+}
+
+// lockConflicts locks the rows conflict with the new values until they are stable
+func (t *memTxn) lockConflicts(tbl *memTable, key string, values []interface{}) ([]string, [][]interface{}, error) {
+	if _, err := t.lockKeys(tbl, append([]string{key}, tbl.uniqueKeys(values)...), true); err != nil {
+		return nil, nil, err
+	}
+	for {
+		keys, rows := t.conflicts(tbl, key, values)
+		var lockKeys []string
+		for i, k := range keys {
+			lockKeys = append(lockKeys, k)
+			lockKeys = append(lockKeys, tbl.uniqueKeys(rows[i])...)
+		}
+		waited, err := t.lockKeys(tbl, lockKeys, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !waited {
+			return keys, rows, nil
+		}
+	}
+}
+
+// update replaces the locked row with new values and reports if it's changed
+func (t *memTxn) update(tbl *memTable, key string, old, values []interface{}) (bool, error) {
+	newKey := key
+	if len(tbl.primary) > 0 {
+		newKey = tbl.rowKey(values)
+	}
+	if _, err := t.lockKeys(tbl, append([]string{newKey}, tbl.uniqueKeys(values)...), true); err != nil {
+		return false, err
+	}
+	if err := t.duplicate(tbl, newKey, values, key); err != nil {
+		return false, err
+	}
+	changed := newKey != key
+	for i := range values {
+		if memCanonical(old[i]) != memCanonical(values[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if newKey != key {
+		t.write(tbl, key, nil)
+	}
+	t.write(tbl, newKey, values)
+	return true, nil
+}
+
+// run executes DML statement, it returns the result set for select statement and the affected rows for others
+func (t *memTxn) run(database string, stmt interface{}) (*memRows, int64, error) {
+	if t.done {
+		return nil, 0, errors.New("transaction has been rolled back")
+	}
+	s := t.store
+	if t.isolation == sql.LevelReadUncommitted || t.isolation == sql.LevelReadCommitted {
+		// every statement reads the latest snapshot
+		t.snapshot = s.ts
+	}
+	var savepoint = make(map[*memTable]map[string][]interface{}, len(t.writes))
+	for tbl, writes := range t.writes {
+		savepoint[tbl] = make(map[string][]interface{}, len(writes))
+		for key, values := range writes {
+			savepoint[tbl][key] = values
+		}
+	}
+	rows, affected, err := t.runStmt(database, stmt)
+	if err != nil && !t.done {
+		// statement level rollback
+		t.writes = savepoint
+	}
+	return rows, affected, err
+}
+
+func (t *memTxn) runStmt(database string, stmt interface{}) (*memRows, int64, error) {
+	switch stmt := stmt.(type) {
+	case *memSelectStmt:
+		tbl, err := t.store.table(database, stmt.table)
+		if err != nil {
+			return nil, 0, err
+		}
+		conds, err := tbl.conditions(stmt.where)
+		if err != nil || conds == nil || stmt.where.never {
+			return tbl.result(nil), 0, err
+		}
+		var rows [][]interface{}
+		switch {
+		case stmt.forUpdate:
+			_, rows, err = t.lockRows(tbl, conds, true, false)
+		case t.isolation == sql.LevelSerializable || t.isolation == sql.LevelLinearizable:
+			// plain reads are converted to shared locking reads like InnoDB
+			_, rows, err = t.lockRows(tbl, conds, false, false)
+		default:
+			_, rows = t.scan(tbl, conds, false)
+		}
+		return tbl.result(rows), 0, err
+	case *memInsertStmt:
+		tbl, err := t.store.table(database, stmt.table)
+		if err != nil {
+			return nil, 0, err
+		}
+		values, err := tbl.row(stmt.values)
+		if err != nil {
+			return nil, 0, err
+		}
+		key := tbl.rowKey(values)
+		if len(tbl.primary) == 0 && !stmt.replace && stmt.onDup == nil {
+			if err := t.duplicate(tbl, key, values, ""); err != nil {
+				return nil, 0, err
+			}
+			t.write(tbl, key, values)
+			return nil, 1, nil
+		}
+		keys, rows, err := t.lockConflicts(tbl, key, values)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch {
+		case stmt.replace:
+			for _, k := range keys {
+				t.write(tbl, k, nil)
+			}
+			t.write(tbl, key, values)
+			return nil, int64(len(keys) + 1), nil
+		case stmt.onDup != nil && len(keys) > 0:
+			values, err := tbl.apply(rows[0], stmt.onDup)
+			if err != nil {
+				return nil, 0, err
+			}
+			changed, err := t.update(tbl, keys[0], rows[0], values)
+			if err != nil || !changed {
+				return nil, 0, err
+			}
+			return nil, 2, nil
+		default:
+			if err := t.duplicate(tbl, key, values, ""); err != nil {
+				return nil, 0, err
+			}
+			t.write(tbl, key, values)
+			return nil, 1, nil
+		}
+	case *memUpdateStmt:
+		tbl, err := t.store.table(database, stmt.table)
+		if err != nil {
+			return nil, 0, err
+		}
+		conds, err := tbl.conditions(stmt.where)
+		if err != nil || conds == nil || stmt.where.never {
+			return nil, 0, err
+		}
+		keys, rows, err := t.lockRows(tbl, conds, true, true)
+		if err != nil {
+			return nil, 0, err
+		}
+		var affected int64
+		for i, key := range keys {
+			values, err := tbl.apply(rows[i], stmt.sets)
+			if err != nil {
+				return nil, 0, err
+			}
+			changed, err := t.update(tbl, key, rows[i], values)
+			if err != nil {
+				return nil, 0, err
+			}
+			if changed {
+				affected++
+			}
+		}
+		return nil, affected, nil
+	case *memDeleteStmt:
+		tbl, err := t.store.table(database, stmt.table)
+		if err != nil {
+			return nil, 0, err
+		}
+		conds, err := tbl.conditions(stmt.where)
+		if err != nil || conds == nil || stmt.where.never {
+			return nil, 0, err
+		}
+		keys, _, err := t.lockRows(tbl, conds, true, true)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, key := range keys {
+			t.write(tbl, key, nil)
+		}
+		return nil, int64(len(keys)), nil
+	default:
+		return nil, 0, t.store.exec(database, stmt)
+	}
+}
+
+func (t *memTxn) release() {
+	s := t.store
+	for _, key := range t.locks {
+		l := s.locks[key]
+		delete(l.owners, t)
+		if len(l.owners) == 0 {
+			delete(s.locks, key)
+		} else {
+			// exclusive lock has only one owner, the rest are shared
+			l.exclusive = false
+		}
+	}
+	t.locks = nil
+	t.done = true
+	s.cond.Broadcast()
+}
+
+func (t *memTxn) commit() {
+	if t.done {
+		return
+	}
+	s := t.store
+	if len(t.writes) > 0 {
+		s.ts++
+		for tbl, writes := range t.writes {
+			for key, values := range writes {
+				tbl.rows[key] = append(tbl.rows[key], memVersion{ts: s.ts, values: values})
+			}
+		}
+	}
+	t.writes = nil
+	t.release()
+}
+
+func (t *memTxn) rollback() {
+	if t.done {
+		return
+	}
+	t.writes = nil
+	t.release()
+}
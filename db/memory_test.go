@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMemory(t *testing.T, name string) *Memory {
+	m, err := NewMemory(name + "/")
+	require.Nil(t, err)
+	_, err = m.Exec("CREATE DATABASE test")
+	require.Nil(t, err)
+	require.Nil(t, m.Close())
+	m, err = NewMemory(name + "/test")
+	require.Nil(t, err)
+	_, err = m.Exec(`CREATE TABLE t1(
+id INT(11) NOT NULL,
+val VARCHAR(255) NOT NULL,
+k DATE NULL,
+PRIMARY KEY(id),
+UNIQUE u_0(val, k))`)
+	require.Nil(t, err)
+	return m
+}
+
+func queryRows(t *testing.T, rows *sql.Rows, err error) []string {
+	require.Nil(t, err)
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var (
+			id  int64
+			val string
+			k   interface{}
+		)
+		require.Nil(t, rows.Scan(&id, &val, &k))
+		if d, ok := k.(time.Time); ok {
+			k = d.Format(memDateFormat)
+		}
+		result = append(result, strings.Join([]string{memCanonical(id), val, memCanonical(k)}, ","))
+	}
+	return result
+}
+
+func TestMemoryStatements(t *testing.T) {
+	m := newTestMemory(t, "statements")
+	defer m.Close()
+	_, err := m.Exec(`INSERT INTO t1 VALUES(1, 'it''s', '2020-08-31')`)
+	require.Nil(t, err)
+	_, err = m.Exec(`INSERT INTO t1 VALUES(1, 'kaeru', NULL)`)
+	require.Contains(t, err.Error(), "Duplicate entry '1' for key 'PRIMARY'")
+	_, err = m.Exec(`INSERT INTO t1 VALUES(2, 'it''s', '2020-08-31')`)
+	require.Contains(t, err.Error(), "Duplicate entry 'it's-2020-08-31 00:00:00' for key 'u_0'")
+
+	rows, err := m.Query(`SELECT * FROM t1 WHERE val='it\'s' AND k='2020-08-31'`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,it's,2020-08-31"})
+	rows, err = m.Query(`SELECT * FROM t1 WHERE 0`)
+	require.Empty(t, queryRows(t, rows, err))
+
+	_, err = m.Exec(`UPDATE t1 SET val='kaeru', k=NULL WHERE id=1`)
+	require.Nil(t, err)
+	_, err = m.Exec(`INSERT INTO t1 VALUES(1, 'kaeru', '1919-08-10') ON DUPLICATE KEY UPDATE k='1919-08-10'`)
+	require.Nil(t, err)
+	_, err = m.Exec(`REPLACE INTO t1 VALUES(2, 'kaeru', '1919-08-10')`)
+	require.Nil(t, err)
+	rows, err = m.Query(`SELECT * FROM t1 WHERE id=1`)
+	require.Empty(t, queryRows(t, rows, err))
+	rows, err = m.Query(`SELECT * FROM t1 WHERE id=2`)
+	require.Equal(t, queryRows(t, rows, err), []string{"2,kaeru,1919-08-10"})
+
+	_, err = m.Exec(`DELETE FROM t1 WHERE val='kaeru' AND k='1919-08-10'`)
+	require.Nil(t, err)
+	rows, err = m.Query(`SELECT * FROM t1 WHERE id=2`)
+	require.Empty(t, queryRows(t, rows, err))
+
+	_, err = m.Exec(`DROP DATABASE test2`)
+	require.Contains(t, err.Error(), "database doesn't exist")
+}
+
+func TestMemoryIsolation(t *testing.T) {
+	m := newTestMemory(t, "isolation")
+	defer m.Close()
+	_, err := m.Exec(`INSERT INTO t1 VALUES(1, 'kaeru', NULL)`)
+	require.Nil(t, err)
+
+	rr, err := m.Begin(&sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	require.Nil(t, err)
+	rc, err := m.Begin(&sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	require.Nil(t, err)
+	rows, err := rr.Query(`SELECT * FROM t1 WHERE id=1`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,kaeru,NULL"})
+
+	txn, err := m.Begin(nil)
+	require.Nil(t, err)
+	_, err = txn.Exec(`UPDATE t1 SET val='kawazu' WHERE id=1`)
+	require.Nil(t, err)
+	// uncommitted write is invisible
+	rows, err = rc.Query(`SELECT * FROM t1 WHERE id=1`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,kaeru,NULL"})
+	require.Nil(t, txn.Commit())
+
+	// snapshot read and statement snapshot read
+	rows, err = rr.Query(`SELECT * FROM t1 WHERE id=1`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,kaeru,NULL"})
+	rows, err = rc.Query(`SELECT * FROM t1 WHERE id=1`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,kawazu,NULL"})
+	// locking read sees the latest committed data
+	rows, err = rr.Query(`SELECT * FROM t1 WHERE id=1 FOR UPDATE`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,kawazu,NULL"})
+	require.Nil(t, rr.Commit())
+	require.Nil(t, rc.Commit())
+}
+
+func TestMemoryDeadlock(t *testing.T) {
+	m := newTestMemory(t, "deadlock")
+	defer m.Close()
+	_, err := m.Exec(`INSERT INTO t1 VALUES(1, 'kaeru', NULL)`)
+	require.Nil(t, err)
+	_, err = m.Exec(`INSERT INTO t1 VALUES(2, 'kawazu', NULL)`)
+	require.Nil(t, err)
+
+	txn1, err := m.Begin(nil)
+	require.Nil(t, err)
+	txn2, err := m.Begin(nil)
+	require.Nil(t, err)
+	_, err = txn1.Exec(`UPDATE t1 SET k='2020-08-31' WHERE id=1`)
+	require.Nil(t, err)
+	_, err = txn2.Exec(`UPDATE t1 SET k='2020-08-31' WHERE id=2`)
+	require.Nil(t, err)
+
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := txn1.Exec(`DELETE FROM t1 WHERE id=2`)
+		blocked <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-blocked:
+		t.Fatal("txn1 should be blocked by txn2")
+	default:
+	}
+	_, err = txn2.Exec(`DELETE FROM t1 WHERE val='kaeru' AND k='2020-08-31'`)
+	require.Contains(t, err.Error(), "Deadlock")
+	require.Equal(t, ErrorCode(err), "1213")
+	// txn2 is rolled back, so txn1 gets the lock
+	require.Nil(t, <-blocked)
+	require.Nil(t, txn2.Rollback())
+	require.Nil(t, txn1.Commit())
+
+	rows, err := m.Query(`SELECT * FROM t1 WHERE id=1`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,kaeru,2020-08-31"})
+	rows, err = m.Query(`SELECT * FROM t1 WHERE id=2`)
+	require.Empty(t, queryRows(t, rows, err))
+}
+
+func TestMemoryStatementTimeout(t *testing.T) {
+	m := newTestMemory(t, "timeout")
+	defer m.Close()
+	_, err := m.Exec(`INSERT INTO t1 VALUES(1, 'kaeru', NULL)`)
+	require.Nil(t, err)
+
+	txn1, err := m.Begin(nil)
+	require.Nil(t, err)
+	txn2, err := m.Begin(nil)
+	require.Nil(t, err)
+	_, err = txn1.Exec(`UPDATE t1 SET val='kawazu' WHERE id=1`)
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = txn2.ExecContext(ctx, `DELETE FROM t1 WHERE id=1`)
+	require.Equal(t, errors.Cause(err), context.DeadlineExceeded)
+	require.Nil(t, txn1.Commit())
+	require.Nil(t, txn2.Rollback())
+
+	rows, err := m.Query(`SELECT * FROM t1 WHERE id=1`)
+	require.Equal(t, queryRows(t, rows, err), []string{"1,kawazu,NULL"})
+}
+
+func TestMemoryLockWaitTimeout(t *testing.T) {
+	m := newTestMemory(t, "lock-wait-timeout")
+	defer m.Close()
+	_, err := m.Exec("SET GLOBAL innodb_lock_wait_timeout = 1")
+	require.Nil(t, err)
+	_, err = m.Exec(`INSERT INTO t1 VALUES(1, 'kaeru', NULL)`)
+	require.Nil(t, err)
+
+	txn1, err := m.Begin(nil)
+	require.Nil(t, err)
+	txn2, err := m.Begin(nil)
+	require.Nil(t, err)
+	_, err = txn1.Exec(`UPDATE t1 SET val='kawazu' WHERE id=1`)
+	require.Nil(t, err)
+	start := time.Now()
+	_, err = txn2.Exec(`DELETE FROM t1 WHERE id=1`)
+	require.Equal(t, ErrorCode(err), "1205")
+	require.True(t, time.Since(start) < MEMORY_LOCK_WAIT_TIMEOUT)
+	require.Nil(t, txn2.Rollback())
+	require.Nil(t, txn1.Commit())
+
+	_, err = m.Exec("SET innodb_lock_wait_timeout = 0")
+	require.Error(t, err)
+}
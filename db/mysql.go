@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -27,8 +28,8 @@ func NewMySQL(dsn string) (*MySQL, error) {
 	}, nil
 }
 
-func (m *MySQL) Begin() (Txn, error) {
-	txn, err := m.db.Begin()
+func (m *MySQL) Begin(opts *sql.TxOptions) (Txn, error) {
+	txn, err := m.db.BeginTx(context.Background(), opts)
 	return &MySQLTxn{txn}, errors.Trace(err)
 }
 
@@ -47,12 +48,20 @@ func (m *MySQL) Query(sql string) (*sql.Rows, error) {
 }
 
 func (m *MySQLTxn) Exec(sql string) (*sql.Result, error) {
-	r, err := m.txn.Exec(sql)
-	return &r, errors.Trace(err)
+	return m.ExecContext(context.Background(), sql)
 }
 
 func (m *MySQLTxn) Query(sql string) (*sql.Rows, error) {
-	r, err := m.txn.Query(sql)
+	return m.QueryContext(context.Background(), sql)
+}
+
+func (m *MySQLTxn) ExecContext(ctx context.Context, sql string) (*sql.Result, error) {
+	r, err := m.txn.ExecContext(ctx, sql)
+	return &r, errors.Trace(err)
+}
+
+func (m *MySQLTxn) QueryContext(ctx context.Context, sql string) (*sql.Rows, error) {
+	r, err := m.txn.QueryContext(ctx, sql)
 	return r, err
 }
 
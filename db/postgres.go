@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/juju/errors"
+	_ "github.com/lib/pq"
+)
+
+type Postgres struct {
+	dsn string
+	db  *sql.DB
+}
+
+type PostgresTxn struct {
+	txn *sql.Tx
+}
+
+func NewPostgres(dsn string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Postgres{
+		dsn: dsn,
+		db:  db,
+	}, nil
+}
+
+// PostgresDSN returns the dsn which connects to the given database,
+// both URL and key/value connection strings are supported
+func PostgresDSN(dsn, database string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			u.Path = "/" + database
+			return u.String()
+		}
+	}
+	return fmt.Sprintf("%s dbname=%s", strings.TrimSpace(dsn), database)
+}
+
+func (p *Postgres) Begin(opts *sql.TxOptions) (Txn, error) {
+	txn, err := p.db.BeginTx(context.Background(), opts)
+	return &PostgresTxn{txn}, errors.Trace(err)
+}
+
+func (p *Postgres) Close() error {
+	return errors.Trace(p.db.Close())
+}
+
+func (p *Postgres) Exec(sql string) (*sql.Result, error) {
+	r, err := p.db.Exec(sql)
+	return &r, errors.Trace(err)
+}
+
+func (p *Postgres) Query(sql string) (*sql.Rows, error) {
+	r, err := p.db.Query(sql)
+	return r, errors.Trace(err)
+}
+
+func (p *PostgresTxn) Exec(sql string) (*sql.Result, error) {
+	return p.ExecContext(context.Background(), sql)
+}
+
+func (p *PostgresTxn) Query(sql string) (*sql.Rows, error) {
+	return p.QueryContext(context.Background(), sql)
+}
+
+func (p *PostgresTxn) ExecContext(ctx context.Context, sql string) (*sql.Result, error) {
+	r, err := p.txn.ExecContext(ctx, sql)
+	return &r, errors.Trace(err)
+}
+
+func (p *PostgresTxn) QueryContext(ctx context.Context, sql string) (*sql.Rows, error) {
+	r, err := p.txn.QueryContext(ctx, sql)
+	return r, err
+}
+
+func (p *PostgresTxn) Commit() error {
+	return errors.Trace(p.txn.Commit())
+}
+
+func (p *PostgresTxn) Rollback() error {
+	return errors.Trace(p.txn.Rollback())
+}
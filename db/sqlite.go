@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// SQLITE_MEMORY is the dsn of an in-memory database
+	SQLITE_MEMORY = ":memory:"
+	// SQLITE_BUSY_TIMEOUT is how long in milliseconds a writer waits for the database lock
+	SQLITE_BUSY_TIMEOUT = 1000
+)
+
+type SQLite struct {
+	dsn string
+	db  *sql.DB
+	// tmp is the file backs ":memory:", removed when closing
+	tmp string
+}
+
+type SQLiteTxn struct {
+	txn *sql.Tx
+}
+
+// NewSQLite opens the database file in WAL mode, so that readers and the writer do not block each other.
+// A ":memory:" database is backed by a temporary file, because each connection of
+// an in-memory database sees its own data, and shared cache mode locks tables instead of the database.
+func NewSQLite(dsn string) (*SQLite, error) {
+	var tmp string
+	if dsn == SQLITE_MEMORY {
+		f, err := ioutil.TempFile("", "mikadzuki-*.db")
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tmp = f.Name()
+		if err := f.Close(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		dsn = tmp
+	}
+	db, err := sql.Open("sqlite3", SQLiteDSN(dsn))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &SQLite{
+		dsn: dsn,
+		db:  db,
+		tmp: tmp,
+	}, nil
+}
+
+// SQLiteDSN appends the busy timeout and journal mode to dsn
+func SQLiteDSN(dsn string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_busy_timeout=%d&_journal_mode=WAL", dsn, sep, SQLITE_BUSY_TIMEOUT)
+}
+
+// Begin ignores the isolation level, SQLite transactions are always serializable.
+// The read snapshot is taken by the first read in deferred transaction,
+// read the schema so that the snapshot starts at BEGIN like the other databases.
+func (s *SQLite) Begin(opts *sql.TxOptions) (Txn, error) {
+	txn, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var count int
+	if err := txn.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&count); err != nil {
+		_ = txn.Rollback()
+		return nil, errors.Trace(err)
+	}
+	return &SQLiteTxn{txn}, nil
+}
+
+func (s *SQLite) Close() error {
+	if err := s.db.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	if s.tmp != "" {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Remove(s.tmp + suffix); err != nil && !os.IsNotExist(err) {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQLite) Exec(sql string) (*sql.Result, error) {
+	r, err := s.db.Exec(sql)
+	return &r, errors.Trace(err)
+}
+
+func (s *SQLite) Query(sql string) (*sql.Rows, error) {
+	r, err := s.db.Query(sql)
+	return r, errors.Trace(err)
+}
+
+func (s *SQLiteTxn) Exec(sql string) (*sql.Result, error) {
+	return s.ExecContext(context.Background(), sql)
+}
+
+func (s *SQLiteTxn) Query(sql string) (*sql.Rows, error) {
+	return s.QueryContext(context.Background(), sql)
+}
+
+func (s *SQLiteTxn) ExecContext(ctx context.Context, sql string) (*sql.Result, error) {
+	r, err := s.txn.ExecContext(ctx, sql)
+	return &r, errors.Trace(err)
+}
+
+func (s *SQLiteTxn) QueryContext(ctx context.Context, sql string) (*sql.Rows, error) {
+	r, err := s.txn.QueryContext(ctx, sql)
+	return r, err
+}
+
+func (s *SQLiteTxn) Commit() error {
+	return errors.Trace(s.txn.Commit())
+}
+
+func (s *SQLiteTxn) Rollback() error {
+	return errors.Trace(s.txn.Rollback())
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	require.Equal(t, "a.db?_busy_timeout=1000&_journal_mode=WAL", SQLiteDSN("a.db"))
+	require.Equal(t, "a.db?cache=private&_busy_timeout=1000&_journal_mode=WAL", SQLiteDSN("a.db?cache=private"))
+}
+
+func TestSQLiteSnapshotBusy(t *testing.T) {
+	s, err := NewSQLite(SQLITE_MEMORY)
+	require.Nil(t, err)
+	_, err = s.Exec("CREATE TABLE t1(id INT NOT NULL, val VARCHAR(255) NOT NULL, PRIMARY KEY(id))")
+	require.Nil(t, err)
+	_, err = s.Exec("INSERT INTO t1 VALUES(1, 'a')")
+	require.Nil(t, err)
+
+	txn1, err := s.Begin(nil)
+	require.Nil(t, err)
+	txn2, err := s.Begin(nil)
+	require.Nil(t, err)
+	_, err = txn1.Exec("UPDATE t1 SET val='b' WHERE id=1")
+	require.Nil(t, err)
+	require.Nil(t, txn1.Commit())
+
+	// the snapshot of txn2 is taken at begin
+	rows, err := txn2.Query("SELECT val FROM t1 WHERE id=1")
+	require.Nil(t, err)
+	require.True(t, rows.Next())
+	var val string
+	require.Nil(t, rows.Scan(&val))
+	require.Equal(t, "a", val)
+	require.Nil(t, rows.Close())
+	// writing on the stale snapshot fails
+	_, err = txn2.Exec("UPDATE t1 SET val='c' WHERE id=1")
+	require.NotNil(t, err)
+	require.True(t, strings.Contains(err.Error(), "database is locked"))
+	require.Equal(t, ErrorCode(err), "517")
+	require.Nil(t, txn2.Rollback())
+
+	file := s.tmp
+	require.Nil(t, s.Close())
+	_, err = os.Stat(file)
+	require.True(t, os.IsNotExist(err))
+}
@@ -4,10 +4,11 @@ go 1.15
 
 require (
 	github.com/BurntSushi/toml v0.3.1
-	github.com/docker/docker v1.13.1
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/juju/errors v0.0.0-20200330140219-3fe23663418f
 	github.com/juju/testing v0.0.0-20200706033705-4c23f9c453cd // indirect
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/ngaut/log v0.0.0-20180314031856-b8e36e7ba5ac
 	github.com/pkg/errors v0.8.0
 	github.com/spf13/cobra v1.0.0
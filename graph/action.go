@@ -18,7 +18,10 @@ type Action struct {
 	outs        []Depend
 	ins         []Depend
 	beforeWrite Depend
-	kvNext      *Depend
+	// beforeLock is the last write before this action, which holds the lock,
+	// it's different from beforeWrite when the write is rolled back
+	beforeLock Depend
+	kvNext     *Depend
 	// key id, when it's -1, it means the key is not specified yet
 	kID int
 	// value id, can find out value from kv.Schema
@@ -33,6 +36,8 @@ type Action struct {
 	// 2: executed but not returned
 	// 3: value returned, exec done, can set next action to ready state
 	phase int64
+	// execEvent is fired when the phase reaches 2
+	execEvent *event
 	// anomaly fields
 	ExpectedErrorMsg string
 	abortOther       bool
@@ -117,6 +122,7 @@ func NewAction(id, tID, xID int, tp ActionTp) Action {
 		outs:         []Depend{},
 		ins:          []Depend{},
 		beforeWrite:  INVALID_DEPEND,
+		beforeLock:   INVALID_DEPEND,
 		knowValue:    false,
 		vID:          kv.INVALID_VALUE_ID,
 		SQL:          "",
@@ -264,6 +270,24 @@ func DependTpFromActionTps(t1, t2 ActionTp) DependTp {
 	panic(fmt.Sprintf("unsuppert ActionTp %s, %s", t1, t2))
 }
 
+func (a *Action) ID() int {
+	return a.id
+}
+
+func (a *Action) Tp() ActionTp {
+	return a.tp
+}
+
+// KID returns the key id of the action
+func (a *Action) KID() int {
+	return a.kID
+}
+
+// VID returns the value id which is written or expected to be read
+func (a *Action) VID() int {
+	return a.vID
+}
+
 func (a *Action) SetReady() {
 	atomic.StoreInt64(&a.phase, 1)
 }
@@ -274,6 +298,7 @@ func (a *Action) GetReady() bool {
 
 func (a *Action) SetExec() {
 	atomic.StoreInt64(&a.phase, 2)
+	a.execEvent.fire()
 }
 
 func (a *Action) GetExec() bool {
@@ -282,6 +307,7 @@ func (a *Action) GetExec() bool {
 
 func (a *Action) SetDone() {
 	atomic.StoreInt64(&a.phase, 3)
+	a.execEvent.fire()
 }
 
 func (a *Action) GetDone() bool {
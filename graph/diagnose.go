@@ -0,0 +1,136 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workerState is what a worker of IterateGraph is blocked on
+type workerState struct {
+	doing string
+	// action is the executing action, nil if the worker is waiting or executing txn statements
+	action *Action
+	// waiting reports if the worker waits for an event of owner timeline, or it's executing a statement
+	waiting bool
+	owner   int
+	since   time.Time
+}
+
+// workerStates are updated by the workers during execution, for diagnosing the hangs
+type workerStates struct {
+	sync.Mutex
+	states []workerState
+	done   []bool
+	// changes is the number of state changes, the workers make no progress if it's not changed
+	changes int64
+}
+
+func newWorkerStates(n int) *workerStates {
+	return &workerStates{
+		states: make([]workerState, n),
+		done:   make([]bool, n),
+	}
+}
+
+func (w *workerStates) exec(i int, doing string, action *Action) {
+	w.Lock()
+	w.states[i] = workerState{doing: doing, action: action, since: time.Now()}
+	w.changes++
+	w.Unlock()
+}
+
+func (w *workerStates) wait(i int, doing string, owner int) {
+	w.Lock()
+	w.states[i] = workerState{doing: doing, waiting: true, owner: owner, since: time.Now()}
+	w.changes++
+	w.Unlock()
+}
+
+func (w *workerStates) clear(i int) {
+	w.Lock()
+	w.states[i] = workerState{}
+	w.changes++
+	w.Unlock()
+}
+
+func (w *workerStates) finish(i int) {
+	w.Lock()
+	w.states[i] = workerState{}
+	w.done[i] = true
+	w.changes++
+	w.Unlock()
+}
+
+// snapshot returns the copy of states
+func (w *workerStates) snapshot() ([]workerState, []bool, int64) {
+	w.Lock()
+	defer w.Unlock()
+	return append([]workerState{}, w.states...), append([]bool{}, w.done...), w.changes
+}
+
+// Diagnose reports what each timeline is blocked on during IterateGraph,
+// and the executed lock actions of unfinished txns on the keys of the executing actions,
+// which may block the executing ones
+func (g *Graph) Diagnose() string {
+	w := g.workers
+	if w == nil {
+		return "the graph is not executing\n"
+	}
+	states, done, _ := w.snapshot()
+	return g.describeWorkers(states, done)
+}
+
+func (g *Graph) describeWorkers(states []workerState, done []bool) string {
+	var b strings.Builder
+	for i := range states {
+		fmt.Fprintf(&b, "timeline %d: %s\n", i, describeWorker(states, done, i))
+	}
+	for _, state := range states {
+		if state.action == nil || state.action.kID < 0 {
+			continue
+		}
+		holders := g.lockHolders(state.action)
+		if len(holders) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "lock holders of (%d, %d, %d) on key %d:\n", state.action.tID, state.action.xID, state.action.id, state.action.kID)
+		for _, holder := range holders {
+			fmt.Fprintf(&b, "  (%d, %d, %d) %s\n", holder.tID, holder.xID, holder.id, holder.SQL)
+		}
+	}
+	return b.String()
+}
+
+func describeWorker(states []workerState, done []bool, i int) string {
+	switch {
+	case done[i]:
+		return "done"
+	case states[i].doing == "":
+		return "running"
+	default:
+		return fmt.Sprintf("%s for %s", states[i].doing, time.Since(states[i].since).Round(time.Millisecond))
+	}
+}
+
+// lockHolders returns the executed lock actions on the key of action in the other unfinished txns
+func (g *Graph) lockHolders(action *Action) []*Action {
+	var holders []*Action
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			if (txn.tID == action.tID && txn.id == action.xID) || !txn.GetStart() || txn.GetEnd() {
+				continue
+			}
+			for k := 0; k < txn.allocID; k++ {
+				a := txn.GetAction(k)
+				if a.kID == action.kID && a.GetExec() && g.isLock(a) {
+					holders = append(holders, a)
+				}
+			}
+		}
+	}
+	return holders
+}
@@ -0,0 +1,105 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WAIT_REPORT_TIME is the interval of reporting the long waits in execution
+const WAIT_REPORT_TIME = 2 * time.Second
+
+// event is a phase transition in execution, the waiters are woken up when it's fired,
+// firing a fired event does nothing
+type event struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newEvent() *event {
+	return &event{ch: make(chan struct{})}
+}
+
+// newEventIf creates an event which is fired if the transition has happened
+func newEventIf(fired bool) *event {
+	e := newEvent()
+	if fired {
+		e.fire()
+	}
+	return e
+}
+
+// fire does nothing on nil event, the phases are set without events before execution
+func (e *event) fire() {
+	if e == nil {
+		return
+	}
+	e.once.Do(func() {
+		close(e.ch)
+	})
+}
+
+// wait blocks until the event is fired, report is called every WAIT_REPORT_TIME during waiting,
+// it returns false if ctx is done before
+func (e *event) wait(ctx context.Context, report func()) bool {
+	select {
+	case <-e.ch:
+		return true
+	default:
+	}
+	ticker := time.NewTicker(WAIT_REPORT_TIME)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.ch:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			report()
+		}
+	}
+}
+
+// every calls f every interval until stop is called, no goroutine is taken between the calls
+func every(interval time.Duration, f func()) (stop func()) {
+	var (
+		mu      sync.Mutex
+		timer   *time.Timer
+		stopped bool
+	)
+	mu.Lock()
+	defer mu.Unlock()
+	timer = time.AfterFunc(interval, func() {
+		f()
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			timer.Reset(interval)
+		}
+	})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		timer.Stop()
+	}
+}
+
+// initEvents creates the events of txns and actions before execution, the happened transitions are fired
+func (g *Graph) initEvents() {
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			txn.Lock()
+			txn.startEvent = newEventIf(txn.ifStart)
+			txn.endEvent = newEventIf(txn.ifEnd)
+			txn.Unlock()
+			for k := 0; k < txn.allocID; k++ {
+				action := txn.GetAction(k)
+				action.execEvent = newEventIf(action.GetExec())
+			}
+		}
+	}
+}
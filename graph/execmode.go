@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+// ExecMode is how the database locks when writing
+type ExecMode int
+
+const (
+	// RowLock databases block the conflict writes until the lock holder ends
+	RowLock ExecMode = iota
+	// DatabaseLock databases allow only one writer at a time,
+	// the other writers wait for a while and then fail with busy error, the failed txn is aborted
+	DatabaseLock
+)
+
+// ExecModeOf returns the execution mode of target
+func ExecModeOf(target string) ExecMode {
+	if target == kv.TARGET_SQLITE {
+		return DatabaseLock
+	}
+	return RowLock
+}
+
+// abortsTxn reports if err aborts the whole txn wherever it happens, the later actions are fixed up by Abort,
+// they are the busy errors of database lock, and the serialization failures of snapshot isolation,
+// e.g. PostgreSQL's repeatable read fails the write on the value committed after the txn begins
+func (g *Graph) abortsTxn(err error) bool {
+	if g.execMode.IsBusy(g.expectedError, err) {
+		return true
+	}
+	class, ok := g.expectedError.Match(err)
+	return ok && class == SerializationFailure
+}
+
+// IsBusy checks if err is the expected abort caused by database lock, which is the Busy error of target
+func (e ExecMode) IsBusy(expected ExpectedError, err error) bool {
+	class, ok := expected.Match(err)
+	return e == DatabaseLock && ok && class == Busy
+}
@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/you06/go-mikadzuki/db"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+// ErrorClass is the kind of error which interrupts a cycle dependency
+type ErrorClass string
+
+var (
+	Deadlock             ErrorClass = "Deadlock"
+	LockWaitTimeout      ErrorClass = "LockWaitTimeout"
+	WriteConflict        ErrorClass = "WriteConflict"
+	SerializationFailure ErrorClass = "SerializationFailure"
+	Busy                 ErrorClass = "Busy"
+)
+
+// ExpectedError is the errors of a target that can be expected by the anomaly cycles,
+// the errors are recognized by the codes reported by database drivers
+type ExpectedError struct {
+	codes map[string]ErrorClass
+}
+
+// ExpectedErrorOf returns the expected errors of target
+func ExpectedErrorOf(target string) ExpectedError {
+	var codes map[string]ErrorClass
+	switch target {
+	case kv.TARGET_MYSQL, kv.TARGET_MEMORY:
+		codes = map[string]ErrorClass{
+			"1213": Deadlock,
+			"1205": LockWaitTimeout,
+		}
+	case kv.TARGET_TIDB:
+		codes = map[string]ErrorClass{
+			"1213": Deadlock,
+			"1205": LockWaitTimeout,
+			// write conflict and txn retry error in optimistic mode
+			"9007": WriteConflict,
+			"8002": WriteConflict,
+		}
+	case kv.TARGET_POSTGRES:
+		codes = map[string]ErrorClass{
+			"40P01": Deadlock,
+			"40001": SerializationFailure,
+		}
+	case kv.TARGET_SQLITE:
+		// SQLITE_BUSY and its extended codes, the writer fails to get the database lock
+		codes = map[string]ErrorClass{
+			"5":   Busy,
+			"261": Busy,
+			"517": Busy,
+			"773": Busy,
+		}
+	}
+	return ExpectedError{codes: codes}
+}
+
+// Match returns the class of err if it's expected
+func (e ExpectedError) Match(err error) (ErrorClass, bool) {
+	if err == nil {
+		return "", false
+	}
+	class, ok := e.codes[db.ErrorCode(err)]
+	return class, ok
+}
+
+func (e ExpectedError) String() string {
+	items := make([]string, 0, len(e.codes))
+	for code, class := range e.codes {
+		items = append(items, fmt.Sprintf("%s(%s)", class, code))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,241 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// export formats of graph
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// exportEdge is an edge between the nodes of actions, begins and ends of txns
+type exportEdge struct {
+	from  string
+	to    string
+	label string
+	// order edges connect the statements in a txn, they are not dependencies
+	order bool
+	// block edges are from the lock which blocks an anomaly action
+	block bool
+}
+
+// Export renders the graph in format, timelines are swimlanes and dependencies are labelled edges
+func (g *Graph) Export(format string) (string, error) {
+	switch format {
+	case FormatDOT:
+		return g.DOT(), nil
+	case FormatMermaid:
+		return g.Mermaid(), nil
+	default:
+		return "", errors.Errorf("unsupported export format %s", format)
+	}
+}
+
+// DOT renders the graph in Graphviz DOT language
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph mikadzuki {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		fmt.Fprintf(&b, "  subgraph cluster_t%d {\n", i)
+		fmt.Fprintf(&b, "    label=\"timeline %d (%s)\";\n", i, timeline.Isolation())
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			fmt.Fprintf(&b, "    %s [label=\"Begin[%d, %d]\", shape=ellipse];\n", beginNode(i, j), i, j)
+			for k := 0; k < txn.allocID; k++ {
+				action := txn.GetAction(k)
+				var attrs []string
+				if action.cycle != nil {
+					attrs = append(attrs, "color=red", "penwidth=2")
+				}
+				if action.mayAbortSelf {
+					attrs = append(attrs, "style=filled", "fillcolor=orange")
+				}
+				fmt.Fprintf(&b, "    %s [label=\"%s\"", actionNode(i, j, k), exportLabel(action))
+				for _, attr := range attrs {
+					fmt.Fprintf(&b, ", %s", attr)
+				}
+				b.WriteString("];\n")
+			}
+			fmt.Fprintf(&b, "    %s [label=\"%s\", shape=ellipse];\n", endNode(i, j), txnEndLabel(txn))
+		}
+		b.WriteString("  }\n")
+	}
+	for _, edge := range g.exportEdges() {
+		switch {
+		case edge.order:
+			fmt.Fprintf(&b, "  %s -> %s [weight=10];\n", edge.from, edge.to)
+		case edge.block:
+			fmt.Fprintf(&b, "  %s -> %s [label=\"%s\", style=dashed, color=red];\n", edge.from, edge.to, edge.label)
+		default:
+			fmt.Fprintf(&b, "  %s -> %s [label=\"%s\", color=%s, constraint=false];\n", edge.from, edge.to, edge.label, dependColor(DependTp(edge.label)))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart
+func (g *Graph) Mermaid() string {
+	var (
+		b          strings.Builder
+		cycleNodes []string
+		abortNodes []string
+	)
+	b.WriteString("flowchart TB\n")
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		fmt.Fprintf(&b, "  subgraph t%d [\"timeline %d (%s)\"]\n", i, i, timeline.Isolation())
+		b.WriteString("    direction TB\n")
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			fmt.Fprintf(&b, "    %s([\"Begin[%d, %d]\"])\n", beginNode(i, j), i, j)
+			for k := 0; k < txn.allocID; k++ {
+				action := txn.GetAction(k)
+				node := actionNode(i, j, k)
+				fmt.Fprintf(&b, "    %s[\"%s\"]\n", node, exportLabel(action))
+				if action.cycle != nil {
+					cycleNodes = append(cycleNodes, node)
+				}
+				if action.mayAbortSelf {
+					abortNodes = append(abortNodes, node)
+				}
+			}
+			fmt.Fprintf(&b, "    %s([\"%s\"])\n", endNode(i, j), txnEndLabel(txn))
+		}
+		b.WriteString("  end\n")
+	}
+	for _, edge := range g.exportEdges() {
+		switch {
+		case edge.order:
+			fmt.Fprintf(&b, "  %s --> %s\n", edge.from, edge.to)
+		case edge.block:
+			fmt.Fprintf(&b, "  %s -. %s .-> %s\n", edge.from, edge.label, edge.to)
+		default:
+			fmt.Fprintf(&b, "  %s -- %s --> %s\n", edge.from, edge.label, edge.to)
+		}
+	}
+	b.WriteString("  classDef cycle stroke:#f00,stroke-width:3px\n")
+	b.WriteString("  classDef mayAbortSelf fill:#fa0\n")
+	if len(cycleNodes) > 0 {
+		fmt.Fprintf(&b, "  class %s cycle\n", strings.Join(cycleNodes, ","))
+	}
+	if len(abortNodes) > 0 {
+		fmt.Fprintf(&b, "  class %s mayAbortSelf\n", strings.Join(abortNodes, ","))
+	}
+	return b.String()
+}
+
+// exportEdges lists the order edges in txns, the dependencies between actions and txns
+func (g *Graph) exportEdges() []exportEdge {
+	var edges []exportEdge
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			prev := beginNode(i, j)
+			if j > 0 {
+				edges = append(edges, exportEdge{from: endNode(i, j-1), to: prev, order: true})
+			}
+			for k := 0; k < txn.allocID; k++ {
+				node := actionNode(i, j, k)
+				edges = append(edges, exportEdge{from: prev, to: node, order: true})
+				prev = node
+			}
+			edges = append(edges, exportEdge{from: prev, to: endNode(i, j), order: true})
+		}
+	}
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			for k := 0; k < txn.allocID; k++ {
+				action := txn.GetAction(k)
+				for _, d := range action.outs {
+					edges = append(edges, exportEdge{
+						from:  actionNode(i, j, k),
+						to:    actionNode(d.tID, d.xID, d.aID),
+						label: string(d.tp),
+					})
+				}
+				if action.abortBlock != nil {
+					d := action.abortBlock
+					edges = append(edges, exportEdge{
+						from:  actionNode(d.tID, d.xID, d.aID),
+						to:    actionNode(i, j, k),
+						label: "Block",
+						block: true,
+					})
+				}
+			}
+			for _, d := range txn.startOuts {
+				edges = append(edges, g.txnEdge(beginNode(i, j), d))
+			}
+			for _, d := range txn.endOuts {
+				edges = append(edges, g.txnEdge(endNode(i, j), d))
+			}
+		}
+	}
+	return edges
+}
+
+// txnEdge connects the begin or end of the depended txn, the same way as ConnectTxn
+func (g *Graph) txnEdge(from string, d Depend) exportEdge {
+	to := endNode(d.tID, d.xID)
+	if g.toBegin(d.tID, d.tp) {
+		to = beginNode(d.tID, d.xID)
+	}
+	return exportEdge{from: from, to: to, label: string(d.tp)}
+}
+
+func beginNode(tID, xID int) string {
+	return fmt.Sprintf("t%d_x%d_begin", tID, xID)
+}
+
+func endNode(tID, xID int) string {
+	return fmt.Sprintf("t%d_x%d_end", tID, xID)
+}
+
+func actionNode(tID, xID, aID int) string {
+	return fmt.Sprintf("t%d_x%d_a%d", tID, xID, aID)
+}
+
+// exportLabel is the same as Action.String without dependencies
+func exportLabel(action *Action) string {
+	label := fmt.Sprintf("(%d)%s(%d, %d)", action.id, action.tp, action.kID, action.vID)
+	if action.mayAbortSelf {
+		label = "*" + label
+	}
+	return label
+}
+
+func txnEndLabel(txn *Txn) string {
+	switch txn.status {
+	case Committed:
+		return "Commit"
+	case Rollbacked:
+		return "Rollback"
+	default:
+		return "Abort"
+	}
+}
+
+func dependColor(tp DependTp) string {
+	switch tp {
+	case WW:
+		return "blue"
+	case WR:
+		return "darkgreen"
+	case RW:
+		return "purple"
+	default:
+		return "gray"
+	}
+}
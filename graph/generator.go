@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"math/rand"
 
+	"github.com/juju/errors"
 	"github.com/you06/go-mikadzuki/config"
 	"github.com/you06/go-mikadzuki/kv"
+	"github.com/you06/go-mikadzuki/util"
 )
 
 type Generator struct {
@@ -18,9 +20,15 @@ type Generator struct {
 	dependMap    map[DependTp]int
 	dependSum    int
 	kvManager    *kv.Manager
+	dialect      kv.Dialect
+	// seed of the next graph, increased after each graph is generated
+	seed int64
+	rd   *rand.Rand
 }
 
+// NewGenerator uses the seed from global config, or current time if it's 0
 func NewGenerator(kvManager *kv.Manager, cfg *config.Config) Generator {
+	_, seed := util.NewRand(cfg.Global.Seed)
 	generator := Generator{
 		cfg:          cfg,
 		globalConfig: &cfg.Global,
@@ -29,6 +37,8 @@ func NewGenerator(kvManager *kv.Manager, cfg *config.Config) Generator {
 		graphSum:     0,
 		dependSum:    0,
 		kvManager:    kvManager,
+		dialect:      kv.NewDialect(cfg.Global.Target),
+		seed:         seed,
 	}
 	generator.CalcGraphSum()
 	generator.CalcDependSum()
@@ -57,10 +67,15 @@ func (g *Generator) CalcDependSum() {
 	}
 }
 
+// Seed returns the seed of the next graph
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
 func (g *Generator) randActionTp() ActionTp {
-	rd := rand.Intn(g.graphSum)
-	for tp, v := range g.graphMap {
-		rd -= v
+	rd := g.rd.Intn(g.graphSum)
+	for _, tp := range actionTps {
+		rd -= g.graphMap[tp]
 		if rd < 0 {
 			return tp
 		}
@@ -69,9 +84,9 @@ func (g *Generator) randActionTp() ActionTp {
 }
 
 func (g *Generator) randDependTp() DependTp {
-	rd := rand.Intn(g.dependSum)
-	for tp, v := range g.dependMap {
-		rd -= v
+	rd := g.rd.Intn(g.dependSum)
+	for _, tp := range dependTps {
+		rd -= g.dependMap[tp]
 		if rd < 0 {
 			return tp
 		}
@@ -79,17 +94,54 @@ func (g *Generator) randDependTp() DependTp {
 	panic("unreachable")
 }
 
-func (g *Generator) NewGraph(conn, length int) *Graph {
+// randTxnStatus chooses commit or rollback by their weights, txns are committed if both are zero
+func (g *Generator) randTxnStatus() Status {
+	commit, rollback := g.graphMap[Commit], g.graphMap[Rollback]
+	if rollback > 0 && g.rd.Intn(commit+rollback) >= commit {
+		return Rollbacked
+	}
+	return Committed
+}
+
+// NewGraph generates a graph, the invalid graphs are regenerated or repaired by the config,
+// it returns an error if the graph is still invalid after the retries
+func (g *Generator) NewGraph(conn, length int) (*Graph, error) {
+	graph := g.generate(conn, length)
+	if g.globalConfig.InvalidGraph == config.INVALID_GRAPH_IGNORE {
+		return graph, nil
+	}
+	for i := 0; ; i++ {
+		var err error
+		if g.globalConfig.InvalidGraph == config.INVALID_GRAPH_REPAIR {
+			// the problems which can't be repaired are handled by regenerating
+			err = graph.Repair()
+		} else {
+			err = graph.Validate()
+		}
+		if err == nil {
+			return graph, nil
+		}
+		if i == MAX_RETRY {
+			return nil, errors.Annotatef(err, "invalid graph after %d retries, seed: %d", MAX_RETRY, graph.Seed())
+		}
+		fmt.Printf("regenerate invalid graph, seed: %d\n%v\n", graph.Seed(), err)
+		graph = g.generate(conn, length)
+	}
+}
+
+func (g *Generator) generate(conn, length int) *Graph {
 	g.kvManager.Reset()
-	graph := NewGraph(g.kvManager, g.cfg)
+	graph := NewGraph(g.kvManager, g.dialect, g.cfg, g.seed)
+	g.seed++
+	g.rd = graph.rd
 	graph.ticker.Go(func() {
 		fmt.Println("1s no result")
 	})
 	for i := 0; i < conn; i++ {
 		timeline := graph.NewTimeline()
+		timeline.SetIsolation(Isolation(g.globalConfig.ThreadIsolation(i)))
 		for j := 0; j < length; j++ {
-			// TODO: random txn status
-			_ = timeline.NewTxnWithStatus(Committed)
+			_ = timeline.NewTxnWithStatus(g.randTxnStatus())
 			graph.ticker.Tick()
 		}
 	}
@@ -1,6 +1,7 @@
 package graph
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math/rand"
@@ -15,7 +16,6 @@ import (
 )
 
 const MAX_RETRY = 10
-const WAIT_TIME = 2 * time.Millisecond
 
 // Graph is the dependencies graph
 // all the timelines should begin with `Begin` and end with `Commit` or `Rollback`
@@ -31,16 +31,33 @@ type Graph struct {
 	dependMap  map[DependTp]int
 	dependSum  int
 	ticker     util.Ticker
+	execMode   ExecMode
+	// expectedError is the errors which can interrupt the anomaly cycles
+	expectedError ExpectedError
+	// seed of rd, the same seed generates the same graph
+	seed int64
+	rd   *rand.Rand
+	// workers are the states of the workers in the last execution
+	workers *workerStates
 }
 
-func NewGraph(kvManager *kv.Manager, cfg *config.Config) *Graph {
+func NewGraph(kvManager *kv.Manager, dialect kv.Dialect, cfg *config.Config, seed int64) *Graph {
+	rd := util.NewLockedRand(seed)
+	return newGraph(kvManager.NewSchema(dialect, rd), cfg, seed, rd)
+}
+
+func newGraph(schema *kv.Schema, cfg *config.Config, seed int64, rd *rand.Rand) *Graph {
 	g := Graph{
-		cfg:        cfg,
-		allocID:    0,
-		timelines:  []Timeline{},
-		dependency: 0,
-		schema:     kvManager.NewSchema(),
-		ticker:     util.NewTicker(time.Second),
+		cfg:           cfg,
+		allocID:       0,
+		timelines:     []Timeline{},
+		dependency:    0,
+		schema:        schema,
+		ticker:        util.NewTicker(time.Second),
+		execMode:      ExecModeOf(cfg.Global.Target),
+		expectedError: ExpectedErrorOf(cfg.Global.Target),
+		seed:          seed,
+		rd:            rd,
 	}
 	g.CalcDependSum()
 	g.CalcGraphSum()
@@ -82,6 +99,10 @@ func (g *Graph) CalcGraphSum() {
 	g.graphMap = make(map[ActionTp]int, len(actionTps))
 	g.graphSum = 0
 	for _, tp := range actionTps {
+		// txn actions are decided by txn status, not generated as statements
+		if tp.IsTxn() {
+			continue
+		}
 		v := graphMap[string(tp)]
 		g.graphMap[tp] = v
 		g.graphSum += v
@@ -99,10 +120,11 @@ func (g *Graph) CalcDependSum() {
 	}
 }
 
+// randActionTp walks through actionTps in order, map iteration is random even with the same seed
 func (g *Graph) randActionTp() ActionTp {
-	rd := rand.Intn(g.graphSum)
-	for tp, v := range g.graphMap {
-		rd -= v
+	rd := g.rd.Intn(g.graphSum)
+	for _, tp := range actionTps {
+		rd -= g.graphMap[tp]
 		if rd < 0 {
 			return tp
 		}
@@ -111,9 +133,9 @@ func (g *Graph) randActionTp() ActionTp {
 }
 
 func (g *Graph) randDependTp() DependTp {
-	rd := rand.Intn(g.dependSum)
-	for tp, v := range g.dependMap {
-		rd -= v
+	rd := g.rd.Intn(g.dependSum)
+	for _, tp := range dependTps {
+		rd -= g.dependMap[tp]
 		if rd < 0 {
 			return tp
 		}
@@ -121,6 +143,11 @@ func (g *Graph) randDependTp() DependTp {
 	panic("unreachable")
 }
 
+// Seed returns the seed which generates this graph
+func (g *Graph) Seed() int64 {
+	return g.seed
+}
+
 func (g *Graph) NewKV(t int) {
 	t = t % g.allocID
 	pair := g.schema.NewKV()
@@ -137,6 +164,7 @@ func (g *Graph) Next(t1, x1, x2 int, pair *kv.KV, before *Action, depth int) {
 		dependTp DependTp
 		t2       int
 		txn      *Txn
+		txnTp    DependTp
 		ifCycle  = false
 		ok       bool
 		path     [][2]int
@@ -168,14 +196,15 @@ func (g *Graph) Next(t1, x1, x2 int, pair *kv.KV, before *Action, depth int) {
 			}
 		}
 
-		if ok, path = g.IfCycle(t1, x1, t2, x2, dependTp); !ok {
+		txnTp = g.txnDependTp(before, dependTp)
+		if ok, path = g.IfCycle(t1, x1, t2, x2, txnTp); !ok {
 			break
 		} else if g.cfg.Global.Anomaly {
 			short = shortPath(path)
 			if canDeadlock(short) {
 				realtimeCycle := false
 				for x := 0; x < x1; x++ {
-					if ok, _ := g.IfCycle(t1, x, t2, x2, dependTp); ok {
+					if ok, _ := g.IfCycle(t1, x, t2, x2, txnTp); ok {
 						realtimeCycle = true
 						break
 					}
@@ -191,6 +220,8 @@ func (g *Graph) Next(t1, x1, x2 int, pair *kv.KV, before *Action, depth int) {
 		}
 	}
 	action := txn.NewActionWithTp(tp)
+	// appending action may move the actions of before's txn
+	before = g.GetAction(before.tID, before.xID, before.id)
 	if tp.IsRead() && action.vID == -1 {
 		ifCycle = false
 	}
@@ -208,17 +239,21 @@ func (g *Graph) Next(t1, x1, x2 int, pair *kv.KV, before *Action, depth int) {
 		aID: action.id,
 		tp:  dependTp,
 	})
-	if before.tp.IsRead() ||
-		g.GetTimeline(before.tID).GetTxn(before.xID).status != Committed {
-		action.beforeWrite = before.beforeWrite
+	if before.tp.IsRead() {
+		action.beforeLock = before.beforeLock
 	} else {
-		action.beforeWrite = Depend{
+		action.beforeLock = Depend{
 			tID: t1,
 			xID: x1,
 			aID: before.id,
 			tp:  WW,
 		}
 	}
+	if before.tp.IsRead() {
+		action.beforeWrite = g.visibleWrite(before.beforeWrite, t2, x2)
+	} else {
+		action.beforeWrite = g.visibleWrite(action.beforeLock, t2, x2)
+	}
 	before.kvNext = &Depend{
 		tID: t2,
 		xID: x2,
@@ -229,12 +264,17 @@ func (g *Graph) Next(t1, x1, x2 int, pair *kv.KV, before *Action, depth int) {
 		fmt.Println("cycle:", short)
 		g.Anomaly(before, action, short)
 	} else {
-		g.ConnectTxn(t1, x1, t2, x2, dependTp)
+		g.ConnectTxn(t1, x1, t2, x2, txnTp)
+		latest := pair.Latest
 		g.AssignPair(pair, action)
+		// the write is generated from the latest value, which may be rolled back
+		if action.tp.IsWrite() && g.beforeVID(action) != latest {
+			g.RebaseWrite(action)
+		}
 	}
 
-	if util.RdBoolRatio(0.7 * float64(g.GetTimeline(t2).allocID) / float64(depth)) {
-		g.Next(t2, x2, x2+util.RdRange(0, 2), pair, action, depth+1)
+	if util.RdBoolRatio(g.rd, 0.7*float64(g.GetTimeline(t2).allocID)/float64(depth)) {
+		g.Next(t2, x2, x2+util.RdRange(g.rd, 0, 2), pair, action, depth+1)
 	}
 	// if action.tp.IsWrite() && util.RdBoolRatio(2/float64(20+depth)) {
 	// 	g.NextSplit(t2, x2, action, depth+1)
@@ -272,7 +312,7 @@ func (g *Graph) Next(t1, x1, x2 int, pair *kv.KV, before *Action, depth int) {
 // If there is an anomaly between txns, eg.
 // T1 -> T2 -> T3 -> T1, and we want deadlock error occurs in T1 -> T2
 // the dependency from T1 -> T2 must be executed after T2 -> T3 and T3 -> T1
-// We always make deadlock because it's the only error can be expected.
+// We always make deadlock, the errors can be expected are decided by the target, see ExpectedErrorOf.
 // For read dependency, we simply use `SELECT FOR UPDATE` clause.
 func (g *Graph) Anomaly(before, action *Action, short [][2]int) {
 	// TODO: action's txn may be committed successfully, we can reuse lockKV
@@ -581,38 +621,62 @@ func (g *Graph) Abort(tID, xID int) {
 			tp:  WW,
 		}
 		pair := g.schema.GetKV(action.kID)
-		for {
-			if action.kvNext == nil {
-				break
-			}
-			if action.beforeWrite == INVALID_DEPEND {
-				break
-			}
-			next := g.GetTimeline(action.kvNext.tID).
+		// the actions based on the aborted write can be anywhere later,
+		// since the invisible writes are skipped in beforeWrite chain
+		for action.kvNext != nil {
+			action = g.GetTimeline(action.kvNext.tID).
 				GetTxn(action.kvNext.xID).
 				GetAction(action.kvNext.aID)
-			if next.beforeWrite != beforeWrite {
-				break
+			if action.beforeWrite != beforeWrite {
+				continue
 			}
-			next.beforeWrite = overwrite
-			action = next
+			action.beforeWrite = overwrite
 			if action.tp.IsRead() {
 				g.AssignPair(pair, action)
+			} else {
+				g.RebaseWrite(action)
 			}
 		}
 	}
 }
 
+// visibleWrite goes back from the write until it's visible in the given txn,
+// the writes of a txn which is not committed are only visible in itself
+func (g *Graph) visibleWrite(depend Depend, tID, xID int) Depend {
+	for depend != INVALID_DEPEND {
+		if (depend.tID == tID && depend.xID == xID) || g.GetTxn(depend.tID, depend.xID).status == Committed {
+			break
+		}
+		depend = g.GetAction(depend.tID, depend.xID, depend.aID).beforeWrite
+	}
+	return depend
+}
+
+// beforeVID returns the value id which the action reads or overwrites
+func (g *Graph) beforeVID(action *Action) int {
+	if action.beforeWrite == INVALID_DEPEND {
+		return kv.NULL_VALUE_ID
+	}
+	return g.GetTimeline(action.beforeWrite.tID).
+		GetTxn(action.beforeWrite.xID).
+		GetAction(action.beforeWrite.aID).vID
+}
+
+// RebaseWrite regenerates the SQL of a write from its before write, the written value is kept
+func (g *Graph) RebaseWrite(action *Action) {
+	beforeVID := g.beforeVID(action)
+	if action.vID == kv.NULL_VALUE_ID {
+		action.SQL = g.schema.DeleteSQL(beforeVID)
+	} else {
+		action.SQL = g.schema.UpdateSQL(beforeVID, action.vID)
+	}
+}
+
 func (g *Graph) AssignPair(pair *kv.KV, action *Action) {
 	action.kID = pair.ID
 	switch action.tp {
 	case Select, SelectForUpdate:
-		beforeVID := kv.NULL_VALUE_ID
-		if action.beforeWrite != INVALID_DEPEND {
-			beforeVID = g.GetTimeline(action.beforeWrite.tID).
-				GetTxn(action.beforeWrite.xID).
-				GetAction(action.beforeWrite.aID).vID
-		}
+		beforeVID := g.beforeVID(action)
 		switch action.tp {
 		case Select:
 			action.SQL = pair.GetValueNoTxnWithID(g.schema, beforeVID)
@@ -639,14 +703,14 @@ func (g *Graph) AssignPair(pair *kv.KV, action *Action) {
 }
 
 func (g *Graph) RandTxn() (int, int, *Txn) {
-	t := rand.Intn(g.allocID)
+	t := g.rd.Intn(g.allocID)
 	timeline := g.GetTimeline(t)
-	x := rand.Intn(timeline.allocID)
+	x := g.rd.Intn(timeline.allocID)
 	return t, x, timeline.GetTxn(x)
 }
 
 func (g *Graph) RandTxnWithXID(xID int) (int, *Txn) {
-	t := rand.Intn(g.allocID)
+	t := g.rd.Intn(g.allocID)
 	timeline := g.GetTimeline(t)
 	return t, timeline.GetTxn(xID)
 }
@@ -691,7 +755,7 @@ func (g *Graph) IfCycle(t1, x1, t2, x2 int, tp DependTp) (bool, [][2]int) {
 		visited[t1][x1] = true
 		for _, out := range txn1Outs {
 			xID := 2 * out.xID
-			if out.tp.toToEnd() {
+			if !g.toBegin(out.tID, out.tp) {
 				xID++
 			}
 			if out.tID == t1 && xID <= x1 {
@@ -704,7 +768,7 @@ func (g *Graph) IfCycle(t1, x1, t2, x2 int, tp DependTp) (bool, [][2]int) {
 		_, outs := getInOut(t1, x1)
 		for _, out := range outs {
 			xID := 2 * out.xID
-			if out.tp.toToEnd() {
+			if !g.toBegin(out.tID, out.tp) {
 				xID += 1
 			}
 			if ok, path := dfs(out.tID, xID, t2, x2, append(path, [2]int{t1, x1})); ok {
@@ -722,15 +786,16 @@ func (g *Graph) IfCycle(t1, x1, t2, x2 int, tp DependTp) (bool, [][2]int) {
 		return false, nil
 	}
 	switch tp {
-	case WW:
-		_, txn1Outs = getInOut(t1, 2*x1+1)
-		cycle, path = dfs(t2, 2*x2+1, t1, 2*x1+1, path)
-	case WR:
-		_, txn1Outs = getInOut(t1, 2*x1+1)
-		cycle, path = dfs(t2, 2*x2, t1, 2*x1+1, path)
-	case RW:
-		_, txn1Outs = getInOut(t1, 2*x1)
-		cycle, path = dfs(t2, 2*x2+1, t1, 2*x1, path)
+	case WW, WR, RW:
+		from, to := 2*x1, 2*x2
+		if !g.fromBegin(t1, tp) {
+			from++
+		}
+		if !g.toBegin(t2, tp) {
+			to++
+		}
+		_, txn1Outs = getInOut(t1, from)
+		cycle, path = dfs(t2, to, t1, from, path)
 	default:
 		panic("unreachable")
 	}
@@ -740,6 +805,47 @@ func (g *Graph) IfCycle(t1, x1, t2, x2 int, tp DependTp) (bool, [][2]int) {
 	return cycle, path
 }
 
+// fromBegin reports if the dependency is from the begin of txn in timeline tID
+func (g *Graph) fromBegin(tID int, tp DependTp) bool {
+	if tp == RW && !g.GetTimeline(tID).Isolation().SnapshotRead() {
+		return false
+	}
+	return tp.toFromBegin()
+}
+
+// toBegin reports if the dependency is to the begin of txn in timeline tID,
+// reads with statement snapshot depend on the writes committed before the read executes,
+// which is bounded by the end of the reading txn
+func (g *Graph) toBegin(tID int, tp DependTp) bool {
+	if tp == WR && g.GetTimeline(tID).Isolation().StatementSnapshot() {
+		return false
+	}
+	return tp.toToBegin()
+}
+
+// txnDependTp returns the dependency type between txns,
+// the RW dependency is the same as WW when the write must wait for the reading txn ends,
+// either the read is a locking read which holds the lock until its txn ends,
+// or the read sees the write in its own txn and the later write is blocked by that one
+func (g *Graph) txnDependTp(before *Action, tp DependTp) DependTp {
+	if tp != RW {
+		return tp
+	}
+	if g.isLock(before) ||
+		(before.beforeWrite.tID == before.tID && before.beforeWrite.xID == before.xID) {
+		return WW
+	}
+	return tp
+}
+
+// isLock reports if the action takes lock when executing
+func (g *Graph) isLock(action *Action) bool {
+	if action.tp == Select {
+		return g.GetTimeline(action.tID).Isolation().LockingRead()
+	}
+	return action.tp.IsLock()
+}
+
 func (g *Graph) ConnectAction(t1, x1, a1, t2, x2, a2 int, tp DependTp) {
 	if t1 == t2 && x1 == x2 && a1 == a2 {
 		return
@@ -778,12 +884,12 @@ func (g *Graph) ConnectTxn(t1, x1, t2, x2 int, tp DependTp) {
 		xID: x1,
 		tp:  tp,
 	}
-	if tp.toFromBegin() {
+	if g.fromBegin(t1, tp) {
 		txn1.startOuts = append(txn1.startOuts, depend1)
 	} else {
 		txn1.endOuts = append(txn1.endOuts, depend1)
 	}
-	if tp.toToBegin() {
+	if g.toBegin(t2, tp) {
 		txn2.startIns = append(txn2.startIns, depend2)
 	} else {
 		txn2.endIns = append(txn2.endIns, depend2)
@@ -811,25 +917,50 @@ func (g *Graph) UnConnectTxn(t1, x1, t2, x2 int, tp DependTp) {
 	txn2.endIns = endIns
 }
 
-// IterateGraph goes over the graph and exec it by given sequence
+// IterateGraph goes over the graph and exec it by given sequence,
+// exec is called with the thread, txn and action id of the statement, the action id is -1 for txn statements and locks,
+// and returns the parsed rows of reads for comparing with the expected values
 // Since transaction is atomic, we only care about the WW value dependency here
 // Commit/Rollback
-//   i.   txns it RW depends on
-//   ii.  itself
-//   iii. Begin txns WR depend on it
-//   iv.  Commit/Rollback txns WW depend on it
-//   Commit/Rollback txns should check if there are Begin txns need to be waited before committing
-//   should avoid any txns being committed between ii and iii, unless it will pollute value dependency
+//
+//	i.   txns it RW depends on
+//	ii.  itself
+//	iii. Begin txns WR depend on it
+//	iv.  Commit/Rollback txns WW depend on it
+//	Commit/Rollback txns should check if there are Begin txns need to be waited before committing
+//	should avoid any txns being committed between ii and iii, unless it will pollute value dependency
+//
 // Begin
-//   i.   txns it WR depends on(only WR here)
-//   ii.  itself
-//   iii. txns RW depend on it(only RW here)
-func (g *Graph) IterateGraph(exec func(int, ActionTp, string) (*sql.Rows, *sql.Result, error)) error {
-	errCh := make(chan error)
-	doneCh := make(chan struct{})
-	var checkMutex sync.Mutex
+//
+//	i.   txns it WR depends on(only WR here)
+//	ii.  itself
+//	iii. txns RW depend on it(only RW here)
+//
+// IterateGraph returns after all the workers stop, they stop at the first error or when ctx is done,
+// the started txns are rolled back by the workers before exiting, and the progress tells the executed part
+func (g *Graph) IterateGraph(ctx context.Context, exec func(int, int, int, ActionTp, string) ([][]*kv.QueryItem, *sql.Result, error)) (*Progress, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		iterErr error
+	)
+	// fail records the first error and stops the other workers,
+	// the errors after stopping are caused by the stopping itself, they are ignored
+	fail := func(err error) {
+		if ctx.Err() != nil {
+			return
+		}
+		errOnce.Do(func() {
+			iterErr = err
+			cancel()
+		})
+	}
 	var txnMutex sync.Mutex
 	var control sync.RWMutex
+	g.initEvents()
+	g.workers = newWorkerStates(g.allocID)
 	progress := make([]int, len(g.timelines))
 	ticker := util.NewTicker(time.Second)
 	ticker.Go(func() {
@@ -837,85 +968,118 @@ func (g *Graph) IterateGraph(exec func(int, ActionTp, string) (*sql.Rows, *sql.R
 	})
 	for i := 0; i < g.allocID; i++ {
 		progress[i] = 0
+		wg.Add(1)
 		go func(i int) {
+			defer wg.Done()
+			defer g.workers.finish(i)
+			// execute records the executing statement for diagnosing
+			execute := func(tID, xID, aID int, tp ActionTp, sql string) ([][]*kv.QueryItem, *sql.Result, error) {
+				var action *Action
+				if tID >= 0 && aID >= 0 {
+					action = g.GetAction(tID, xID, aID)
+				}
+				g.workers.exec(i, fmt.Sprintf("exec (%d, %d, %d) %s", tID, xID, aID, sql), action)
+				defer g.workers.clear(i)
+				return exec(tID, xID, aID, tp, sql)
+			}
 			var (
-				rows   *sql.Rows
+				rows   [][]*kv.QueryItem
 				err    error
 				txn    *Txn
 				action *Action
 			)
 			timeline := g.GetTimeline(i)
+			// the unfinished txn may hold locks which block the statements of other workers
+			defer func() {
+				if txn != nil && txn.allocID > 0 && txn.GetStart() && !txn.GetEnd() {
+					if _, _, err := execute(i, txn.id, -1, Rollback, "ROLLBACK"); err != nil {
+						fmt.Println("rollback unfinished txn failed", txn.tID, txn.id, err)
+					}
+				}
+			}()
 
 			timeline.GetTxn(0).SetReady(true)
 			for j := 0; j < timeline.allocID; j++ {
+				if ctx.Err() != nil {
+					return
+				}
 				progress[i]++
 				ticker.Tick()
 				txn = timeline.GetTxn(j)
 
 				for _, depend := range txn.startIns {
-					before := g.GetTimeline(depend.tID).GetTxn(depend.xID)
-					t := 1
-					for (depend.tp.toFromBegin() && !before.GetStart()) ||
-						(depend.tp.toFromEnd() && !before.GetEnd()) {
-						t += 1
-						if t%1000 == 0 {
-							fmt.Println("wait for txn start", txn.tID, txn.id)
-						}
-						time.Sleep(WAIT_TIME)
+					if !g.waitTxn(ctx, i, depend, func() string {
+						return fmt.Sprintf("wait for txn start (%d, %d) on (%d, %d)", txn.tID, txn.id, depend.tID, depend.xID)
+					}) {
+						return
 					}
 				}
 
 				txnMutex.Lock()
-				if txn.allocID > 0 && !txn.GetStart() {
-					if _, _, err = exec(i, Begin, "BEGIN"); err != nil {
-						errCh <- err
-						return
-					}
-
-					for _, sql := range txn.lockSQLs {
-						_, _, err := exec(i, Insert, sql)
-						if err != nil {
-							errCh <- err
+				// the txns emptied by minimizing are not executed, but the txns depend on them still wait for the start
+				if !txn.GetStart() {
+					if txn.allocID > 0 {
+						if _, _, err = execute(i, txn.id, -1, Begin, "BEGIN"); err != nil {
+							txnMutex.Unlock()
+							fail(err)
 							return
 						}
+
+						for _, sql := range txn.lockSQLs {
+							_, _, err := execute(i, txn.id, -1, Insert, sql)
+							if err != nil {
+								txnMutex.Unlock()
+								fail(err)
+								return
+							}
+						}
 					}
 					txn.SetStart(true)
 				}
 				txnMutex.Unlock()
 
 				for k := 0; k < txn.allocID; k++ {
+					if ctx.Err() != nil {
+						return
+					}
 					progress[i]++
 					ticker.Tick()
 					control.RLock()
 					action = txn.GetAction(k)
 					control.RUnlock()
 					if action.abortBlock == nil {
+						// statement snapshot, wait for the WR depended txns committed
+						if action.tp.IsRead() && timeline.Isolation().StatementSnapshot() {
+							for _, depend := range action.ins {
+								if depend.tp != WR || (depend.tID == txn.tID && depend.xID == txn.id) {
+									continue
+								}
+								before := g.GetTimeline(depend.tID).GetTxn(depend.xID)
+								if !g.wait(ctx, i, before.tID, before.endEvent, func() string {
+									return fmt.Sprintf("wait for wr commit (%d, %d, %d) on (%d, %d)", action.tID, action.xID, action.id, before.tID, before.id)
+								}) {
+									return
+								}
+							}
+						}
 						// lock dependency
-						if action.tp.IsLock() {
+						if g.isLock(action) {
 							for _, depend := range action.ins {
 								before := g.GetTimeline(depend.tID).GetTxn(depend.xID).GetAction(depend.aID)
-								if before.tp.IsLock() {
-									t := 1
-									for !before.GetExec() {
-										t += 1
-										if t%1000 == 0 {
-											fmt.Println("wait fot lock dependency", action.tID, action.xID, action.id, action.mayAbortSelf, before.tID, before.xID, before.id, before.mayAbortSelf)
-										}
-										time.Sleep(WAIT_TIME)
-									}
+								if g.isLock(before) && !g.wait(ctx, i, before.tID, before.execEvent, func() string {
+									return fmt.Sprintf("wait for lock dependency (%d, %d, %d) on (%d, %d, %d)", action.tID, action.xID, action.id, before.tID, before.xID, before.id)
+								}) {
+									return
 								}
 							}
 						}
-						if action.tp.IsWrite() && action.beforeWrite != INVALID_DEPEND {
-							depend := action.beforeWrite
+						if action.tp.IsWrite() && action.beforeLock != INVALID_DEPEND {
+							depend := action.beforeLock
 							before := g.GetTimeline(depend.tID).GetTxn(depend.xID).GetAction(depend.aID)
-							t := 1
-							for !before.GetExec() {
-								t += 1
-								if t%1000 == 0 {
-									fmt.Println("wait for ww", action.tID, action.xID, action.id, action.mayAbortSelf, before.tID, before.xID, before.id, before.mayAbortSelf)
-								}
-								time.Sleep(WAIT_TIME)
+							if !g.wait(ctx, i, before.tID, before.execEvent, func() string {
+								return fmt.Sprintf("wait for ww (%d, %d, %d) on (%d, %d, %d)", action.tID, action.xID, action.id, before.tID, before.xID, before.id)
+							}) {
+								return
 							}
 						}
 					} else {
@@ -923,44 +1087,58 @@ func (g *Graph) IterateGraph(exec func(int, ActionTp, string) (*sql.Rows, *sql.R
 						before := g.GetTimeline(action.abortBlock.tID).
 							GetTxn(action.abortBlock.xID).
 							GetAction(action.abortBlock.aID)
-						t := 1
-						for !before.GetExec() {
-							t += 1
-							if t%1000 == 0 {
-								fmt.Println("wait for locks", action.tID, action.xID, action.id, action.mayAbortSelf, before.tID, before.xID, before.id, before.mayAbortSelf)
-							}
-							time.Sleep(WAIT_TIME)
+						if !g.wait(ctx, i, before.tID, before.execEvent, func() string {
+							return fmt.Sprintf("wait for locks (%d, %d, %d) on (%d, %d, %d)", action.tID, action.xID, action.id, before.tID, before.xID, before.id)
+						}) {
+							return
 						}
 					}
 
-					execDone := make(chan struct{}, 1)
-					go func() {
-						ticker := time.NewTicker(time.Second)
-						for range ticker.C {
-							select {
-							case <-execDone:
-								return
-							default:
-								action.SetExec()
-								fmt.Println("hang in exec", action.tID, action.xID, action.id)
-							}
+					hang := action
+					stopHang := every(time.Second, func() {
+						// the blocked write may fail to get database lock, keep the later writes waiting
+						if g.execMode != DatabaseLock {
+							hang.SetExec()
+						}
+						// the hanging statements are diagnosed by the statement timeout if it's set
+						if g.cfg.Global.StmtTimeout.Duration == 0 {
+							fmt.Println("hang in exec", hang.tID, hang.xID, hang.id)
 						}
-					}()
-					rows, _, err = exec(i, action.tp, action.SQL)
-					execDone <- struct{}{}
+					})
+					rows, _, err = execute(i, txn.id, action.id, action.tp, action.SQL)
+					stopHang()
+					// the statement timeout means the database hangs, it's never expected
+					if errors.Cause(err) == context.DeadlineExceeded {
+						fail(err)
+						return
+					}
 					txnMutex.Lock()
+					aborted := g.abortsTxn(err)
+					if aborted {
+						// the txn is aborted by database, fix the later actions before releasing them
+						class, _ := g.expectedError.Match(err)
+						fmt.Println("abort by", class, action.tID, action.xID, action.id)
+						g.Abort(txn.tID, txn.id)
+						txn.abortByErr = true
+					}
 					action.SetExec()
 					action.SetDone()
 					// end this transaction
 					if action.mayAbortSelf {
 						if err == nil && action.cycle.GetDone() && !action.cycle.GetErr() && !action.cycle.IfAbort() {
-							errCh <- errors.Errorf("expect error: %s but got nil\ncycle: %s", DEADLOCK_ERROR_MESSAGE, action.cycle)
+							txnMutex.Unlock()
+							fail(errors.Errorf("expect error: %s but got nil\ncycle: %s", g.expectedError, action.cycle))
 							return
-						} else if err != nil && strings.Contains(err.Error(), DEADLOCK_ERROR_MESSAGE) {
-							action.cycle.SetErr()
+						} else if class, ok := g.expectedError.Match(err); ok {
+							fmt.Println("cycle interrupted by", class, action.tID, action.xID, action.id)
+							action.cycle.SetErr(class)
 							action.cycle.SetDone()
+							// the actions based on the writes of this txn are fixed up under txnMutex
+							// before SetEnd releases their waiters, the aborted txn is already fixed up above
+							if !aborted {
+								g.Abort(txn.tID, txn.id)
+							}
 							txnMutex.Unlock()
-							g.Abort(txn.tID, txn.id)
 							txn.SetEnd(true)
 							for ; k < txn.allocID; k++ {
 								action := txn.GetAction(k)
@@ -970,9 +1148,21 @@ func (g *Graph) IterateGraph(exec func(int, ActionTp, string) (*sql.Rows, *sql.R
 								next.SetReady(true)
 							}
 							break
+						} else if err != nil {
+							txnMutex.Unlock()
+							fail(errors.Errorf("expect error: %s but got %s\ncycle: %s", g.expectedError, err, action.cycle))
+							return
 						}
+					} else if aborted {
+						txnMutex.Unlock()
+						for ; k < txn.allocID; k++ {
+							action := txn.GetAction(k)
+							action.SetDone()
+						}
+						break
 					} else if err != nil {
-						errCh <- err
+						txnMutex.Unlock()
+						fail(err)
 						return
 					}
 					txnMutex.Unlock()
@@ -981,15 +1171,13 @@ func (g *Graph) IterateGraph(exec func(int, ActionTp, string) (*sql.Rows, *sql.R
 						if same, err := g.schema.CompareData(action.vID, rows); !same {
 							control.Lock()
 							if strings.Contains(err.Error(), "data length 0, expect 1") {
-								g.TraceEmpty(action, exec)
+								g.TraceEmpty(action, execute)
 							}
-							errCh <- fmt.Errorf("%s got %s", action.SQL, err.Error())
+							fail(fmt.Errorf("%s got %s", action.SQL, err.Error()))
 							control.Unlock()
+							return
 						}
 					}
-					if rows != nil {
-						rows.Close()
-					}
 					action.SetExec()
 					if next := txn.GetAction(k + 1); next != nil {
 						next.SetReady()
@@ -998,33 +1186,32 @@ func (g *Graph) IterateGraph(exec func(int, ActionTp, string) (*sql.Rows, *sql.R
 				progress[i]++
 
 				for _, depend := range txn.endIns {
-					before := g.GetTimeline(depend.tID).GetTxn(depend.xID)
-					t := 1
-					for (depend.tp.toFromBegin() && !before.GetStart()) ||
-						(depend.tp.toFromEnd() && !before.GetEnd()) {
-						next := g.GetTimeline(depend.tID).GetTxn(depend.xID)
-						for !next.GetReady() {
-							t += 1
-							if t%1000 == 0 {
-								fmt.Println("waiting for txn end", txn.tID, txn.id)
-							}
-							time.Sleep(WAIT_TIME)
-						}
+					if !g.waitTxn(ctx, i, depend, func() string {
+						return fmt.Sprintf("wait for txn end (%d, %d) on (%d, %d)", txn.tID, txn.id, depend.tID, depend.xID)
+					}) {
+						return
 					}
 				}
 				txnMutex.Lock()
 				if txn.allocID > 0 {
 					if txn.status != Abort {
-						if _, _, err := exec(txn.tID, txn.EndTp(), txn.EndSQL()); err != nil {
-							errCh <- err
+						if _, _, err := execute(txn.tID, txn.id, -1, txn.EndTp(), txn.EndSQL()); err != nil {
+							fail(err)
+						}
+					} else {
+						// the aborted txn may be still alive, lock wait timeout only rolls back the statement,
+						// and the txn of PostgreSQL stays in aborted state, its connection and locks are released here
+						if _, _, err := execute(txn.tID, txn.id, -1, Rollback, "ROLLBACK"); err != nil {
+							fail(err)
 						}
 					}
 				}
 				for _, depend := range txn.endIns {
-					if depend.tp == WR {
+					// WR depends in endIns are from statement snapshot reads, which begin by themselves
+					if depend.tp == WR && g.toBegin(txn.tID, depend.tp) {
 						next := g.GetTimeline(depend.tID).GetTxn(depend.xID)
-						if _, _, err := exec(depend.tID, Begin, "BEGIN"); err != nil {
-							errCh <- err
+						if _, _, err := execute(depend.tID, depend.xID, -1, Begin, "BEGIN"); err != nil {
+							fail(err)
 						}
 						next.SetStart(true)
 					}
@@ -1035,30 +1222,51 @@ func (g *Graph) IterateGraph(exec func(int, ActionTp, string) (*sql.Rows, *sql.R
 				}
 				txnMutex.Unlock()
 			}
-			// check if all done
-			checkMutex.Lock()
-			defer checkMutex.Unlock()
-			for i := 0; i < len(g.timelines); i++ {
-				timeline := g.GetTimeline(i)
-				if !timeline.GetTxn(timeline.allocID - 1).GetEnd() {
-					return
-				}
-			}
-			doneCh <- struct{}{}
 		}(i)
 	}
 
-	for {
-		select {
-		case err := <-errCh:
-			return err
-		case <-doneCh:
-			return nil
-		}
+	stopWatchdog, watchdogDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		g.watchdog(stopWatchdog, fail)
+		close(watchdogDone)
+	}()
+	wg.Wait()
+	close(stopWatchdog)
+	<-watchdogDone
+	ticker.Stop()
+	if iterErr != nil {
+		return g.progress(), iterErr
+	}
+	return g.progress(), errors.Trace(ctx.Err())
+}
+
+// wait blocks the worker until the event of owner timeline is fired, the waiting described by describe
+// is recorded for diagnosing and reported every WAIT_REPORT_TIME, it returns false if ctx is done before
+func (g *Graph) wait(ctx context.Context, worker, owner int, e *event, describe func() string) bool {
+	select {
+	case <-e.ch:
+		return true
+	default:
+	}
+	doing := describe()
+	g.workers.wait(worker, doing, owner)
+	defer g.workers.clear(worker)
+	return e.wait(ctx, func() {
+		fmt.Println(doing)
+	})
+}
+
+// waitTxn waits for the start or end of the depended txn by the dependency type,
+// it returns false if ctx is done before
+func (g *Graph) waitTxn(ctx context.Context, worker int, depend Depend, describe func() string) bool {
+	before := g.GetTimeline(depend.tID).GetTxn(depend.xID)
+	if g.fromBegin(depend.tID, depend.tp) {
+		return g.wait(ctx, worker, depend.tID, before.startEvent, describe)
 	}
+	return g.wait(ctx, worker, depend.tID, before.endEvent, describe)
 }
 
-func (g *Graph) TraceEmpty(action *Action, exec func(int, ActionTp, string) (*sql.Rows, *sql.Result, error)) {
+func (g *Graph) TraceEmpty(action *Action, exec func(int, int, int, ActionTp, string) ([][]*kv.QueryItem, *sql.Result, error)) {
 	fmt.Printf("Executed SQL got empty: %s\n", action.SQL)
 	fmt.Printf("Correct data of (%d, %d, %d): %s\n", action.tID, action.xID, action.id, g.schema.GetData(action.vID))
 	for _, depend := range action.ins {
@@ -1080,7 +1288,7 @@ func (g *Graph) TraceEmpty(action *Action, exec func(int, ActionTp, string) (*sq
 					fmt.Printf(" (%d, %d, %d, %s)", a.tID, a.xID, a.id, a.tp)
 					if a.tp.IsWrite() && a.vID != kv.NULL_VALUE_ID {
 						selectSQL := g.schema.SelectSQL(a.vID)
-						rows, _, err := exec(-1, Select, selectSQL)
+						rows, _, err := exec(-1, -1, -1, Select, selectSQL)
 						if err == nil {
 							if same, _ := g.schema.CompareData(a.vID, rows); same {
 								fmt.Fprintf(&b, "(%d, %d, %d, %s)'s value still alive, SQL: %s\n", a.tID, a.xID, a.id, a.tp, selectSQL)
@@ -1097,6 +1305,11 @@ func (g *Graph) TraceEmpty(action *Action, exec func(int, ActionTp, string) (*sq
 	fmt.Print(b.String())
 }
 
+// TimelineNum returns the number of timelines, which is the number of threads to execute the graph
+func (g *Graph) TimelineNum() int {
+	return g.allocID
+}
+
 func (g *Graph) MaxAction() int {
 	m := 0
 	for i := 0; i < g.allocID; i++ {
@@ -1128,6 +1341,11 @@ func (g *Graph) GetSchemas() []string {
 	return []string{g.schema.CreateTable()}
 }
 
+// Schema returns the table schema and the generated data of values
+func (g *Graph) Schema() *kv.Schema {
+	return g.schema
+}
+
 func shortPath(path [][2]int) [][2]int {
 	var short [][2]int
 	var idx = -1
@@ -1,9 +1,24 @@
 package graph
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/juju/errors"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/db"
+	"github.com/you06/go-mikadzuki/kv"
 )
 
 func emptyGraph() *Graph {
@@ -40,14 +55,15 @@ func TestConnectTxnAndSimpleCycle(t *testing.T) {
 				allocID: 1,
 				txns: []Txn{
 					{
-						id:        0,
-						tID:       0,
-						allocID:   0,
-						actions:   []Action{},
-						status:    Committed,
-						startOuts: []Depend{},
-						startIns:  []Depend{},
-						endIns:    []Depend{},
+						id:         0,
+						tID:        0,
+						allocID:    0,
+						actions:    []Action{},
+						status:     Committed,
+						startOuts:  []Depend{},
+						startIns:   []Depend{},
+						noStartIns: map[Depend]struct{}{},
+						endIns:     []Depend{},
 						endOuts: []Depend{
 							{
 								tID: 1,
@@ -77,9 +93,10 @@ func TestConnectTxnAndSimpleCycle(t *testing.T) {
 								tp:  WR,
 							},
 						},
-						endIns:   []Depend{},
-						endOuts:  []Depend{},
-						lockSQLs: []string{},
+						noStartIns: map[Depend]struct{}{},
+						endIns:     []Depend{},
+						endOuts:    []Depend{},
+						lockSQLs:   []string{},
 					},
 				},
 			},
@@ -210,9 +227,10 @@ func TestMoveBefore(t *testing.T) {
 		return graph
 	}
 	graph := case1()
-	before := graph.GetAction(1, 0, 0)
+	before := *graph.GetAction(1, 0, 0)
 	graph.MoveBefore(1, 0, 0, 2)
-	require.Equal(t, *before, *graph.GetAction(1, 0, 1))
+	before.id = 1
+	require.Equal(t, before, *graph.GetAction(1, 0, 1))
 	require.Equal(t, graph.GetAction(1, 0, 0).id, 0)
 	require.Equal(t, graph.GetAction(1, 0, 1).id, 1)
 	require.Equal(t, graph.GetAction(1, 0, 2).id, 2)
@@ -240,3 +258,769 @@ func TestMoveBefore(t *testing.T) {
 		newDepend(1, 0, 0, WW),
 	})
 }
+
+func TestIsolationCycle(t *testing.T) {
+	newGraph := func(isolation Isolation) *Graph {
+		graph := emptyGraph()
+		timeline := graph.NewTimeline()
+		timeline.SetIsolation(isolation)
+		_ = timeline.NewTxnWithStatus(Committed)
+		timeline = graph.NewTimeline()
+		_ = timeline.NewTxnWithStatus(Committed)
+		graph.ConnectTxn(0, 0, 1, 0, RW)
+		return graph
+	}
+	// the write can be committed after the read txn begins
+	graph := newGraph(RepeatableRead)
+	require.Equal(t, len(graph.GetTxn(0, 0).startOuts), 1)
+	ok, _ := graph.IfCycle(1, 0, 0, 0, WW)
+	require.False(t, ok)
+	// the write must be committed after the read txn ends
+	for _, isolation := range []Isolation{ReadCommitted, Serializable} {
+		graph = newGraph(isolation)
+		require.Equal(t, len(graph.GetTxn(0, 0).endOuts), 1)
+		ok, path := graph.IfCycle(1, 0, 0, 0, WW)
+		require.True(t, ok)
+		require.Equal(t, path, [][2]int{{0, 1}, {1, 1}})
+	}
+}
+
+func TestStatementSnapshot(t *testing.T) {
+	newGraph := func(isolation Isolation) *Graph {
+		graph := emptyGraph()
+		timeline := graph.NewTimeline()
+		_ = timeline.NewTxnWithStatus(Committed)
+		timeline = graph.NewTimeline()
+		timeline.SetIsolation(isolation)
+		_ = timeline.NewTxnWithStatus(Committed)
+		graph.ConnectTxn(0, 0, 1, 0, WR)
+		return graph
+	}
+	// the read txn begins after the write is committed
+	graph := newGraph(RepeatableRead)
+	require.Equal(t, len(graph.GetTxn(1, 0).startIns), 1)
+	ok, path := graph.IfCycle(1, 0, 0, 0, RW)
+	require.True(t, ok)
+	require.Equal(t, path, [][2]int{{0, 1}, {1, 0}})
+	// the read txn may begin before the write is committed
+	graph = newGraph(ReadCommitted)
+	require.Equal(t, len(graph.GetTxn(1, 0).startIns), 0)
+	require.Equal(t, len(graph.GetTxn(1, 0).endIns), 1)
+	ok, path = graph.IfCycle(1, 0, 0, 0, RW)
+	require.True(t, ok)
+	require.Equal(t, path, [][2]int{{0, 1}, {1, 1}})
+}
+
+func TestTxnDependTp(t *testing.T) {
+	graph := emptyGraph()
+	timeline := graph.NewTimeline()
+	txn := timeline.NewTxnWithStatus(Committed)
+	write := txn.NewActionWithTp(Insert)
+	read := txn.NewActionWithTp(Select)
+	lock := txn.NewActionWithTp(SelectForUpdate)
+	require.Equal(t, graph.txnDependTp(write, WW), WW)
+	require.Equal(t, graph.txnDependTp(read, RW), RW)
+	require.Equal(t, graph.txnDependTp(lock, RW), WW)
+	// the write must wait for the lock of the write it reads
+	read.beforeWrite = newDepend(0, 0, write.id, WW)
+	require.Equal(t, graph.txnDependTp(read, RW), WW)
+	timeline.SetIsolation(Serializable)
+	read.beforeWrite = INVALID_DEPEND
+	require.Equal(t, graph.txnDependTp(read, RW), WW)
+}
+
+func TestAbortRebaseWrite(t *testing.T) {
+	cfg := config.NewConfig()
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.MySQL{}, &cfg, 1)
+	for i := 0; i < 3; i++ {
+		graph.NewTimeline().NewTxnWithStatus(Committed)
+	}
+	pair := graph.schema.NewKV()
+	insert := graph.GetTxn(0, 0).NewActionWithTp(Insert)
+	graph.AssignPair(pair, insert)
+	update := graph.GetTxn(1, 0).NewActionWithTp(Update)
+	update.beforeWrite = newDepend(0, 0, insert.id, WW)
+	insert.kvNext = &Depend{tID: 1, xID: 0, aID: update.id, tp: WW}
+	graph.AssignPair(pair, update)
+	read := graph.GetTxn(2, 0).NewActionWithTp(Select)
+	read.beforeWrite = newDepend(1, 0, update.id, WW)
+	update.kvNext = &Depend{tID: 2, xID: 0, aID: read.id, tp: WR}
+	graph.AssignPair(pair, read)
+	require.Equal(t, read.vID, update.vID)
+
+	// the update writes the same value without the inserted row
+	graph.Abort(0, 0)
+	require.Equal(t, update.beforeWrite, INVALID_DEPEND)
+	require.Equal(t, update.SQL, graph.schema.ReplaceSQL(update.vID))
+	require.Equal(t, read.vID, update.vID)
+	// the read finds nothing when both writes are aborted
+	graph.Abort(1, 0)
+	require.Equal(t, read.beforeWrite, INVALID_DEPEND)
+	require.Equal(t, read.vID, kv.NULL_VALUE_ID)
+}
+
+func TestVisibleWrite(t *testing.T) {
+	graph := emptyGraph()
+	graph.NewTimeline().NewTxnWithStatus(Committed).NewActionWithTp(Insert)
+	txn := graph.NewTimeline().NewTxnWithStatus(Rollbacked)
+	update := txn.NewActionWithTp(Update)
+	update.beforeWrite = newDepend(0, 0, 0, WW)
+	del := txn.NewActionWithTp(Delete)
+	del.beforeWrite = newDepend(1, 0, update.id, WW)
+	graph.NewTimeline().NewTxnWithStatus(Committed)
+	// rolled back writes are visible in its own txn
+	require.Equal(t, graph.visibleWrite(newDepend(1, 0, del.id, WW), 1, 0), newDepend(1, 0, del.id, WW))
+	// other txns see the last committed write
+	require.Equal(t, graph.visibleWrite(newDepend(1, 0, del.id, WW), 2, 0), newDepend(0, 0, 0, WW))
+	require.Equal(t, graph.visibleWrite(newDepend(0, 0, 0, WW), 2, 0), newDepend(0, 0, 0, WW))
+	require.Equal(t, graph.visibleWrite(INVALID_DEPEND, 2, 0), INVALID_DEPEND)
+}
+
+func TestSeedGraph(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Global.Seed = 17
+	newGraph := func() *Graph {
+		kvManager := kv.NewManager(&cfg.Global)
+		generator := NewGenerator(&kvManager, &cfg)
+		graph, err := generator.NewGraph(cfg.Global.Thread, cfg.Global.Action)
+		require.Nil(t, err)
+		return graph
+	}
+	g1, g2 := newGraph(), newGraph()
+	require.Equal(t, g1.Seed(), int64(17))
+	require.Equal(t, g1.String(), g2.String())
+	require.Equal(t, g1.GetSchemas(), g2.GetSchemas())
+}
+
+func TestGraphJSON(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Global.Seed = 23
+	cfg.Global.Anomaly = true
+	cfg.Global.IsolationMix = []string{config.REPEATABLE_READ, config.READ_COMMITTED}
+	kvManager := kv.NewManager(&cfg.Global)
+	generator := NewGenerator(&kvManager, &cfg)
+	graph, err := generator.NewGraph(cfg.Global.Thread, cfg.Global.Action)
+	require.Nil(t, err)
+	data, err := json.Marshal(graph)
+	require.Nil(t, err)
+
+	loadManager := kv.NewManager(&cfg.Global)
+	loaded, err := LoadGraph(data, &loadManager, &cfg)
+	require.Nil(t, err)
+	require.Equal(t, loaded.Seed(), graph.Seed())
+	require.Equal(t, loaded.String(), graph.String())
+	require.Equal(t, loaded.GetSchemas(), graph.GetSchemas())
+	require.Equal(t, loaded.GetTimeline(1).Isolation(), ReadCommitted)
+	for vID := range graph.schema.Data {
+		require.Equal(t, loaded.schema.GetData(vID), graph.schema.GetData(vID))
+	}
+	reloaded, err := json.Marshal(loaded)
+	require.Nil(t, err)
+	require.Equal(t, string(reloaded), string(data))
+
+	// the SQLs of graph are in MySQL dialect
+	postgresCfg := cfg
+	postgresCfg.Global.Target = kv.TARGET_POSTGRES
+	_, err = LoadGraph(data, &loadManager, &postgresCfg)
+	require.NotNil(t, err)
+
+	data = []byte(strings.Replace(string(data), `"version":1`, `"version":0`, 1))
+	_, err = LoadGraph(data, &loadManager, &cfg)
+	require.NotNil(t, err)
+}
+
+func TestRemoveAction(t *testing.T) {
+	cfg := config.NewConfig()
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.MySQL{}, &cfg, 1)
+	for i := 0; i < 3; i++ {
+		graph.NewTimeline().NewTxnWithStatus(Committed)
+	}
+	pair := graph.schema.NewKV()
+	insert := graph.GetTxn(0, 0).NewActionWithTp(Insert)
+	graph.AssignPair(pair, insert)
+	lock := graph.GetTxn(1, 0).NewActionWithTp(SelectForUpdate)
+	lock.beforeWrite = newDepend(0, 0, insert.id, WW)
+	insert.kvNext = &Depend{tID: 1, xID: 0, aID: 0, tp: WR}
+	graph.AssignPair(pair, lock)
+	update := graph.GetTxn(1, 0).NewActionWithTp(Update)
+	update.beforeWrite = newDepend(0, 0, insert.id, WW)
+	graph.GetAction(1, 0, 0).kvNext = &Depend{tID: 1, xID: 0, aID: 1, tp: RW}
+	graph.AssignPair(pair, update)
+	read := graph.GetTxn(2, 0).NewActionWithTp(Select)
+	read.beforeWrite = newDepend(1, 0, 1, WW)
+	read.beforeLock = newDepend(1, 0, 1, WW)
+	update.kvNext = &Depend{tID: 2, xID: 0, aID: 0, tp: WR}
+	graph.ConnectAction(1, 0, 1, 2, 0, 0, WR)
+	graph.AssignPair(pair, read)
+
+	// the update is moved forward
+	graph.RemoveAction(1, 0, 0)
+	update = graph.GetAction(1, 0, 0)
+	require.Equal(t, update.id, 0)
+	require.Equal(t, update.tp, Update)
+	require.Equal(t, *insert.kvNext, Depend{tID: 1, xID: 0, aID: 0, tp: RW})
+	require.Equal(t, read.beforeWrite, newDepend(1, 0, 0, WW))
+	require.Equal(t, read.ins, []Depend{newDepend(1, 0, 0, WR)})
+
+	// the read gets the inserted value
+	graph.RemoveAction(1, 0, 0)
+	require.Equal(t, graph.GetTxn(1, 0).allocID, 0)
+	require.Equal(t, read.beforeWrite, newDepend(0, 0, insert.id, WW))
+	require.Equal(t, read.beforeLock, INVALID_DEPEND)
+	require.Equal(t, read.vID, insert.vID)
+	require.Equal(t, read.SQL, graph.schema.SelectSQL(insert.vID))
+	require.Empty(t, read.ins)
+	require.Equal(t, *insert.kvNext, Depend{tID: 2, xID: 0, aID: 0, tp: WR})
+}
+
+func TestRemoveKeepsReferences(t *testing.T) {
+	// the reads in anomaly cycles may not read the before write, their values are not checked
+	for _, anomaly := range []bool{false, true} {
+		testRemoveKeepsReferences(t, anomaly)
+	}
+}
+
+func testRemoveKeepsReferences(t *testing.T, anomaly bool) {
+	cfg := config.NewConfig()
+	cfg.Global.Seed = 23
+	cfg.Global.Anomaly = anomaly
+	kvManager := kv.NewManager(&cfg.Global)
+	generator := NewGenerator(&kvManager, &cfg)
+	graph, err := generator.NewGraph(cfg.Global.Thread, cfg.Global.Action)
+	require.Nil(t, err)
+	graph.RemoveTimeline(1)
+	graph.RemoveTxn(2, 3)
+	for i := 0; i < graph.TimelineNum(); i++ {
+		timeline := graph.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			if txn := timeline.GetTxn(j); txn.allocID > 1 {
+				graph.RemoveAction(i, j, (i+j)%txn.allocID)
+			}
+		}
+	}
+
+	resolve := func(d Depend) {
+		if d != INVALID_DEPEND {
+			require.NotNil(t, graph.GetAction(d.tID, d.xID, d.aID), fmt.Sprint(d))
+		}
+	}
+	for i := 0; i < graph.TimelineNum(); i++ {
+		timeline := graph.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			if i == 1 || (i == 2 && j == 3) {
+				require.Equal(t, txn.allocID, 0)
+			}
+			for k := 0; k < txn.allocID; k++ {
+				action := txn.GetAction(k)
+				require.Equal(t, action.id, k)
+				for _, d := range append(append([]Depend{}, action.ins...), action.outs...) {
+					resolve(d)
+				}
+				resolve(action.beforeWrite)
+				resolve(action.beforeLock)
+				if action.kvNext != nil {
+					resolve(*action.kvNext)
+				}
+				if action.abortBlock != nil {
+					resolve(*action.abortBlock)
+				}
+				if action.cycle != nil {
+					for l := range action.cycle.locations {
+						require.NotNil(t, graph.GetAction(l.tID, l.xID, l.aID), fmt.Sprint(l))
+					}
+				}
+				if !anomaly && action.tp.IsRead() {
+					require.Equal(t, action.vID, graph.beforeVID(action))
+				}
+			}
+		}
+	}
+}
+
+func TestGraphExport(t *testing.T) {
+	cfg := config.NewConfig()
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.MySQL{}, &cfg, 1)
+	graph.NewTimeline().NewTxnWithStatus(Committed)
+	graph.NewTimeline().NewTxnWithStatus(Rollbacked)
+	graph.GetTxn(0, 0).NewActionWithTp(Insert)
+	read := graph.GetTxn(1, 0).NewActionWithTp(Select)
+	graph.ConnectAction(0, 0, 0, 1, 0, 0, WR)
+	graph.ConnectTxn(0, 0, 1, 0, WR)
+	cycle := EmptyCycle(graph)
+	read.cycle = &cycle
+	read.mayAbortSelf = true
+
+	dot, err := graph.Export(FormatDOT)
+	require.Nil(t, err)
+	require.Contains(t, dot, "subgraph cluster_t1 {")
+	require.Contains(t, dot, "t0_x0_begin -> t0_x0_a0 [weight=10];")
+	require.Contains(t, dot, "t0_x0_a0 -> t1_x0_a0 [label=\"WR\"")
+	require.Contains(t, dot, "t0_x0_end -> t1_x0_begin [label=\"WR\"")
+	require.Contains(t, dot, "t1_x0_a0 [label=\"*(0)Select(")
+	require.Contains(t, dot, "color=red, penwidth=2, style=filled, fillcolor=orange];")
+	require.Contains(t, dot, "t1_x0_end [label=\"Rollback\"")
+
+	mermaid, err := graph.Export(FormatMermaid)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(mermaid, "flowchart TB\n"))
+	require.Contains(t, mermaid, "subgraph t0 [\"timeline 0 (repeatable-read)\"]")
+	require.Contains(t, mermaid, "t0_x0_a0 -- WR --> t1_x0_a0")
+	require.Contains(t, mermaid, "t0_x0_end -- WR --> t1_x0_begin")
+	require.Contains(t, mermaid, "class t1_x0_a0 cycle")
+	require.Contains(t, mermaid, "class t1_x0_a0 mayAbortSelf")
+
+	_, err = graph.Export("png")
+	require.NotNil(t, err)
+}
+
+func TestIterateGraphCancel(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Global.Seed = 29
+	kvManager := kv.NewManager(&cfg.Global)
+	generator := NewGenerator(&kvManager, &cfg)
+	graph, err := generator.NewGraph(cfg.Global.Thread, cfg.Global.Action)
+	require.Nil(t, err)
+
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		mu   sync.Mutex
+		open = make(map[int]bool)
+	)
+	// the statements block like waiting for locks until the iteration is canceled
+	progress, err := graph.IterateGraph(ctx, func(tID, xID, aID int, tp ActionTp, sql string) ([][]*kv.QueryItem, *sql.Result, error) {
+		if tID < 0 {
+			return nil, nil, nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case tp == Begin:
+			open[tID] = true
+		case tp.IsTxnEnd():
+			open[tID] = false
+		default:
+			cancel()
+			mu.Unlock()
+			<-ctx.Done()
+			mu.Lock()
+			return nil, nil, errors.New("statement canceled")
+		}
+		return nil, nil, nil
+	})
+	require.Equal(t, errors.Cause(err), context.Canceled)
+	require.False(t, progress.Finished())
+	require.NotEmpty(t, progress.Unfinished)
+	for tID, isOpen := range open {
+		require.False(t, isOpen, tID)
+	}
+	// the tickers exit in a while
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		require.True(t, i < 100, "leaked goroutines")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := newEvent()
+	cancel()
+	require.False(t, e.wait(ctx, func() {}))
+	e.fire()
+	e.fire()
+	require.True(t, e.wait(ctx, func() {}))
+	require.True(t, newEventIf(true).wait(ctx, func() {}))
+	var nilEvent *event
+	nilEvent.fire()
+
+	calls := make(chan struct{}, 10)
+	stop := every(time.Millisecond, func() {
+		calls <- struct{}{}
+	})
+	<-calls
+	<-calls
+	stop()
+	time.Sleep(10 * time.Millisecond)
+	for len(calls) > 0 {
+		<-calls
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, calls, 0)
+}
+
+func TestWatchdog(t *testing.T) {
+	cfg := config.NewConfig()
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.MySQL{}, &cfg, 1)
+	for i := 0; i < 2; i++ {
+		timeline := graph.NewTimeline()
+		txn := timeline.NewTxnWithStatus(Committed)
+		txn.NewActionWithTp(Insert)
+	}
+	// each txn starts after the other one ends
+	graph.ConnectTxn(0, 0, 1, 0, WR)
+	graph.ConnectTxn(1, 0, 0, 0, WR)
+
+	_, err := graph.IterateGraph(context.Background(), func(tID, xID, aID int, tp ActionTp, sql string) ([][]*kv.QueryItem, *sql.Result, error) {
+		return nil, nil, nil
+	})
+	watchdogErr, ok := errors.Cause(err).(*WatchdogError)
+	require.True(t, ok, err)
+	require.True(t, watchdogErr.Unschedulable)
+	require.Contains(t, watchdogErr.Report, "wait-for cycle: timeline 0 -> 1 -> 0")
+}
+
+func TestWatchdogHang(t *testing.T) {
+	cfg := config.NewConfig()
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.MySQL{}, &cfg, 1)
+	for i := 0; i < 2; i++ {
+		timeline := graph.NewTimeline()
+		txn := timeline.NewTxnWithStatus(Committed)
+		txn.NewActionWithTp(Insert)
+	}
+	states := []workerState{
+		{doing: "wait", waiting: true, owner: 1},
+		{doing: "exec", action: graph.GetAction(1, 0, 0)},
+	}
+	done := []bool{false, false}
+	// the hangs are not detected without statement timeout
+	require.Nil(t, graph.stuck(states, done, time.Hour))
+	cfg.Global.StmtTimeout.Duration = 5 * time.Minute
+	hangTime := 5*time.Minute + WATCHDOG_HANG_MARGIN
+	require.Nil(t, graph.stuck(states, done, hangTime-time.Second))
+	err := graph.stuck(states, done, hangTime)
+	require.NotNil(t, err)
+	require.False(t, err.(*WatchdogError).Unschedulable)
+	// the worker is running
+	states[1] = workerState{}
+	require.Nil(t, graph.stuck(states, done, hangTime))
+}
+
+// newValidateGraph creates a graph of timelines with txns of the given numbers of actions
+func newValidateGraph(txns ...[]int) *Graph {
+	cfg := config.NewConfig()
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.MySQL{}, &cfg, 1)
+	for _, actions := range txns {
+		timeline := graph.NewTimeline()
+		for _, n := range actions {
+			txn := timeline.NewTxnWithStatus(Committed)
+			for i := 0; i < n; i++ {
+				txn.NewActionWithTp(Update)
+			}
+		}
+	}
+	return graph
+}
+
+// overwrite makes the action a write of key 0 after the before one
+func overwrite(graph *Graph, action *Action, before *Action, vID int) {
+	action.kID, action.vID = 0, vID
+	if before != nil {
+		depend := Depend{tID: before.tID, xID: before.xID, aID: before.id, tp: WW}
+		action.beforeLock, action.beforeWrite = depend, depend
+		graph.ConnectAction(before.tID, before.xID, before.id, action.tID, action.xID, action.id, WW)
+	}
+}
+
+func requireProblem(t *testing.T, err error, tp ProblemTp, detail string) {
+	invalid, ok := err.(*InvalidGraphError)
+	require.True(t, ok, err)
+	for _, problem := range invalid.Problems {
+		if problem.Tp == tp && strings.Contains(problem.Detail, detail) {
+			return
+		}
+	}
+	require.Fail(t, "problem not found", "%s %s in %v", tp, detail, err)
+}
+
+func TestValidateGenerated(t *testing.T) {
+	for _, isolation := range []string{config.REPEATABLE_READ, config.READ_COMMITTED, config.SERIALIZABLE} {
+		cfg := config.NewConfig()
+		cfg.Global.Seed = 1
+		cfg.Global.Isolation = isolation
+		kvManager := kv.NewManager(&cfg.Global)
+		generator := NewGenerator(&kvManager, &cfg)
+		for i := 0; i < 10; i++ {
+			graph, err := generator.NewGraph(cfg.Global.Thread, cfg.Global.Action)
+			require.Nil(t, err)
+			require.Nil(t, graph.Validate(), "%s seed %d", isolation, graph.Seed())
+		}
+	}
+}
+
+func TestValidateCycle(t *testing.T) {
+	graph := newValidateGraph([]int{1}, []int{1})
+	// each txn starts after the other one ends
+	graph.ConnectTxn(0, 0, 1, 0, WR)
+	graph.ConnectTxn(1, 0, 0, 0, WR)
+	requireProblem(t, graph.Validate(), OrderCycle, "(0, 0) begin -Realtime-> (0, 0, 0)")
+}
+
+func TestValidateImpossible(t *testing.T) {
+	// the impossible graph in doc/graph.md
+	// w(x, 1) -> commit
+	// w(x, 2) -> commit -> begin -> r(x, 1) -> commit
+	graph := newValidateGraph([]int{1}, []int{1, 1})
+	w1, w2, r := graph.GetAction(0, 0, 0), graph.GetAction(1, 0, 0), graph.GetAction(1, 1, 0)
+	overwrite(graph, w1, nil, 1)
+	overwrite(graph, w2, w1, 2)
+	graph.ConnectTxn(0, 0, 1, 0, WW)
+	r.tp, r.kID, r.vID = Select, 0, 1
+	r.beforeWrite = Depend{tID: 0, xID: 0, aID: 0, tp: WR}
+	graph.ConnectAction(0, 0, 0, 1, 1, 0, WR)
+	graph.ConnectTxn(0, 0, 1, 1, WR)
+	requireProblem(t, graph.Validate(), InvisibleRead, "(1, 1, 0) reads (0, 0, 0) on key 0 which is overwritten by visible (1, 0, 0)")
+
+	// the read is removed
+	require.Nil(t, graph.Repair())
+	require.Equal(t, graph.GetTxn(1, 1).allocID, 0)
+	require.Equal(t, graph.GetTxn(1, 0).allocID, 1)
+}
+
+func TestValidateAmbiguous(t *testing.T) {
+	// the ambiguous graph in doc/graph.md, w(x, 2) and w(x, 3) overwrite w(x, 1) in no order
+	graph := newValidateGraph([]int{1}, []int{1}, []int{1})
+	w1, w2, w3 := graph.GetAction(0, 0, 0), graph.GetAction(1, 0, 0), graph.GetAction(2, 0, 0)
+	overwrite(graph, w1, nil, 1)
+	overwrite(graph, w2, w1, 2)
+	overwrite(graph, w3, w1, 3)
+	graph.ConnectTxn(0, 0, 1, 0, WW)
+	graph.ConnectTxn(0, 0, 2, 0, WW)
+	requireProblem(t, graph.Validate(), AmbiguousWW, "(1, 0, 0) and (2, 0, 0) overwrite (0, 0, 0) on key 0 in no order")
+
+	// the dependency from w(x, 2) to w(x, 3) fixes it
+	graph.ConnectAction(1, 0, 0, 2, 0, 0, WW)
+	graph.ConnectTxn(1, 0, 2, 0, WW)
+	require.Nil(t, graph.Validate())
+}
+
+func TestExpectedError(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}
+	conflict := &mysql.MySQLError{Number: 9007, Message: "Write conflict"}
+
+	mysqlExpected := ExpectedErrorOf(kv.TARGET_MYSQL)
+	class, ok := mysqlExpected.Match(errors.Trace(deadlock))
+	require.True(t, ok)
+	require.Equal(t, class, Deadlock)
+	_, ok = mysqlExpected.Match(conflict)
+	require.False(t, ok)
+	// the message is never matched without code
+	_, ok = mysqlExpected.Match(errors.New(deadlock.Message))
+	require.False(t, ok)
+	_, ok = mysqlExpected.Match(nil)
+	require.False(t, ok)
+
+	class, ok = ExpectedErrorOf(kv.TARGET_TIDB).Match(conflict)
+	require.True(t, ok)
+	require.Equal(t, class, WriteConflict)
+
+	postgresExpected := ExpectedErrorOf(kv.TARGET_POSTGRES)
+	class, ok = postgresExpected.Match(&pq.Error{Code: "40P01"})
+	require.True(t, ok)
+	require.Equal(t, class, Deadlock)
+	class, ok = postgresExpected.Match(&pq.Error{Code: "40001"})
+	require.True(t, ok)
+	require.Equal(t, class, SerializationFailure)
+	require.Equal(t, postgresExpected.String(), "Deadlock(40P01), SerializationFailure(40001)")
+
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy, ExtendedCode: sqlite3.ErrBusySnapshot}
+	sqliteExpected := ExpectedErrorOf(kv.TARGET_SQLITE)
+	require.True(t, DatabaseLock.IsBusy(sqliteExpected, errors.Trace(busy)))
+	require.False(t, RowLock.IsBusy(sqliteExpected, busy))
+	require.False(t, DatabaseLock.IsBusy(sqliteExpected, errors.New("database is locked")))
+
+	graph := emptyGraph()
+	cycle := EmptyCycle(graph)
+	require.False(t, cycle.GetErr())
+	cycle.SetErr(Deadlock)
+	require.True(t, cycle.GetErr())
+	require.Equal(t, cycle.Err(), Deadlock)
+	require.True(t, strings.HasSuffix(cycle.String(), "] interrupted by Deadlock"))
+}
+
+func TestAbortByLockWaitTimeout(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Global.Target = kv.TARGET_MEMORY
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.MySQL{}, &cfg, 1)
+	schema := graph.schema
+	pairA, pairB := schema.NewKV(), schema.NewKV()
+	stmts := []string{schema.CreateTable(), pairA.NewValueNoTxn(schema), pairB.NewValueNoTxn(schema)}
+	initB := pairB.Latest
+
+	update := func(txn *Txn, pair *kv.KV, sql string) *Action {
+		action := txn.NewActionWithTp(Update)
+		action.kID, action.vID, action.SQL = pair.ID, pair.Latest, sql
+		return action
+	}
+	// timeline 0 holds the lock of A until the txn of timeline 1 ends
+	t0 := graph.NewTimeline()
+	lockA := update(t0.NewTxnWithStatus(Committed), pairA, pairA.PutValueNoTxn(schema))
+	// timeline 1 writes B and waits for the lock of A until it times out
+	t1 := graph.NewTimeline()
+	timeout := t1.NewTxnWithStatus(Committed)
+	update(timeout, pairB, pairB.PutValueNoTxn(schema))
+	waitA := update(timeout, pairA, pairA.PutValueNoTxn(schema))
+	waitA.beforeLock = Depend{tID: lockA.tID, xID: lockA.xID, aID: lockA.id, tp: WW}
+	waitA.mayAbortSelf = true
+	cycle := EmptyCycle(graph)
+	waitA.cycle = &cycle
+	graph.ConnectTxn(1, 0, 0, 0, WW)
+	// the next txn of timeline 1 writes B again, which is blocked if the timed out txn is alive
+	pairB.PutValueNoTxn(schema)
+	update(t1.NewTxnWithStatus(Committed), pairB, schema.UpdateSQL(initB, pairB.Latest))
+
+	conn, err := db.NewMemory("lock-wait-timeout/")
+	require.Nil(t, err)
+	_, err = conn.Exec("CREATE DATABASE test")
+	require.Nil(t, err)
+	require.Nil(t, conn.Close())
+	conn, err = db.NewMemory("lock-wait-timeout/test")
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Exec("SET GLOBAL innodb_lock_wait_timeout = 1")
+	require.Nil(t, err)
+	for _, stmt := range stmts {
+		_, err = conn.Exec(stmt)
+		require.Nil(t, err)
+	}
+
+	var mu sync.Mutex
+	txns := make([]db.Txn, 2)
+	_, err = graph.IterateGraph(context.Background(), func(tID, xID, aID int, tp ActionTp, stmt string) ([][]*kv.QueryItem, *sql.Result, error) {
+		mu.Lock()
+		txn := txns[tID]
+		mu.Unlock()
+		switch tp {
+		case Begin:
+			if txn != nil {
+				return nil, nil, errors.Errorf("the txn before (%d, %d) is not ended", tID, xID)
+			}
+			txn, err := conn.Begin(graph.GetTimeline(tID).TxOptions())
+			mu.Lock()
+			txns[tID] = txn
+			mu.Unlock()
+			return nil, nil, err
+		case Commit, Rollback:
+			mu.Lock()
+			txns[tID] = nil
+			mu.Unlock()
+			if tp == Commit {
+				return nil, nil, txn.Commit()
+			}
+			return nil, nil, txn.Rollback()
+		default:
+			res, err := txn.Exec(stmt)
+			return nil, res, err
+		}
+	})
+	require.Nil(t, err)
+	require.True(t, cycle.GetErr())
+	require.Equal(t, cycle.Err(), LockWaitTimeout)
+}
+
+// snapshotWrites executes the writes with the semantics of PostgreSQL's repeatable read,
+// a write waits for the uncommitted writer of the key, and fails if the key is committed after its txn begins
+type snapshotWrites struct {
+	sync.Mutex
+	cond      *sync.Cond
+	ts        int
+	committed map[int]int
+	owners    map[int]int
+	snapshots map[int]int
+	locks     map[int][]int
+	stmts     [][]string
+}
+
+func newSnapshotWrites(timelines int) *snapshotWrites {
+	s := &snapshotWrites{
+		committed: make(map[int]int),
+		owners:    make(map[int]int),
+		snapshots: make(map[int]int),
+		locks:     make(map[int][]int),
+		stmts:     make([][]string, timelines),
+	}
+	s.cond = sync.NewCond(&s.Mutex)
+	return s
+}
+
+func (s *snapshotWrites) exec(g *Graph, tID, xID, aID int, tp ActionTp) error {
+	s.Lock()
+	defer s.Unlock()
+	s.stmts[tID] = append(s.stmts[tID], string(tp))
+	switch tp {
+	case Begin:
+		s.snapshots[tID] = s.ts
+	case Commit, Rollback:
+		if tp == Commit {
+			s.ts++
+		}
+		for _, key := range s.locks[tID] {
+			if tp == Commit {
+				s.committed[key] = s.ts
+			}
+			delete(s.owners, key)
+		}
+		s.locks[tID] = nil
+		s.cond.Broadcast()
+	default:
+		key := g.GetAction(tID, xID, aID).kID
+		for owner, ok := s.owners[key]; ok && owner != tID; owner, ok = s.owners[key] {
+			s.cond.Wait()
+		}
+		if s.committed[key] > s.snapshots[tID] {
+			return &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+		}
+		if _, ok := s.owners[key]; !ok {
+			s.owners[key] = tID
+			s.locks[tID] = append(s.locks[tID], key)
+		}
+	}
+	return nil
+}
+
+func TestSnapshotWriteConflict(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Global.Target = kv.TARGET_POSTGRES
+	kvManager := kv.NewManager(&cfg.Global)
+	graph := NewGraph(&kvManager, kv.PostgreSQL{}, &cfg, 1)
+	schema := graph.schema
+	pair := schema.NewKV()
+	pair.NewValueNoTxn(schema)
+	update := func(txn *Txn, before *Action) *Action {
+		action := txn.NewActionWithTp(Update)
+		action.kID, action.SQL = pair.ID, pair.PutValueNoTxn(schema)
+		action.vID = pair.Latest
+		if before != nil {
+			depend := Depend{tID: before.tID, xID: before.xID, aID: before.id, tp: WW}
+			action.beforeLock, action.beforeWrite = depend, depend
+			before.kvNext = &Depend{tID: action.tID, xID: action.xID, aID: action.id, tp: WW}
+		}
+		return action
+	}
+	first := update(graph.NewTimeline().NewTxnWithStatus(Committed), nil)
+	t1 := graph.NewTimeline()
+	t1.NewTxnWithStatus(Committed)
+	t1.NewTxnWithStatus(Committed)
+	second := update(graph.GetTxn(1, 0), first)
+	third := update(graph.GetTxn(1, 1), second)
+	// WW only orders the ends, the second txn begins before the first one commits
+	graph.ConnectTxn(0, 0, 1, 0, WW)
+	graph.ConnectTxn(1, 0, 0, 0, RW)
+
+	s := newSnapshotWrites(2)
+	_, err := graph.IterateGraph(context.Background(), func(tID, xID, aID int, tp ActionTp, sql string) ([][]*kv.QueryItem, *sql.Result, error) {
+		return nil, nil, s.exec(graph, tID, xID, aID, tp)
+	})
+	require.Nil(t, err)
+	require.Equal(t, graph.GetTxn(1, 0).status, Abort)
+	// the write after the aborted one is rebased on the first write
+	require.Equal(t, third.beforeWrite, Depend{tID: 0, xID: 0, aID: 0, tp: WW})
+	require.Equal(t, s.stmts[1], []string{"Begin", "Update", "Rollback", "Begin", "Update", "Commit"})
+}
@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"database/sql"
+
+	"github.com/you06/go-mikadzuki/config"
+)
+
+type Isolation string
+
+const (
+	RepeatableRead Isolation = config.REPEATABLE_READ
+	ReadCommitted  Isolation = config.READ_COMMITTED
+	Serializable   Isolation = config.SERIALIZABLE
+)
+
+// Level converts isolation to the one used in sql.TxOptions
+func (i Isolation) Level() sql.IsolationLevel {
+	switch i {
+	case ReadCommitted:
+		return sql.LevelReadCommitted
+	case Serializable:
+		return sql.LevelSerializable
+	default:
+		return sql.LevelRepeatableRead
+	}
+}
+
+// SnapshotRead means the reads in a txn see the snapshot when txn begins,
+// under read-committed, the reads see the values committed after txn begins,
+// under serializable, reads take locks and block the later writes,
+// in both cases RW dependency should be from the end of the reading txn
+func (i Isolation) SnapshotRead() bool {
+	return i == RepeatableRead
+}
+
+// StatementSnapshot means every read takes a new snapshot when executing,
+// so a read sees the writes committed before the statement instead of the txn begins
+func (i Isolation) StatementSnapshot() bool {
+	return i == ReadCommitted
+}
+
+// LockingRead means plain reads are converted into locking reads
+func (i Isolation) LockingRead() bool {
+	return i == Serializable
+}
@@ -0,0 +1,286 @@
+package graph
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/kv"
+	"github.com/you06/go-mikadzuki/util"
+)
+
+// GRAPH_JSON_VERSION should be increased when the serialized format changes
+const GRAPH_JSON_VERSION = 1
+
+// graphJSON is the serialized Graph, the ids of timelines, txns and actions are their positions.
+// Execution states are not saved, a loaded graph is the same as a newly generated one.
+type graphJSON struct {
+	Version    int             `json:"version"`
+	Seed       int64           `json:"seed"`
+	Dialect    string          `json:"dialect"`
+	Dependency int             `json:"dependency"`
+	Timelines  []timelineJSON  `json:"timelines"`
+	Cycles     []cycleJSON     `json:"cycles"`
+	Schema     json.RawMessage `json:"schema"`
+}
+
+type timelineJSON struct {
+	Isolation Isolation `json:"isolation"`
+	Txns      []txnJSON `json:"txns"`
+}
+
+type txnJSON struct {
+	Status     Status       `json:"status"`
+	Actions    []actionJSON `json:"actions"`
+	StartOuts  []Depend     `json:"start_outs"`
+	StartIns   []Depend     `json:"start_ins"`
+	NoStartIns []Depend     `json:"no_start_ins"`
+	EndIns     []Depend     `json:"end_ins"`
+	EndOuts    []Depend     `json:"end_outs"`
+	LockSQLs   []string     `json:"lock_sqls"`
+}
+
+type actionJSON struct {
+	Tp               ActionTp `json:"tp"`
+	Outs             []Depend `json:"outs"`
+	Ins              []Depend `json:"ins"`
+	BeforeWrite      Depend   `json:"before_write"`
+	BeforeLock       Depend   `json:"before_lock"`
+	KVNext           *Depend  `json:"kv_next,omitempty"`
+	KID              int      `json:"kid"`
+	VID              int      `json:"vid"`
+	KnowValue        bool     `json:"know_value,omitempty"`
+	SQL              string   `json:"sql"`
+	ExpectedErrorMsg string   `json:"expected_error_msg,omitempty"`
+	AbortOther       bool     `json:"abort_other,omitempty"`
+	AbortSelf        bool     `json:"abort_self,omitempty"`
+	MayAbortSelf     bool     `json:"may_abort_self,omitempty"`
+	AbortBlock       *Depend  `json:"abort_block,omitempty"`
+	// Cycle is the index in graphJSON.Cycles, the cycle is shared by the actions in it
+	Cycle *int `json:"cycle,omitempty"`
+}
+
+type cycleJSON struct {
+	Locations          []Location          `json:"locations"`
+	RealtimeBlockPairs []RealtimeBlockPair `json:"realtime_block_pairs"`
+}
+
+type dependJSON struct {
+	TID int      `json:"t"`
+	XID int      `json:"x"`
+	AID int      `json:"a"`
+	Tp  DependTp `json:"tp,omitempty"`
+}
+
+func (d Depend) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dependJSON{d.tID, d.xID, d.aID, d.tp})
+}
+
+func (d *Depend) UnmarshalJSON(b []byte) error {
+	var data dependJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return errors.Trace(err)
+	}
+	*d = Depend{tID: data.TID, xID: data.XID, aID: data.AID, tp: data.Tp}
+	return nil
+}
+
+func (l Location) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dependJSON{TID: l.tID, XID: l.xID, AID: l.aID})
+}
+
+func (l *Location) UnmarshalJSON(b []byte) error {
+	var data dependJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return errors.Trace(err)
+	}
+	*l = Location{tID: data.TID, xID: data.XID, aID: data.AID}
+	return nil
+}
+
+func (p RealtimeBlockPair) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]Location{p.from, p.to})
+}
+
+func (p *RealtimeBlockPair) UnmarshalJSON(b []byte) error {
+	var data [2]Location
+	if err := json.Unmarshal(b, &data); err != nil {
+		return errors.Trace(err)
+	}
+	*p = RealtimeBlockPair{from: data[0], to: data[1]}
+	return nil
+}
+
+func sortLocations(locations []Location) {
+	sort.Slice(locations, func(i, j int) bool {
+		a, b := locations[i], locations[j]
+		if a.tID != b.tID {
+			return a.tID < b.tID
+		}
+		if a.xID != b.xID {
+			return a.xID < b.xID
+		}
+		return a.aID < b.aID
+	})
+}
+
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	schema, err := json.Marshal(g.schema)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	data := graphJSON{
+		Version:    GRAPH_JSON_VERSION,
+		Seed:       g.seed,
+		Dialect:    g.schema.Dialect().Name(),
+		Dependency: g.dependency,
+		Timelines:  make([]timelineJSON, len(g.timelines)),
+		Cycles:     []cycleJSON{},
+		Schema:     schema,
+	}
+	cycles := make(map[*Cycle]int)
+	for i := range g.timelines {
+		timeline := &g.timelines[i]
+		t := timelineJSON{
+			Isolation: timeline.isolation,
+			Txns:      make([]txnJSON, len(timeline.txns)),
+		}
+		for j := range timeline.txns {
+			txn := &timeline.txns[j]
+			x := txnJSON{
+				Status:     txn.status,
+				Actions:    make([]actionJSON, len(txn.actions)),
+				StartOuts:  txn.startOuts,
+				StartIns:   txn.startIns,
+				NoStartIns: []Depend{},
+				EndIns:     txn.endIns,
+				EndOuts:    txn.endOuts,
+				LockSQLs:   txn.lockSQLs,
+			}
+			var noStartIns []Location
+			for d := range txn.noStartIns {
+				noStartIns = append(noStartIns, LocationFromDepend(&d))
+			}
+			sortLocations(noStartIns)
+			for _, l := range noStartIns {
+				x.NoStartIns = append(x.NoStartIns, Depend{tID: l.tID, xID: l.xID})
+			}
+			for k := range txn.actions {
+				action := &txn.actions[k]
+				a := actionJSON{
+					Tp:               action.tp,
+					Outs:             action.outs,
+					Ins:              action.ins,
+					BeforeWrite:      action.beforeWrite,
+					BeforeLock:       action.beforeLock,
+					KVNext:           action.kvNext,
+					KID:              action.kID,
+					VID:              action.vID,
+					KnowValue:        action.knowValue,
+					SQL:              action.SQL,
+					ExpectedErrorMsg: action.ExpectedErrorMsg,
+					AbortOther:       action.abortOther,
+					AbortSelf:        action.abortSelf,
+					MayAbortSelf:     action.mayAbortSelf,
+					AbortBlock:       action.abortBlock,
+				}
+				if action.cycle != nil {
+					idx, ok := cycles[action.cycle]
+					if !ok {
+						idx = len(data.Cycles)
+						cycles[action.cycle] = idx
+						c := cycleJSON{
+							Locations:          []Location{},
+							RealtimeBlockPairs: action.cycle.realtimeBlockPairs,
+						}
+						for l := range action.cycle.locations {
+							c.Locations = append(c.Locations, l)
+						}
+						sortLocations(c.Locations)
+						data.Cycles = append(data.Cycles, c)
+					}
+					a.Cycle = &idx
+				}
+				x.Actions[k] = a
+			}
+			t.Txns[j] = x
+		}
+		data.Timelines[i] = t
+	}
+	return json.Marshal(data)
+}
+
+// LoadGraph reconstructs the graph serialized by Graph.MarshalJSON,
+// the schema is added into kvManager and the SQLs are kept in the saved dialect,
+// so the graph can only be loaded for the target of the same dialect.
+func LoadGraph(b []byte, kvManager *kv.Manager, cfg *config.Config) (*Graph, error) {
+	var data graphJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if data.Version != GRAPH_JSON_VERSION {
+		return nil, errors.Errorf("unsupported graph version %d, expect %d", data.Version, GRAPH_JSON_VERSION)
+	}
+	if dialect := kv.NewDialect(cfg.Global.Target).Name(); dialect != data.Dialect {
+		return nil, errors.Errorf("the graph is saved in %s dialect, which is not the %s dialect of target %s", data.Dialect, dialect, cfg.Global.Target)
+	}
+	rd := util.NewLockedRand(data.Seed)
+	schema, err := kvManager.LoadSchema(data.Schema, kv.NewDialect(data.Dialect), rd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	g := newGraph(schema, cfg, data.Seed, rd)
+	g.dependency = data.Dependency
+
+	cycles := make([]*Cycle, len(data.Cycles))
+	for i, c := range data.Cycles {
+		cycle := EmptyCycle(g)
+		for _, l := range c.Locations {
+			cycle.Add(l)
+		}
+		for _, pair := range c.RealtimeBlockPairs {
+			cycle.AddBlockPair(pair)
+		}
+		cycles[i] = &cycle
+	}
+	for i, t := range data.Timelines {
+		timeline := g.NewTimeline()
+		timeline.SetIsolation(t.Isolation)
+		for j, x := range t.Txns {
+			txn := timeline.NewTxnWithStatus(x.Status)
+			txn.startOuts = x.StartOuts
+			txn.startIns = x.StartIns
+			txn.endIns = x.EndIns
+			txn.endOuts = x.EndOuts
+			txn.lockSQLs = x.LockSQLs
+			for _, d := range x.NoStartIns {
+				txn.noStartIns[Depend{tID: d.tID, xID: d.xID}] = struct{}{}
+			}
+			for k, a := range x.Actions {
+				action := txn.NewActionWithTp(a.Tp)
+				action.outs = a.Outs
+				action.ins = a.Ins
+				action.beforeWrite = a.BeforeWrite
+				action.beforeLock = a.BeforeLock
+				action.kvNext = a.KVNext
+				action.kID = a.KID
+				action.vID = a.VID
+				action.knowValue = a.KnowValue
+				action.SQL = a.SQL
+				action.ExpectedErrorMsg = a.ExpectedErrorMsg
+				action.abortOther = a.AbortOther
+				action.abortSelf = a.AbortSelf
+				action.mayAbortSelf = a.MayAbortSelf
+				action.abortBlock = a.AbortBlock
+				if a.Cycle != nil {
+					if *a.Cycle < 0 || *a.Cycle >= len(cycles) {
+						return nil, errors.Errorf("action [%d %d %d] refers unknown cycle %d", i, j, k, *a.Cycle)
+					}
+					action.cycle = cycles[*a.Cycle]
+				}
+			}
+		}
+	}
+	return g, nil
+}
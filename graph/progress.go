@@ -0,0 +1,61 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Progress is the executed part of a graph when IterateGraph returns
+type Progress struct {
+	// Txns and Actions are the numbers of non-empty txns and actions in graph
+	Txns    int
+	Actions int
+	// Ended and Done are the numbers of ended txns and done actions
+	Ended int
+	Done  int
+	// Unfinished are the [tID, xID] of txns started but not ended, the workers roll them back before exiting
+	Unfinished [][2]int
+}
+
+func (g *Graph) progress() *Progress {
+	var p Progress
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			if txn.allocID == 0 {
+				continue
+			}
+			p.Txns++
+			if txn.GetEnd() {
+				p.Ended++
+			} else if txn.GetStart() {
+				p.Unfinished = append(p.Unfinished, [2]int{i, j})
+			}
+			for k := 0; k < txn.allocID; k++ {
+				p.Actions++
+				if txn.GetAction(k).GetDone() {
+					p.Done++
+				}
+			}
+		}
+	}
+	return &p
+}
+
+// Finished reports if all the txns are ended
+func (p *Progress) Finished() bool {
+	return p.Ended == p.Txns
+}
+
+func (p *Progress) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "txns: %d/%d, actions: %d/%d", p.Ended, p.Txns, p.Done, p.Actions)
+	if len(p.Unfinished) > 0 {
+		b.WriteString(", unfinished txns:")
+		for _, txn := range p.Unfinished {
+			fmt.Fprintf(&b, " (%d, %d)", txn[0], txn[1])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,186 @@
+package graph
+
+// RemoveAction deletes an action from its txn, the actions based on it are fixed in the same way as Abort,
+// and the references to the later actions in this txn are shifted.
+// The txn level dependencies are kept, so the execution order of txns does not change.
+func (g *Graph) RemoveAction(tID, xID, aID int) {
+	txn := g.GetTxn(tID, xID)
+	action := txn.GetAction(aID)
+	self := Depend{
+		tID: tID,
+		xID: xID,
+		aID: aID,
+		tp:  WW,
+	}
+
+	// the later actions on the same key read or overwrite the value before it,
+	// the reordered actions in generation may point back, so each action is visited once
+	if !action.tp.IsRead() {
+		pair := g.schema.GetKV(action.kID)
+		visited := make(map[Location]struct{})
+		for next := action.kvNext; next != nil; {
+			location := LocationFromDepend(next)
+			if _, ok := visited[location]; ok {
+				break
+			}
+			visited[location] = struct{}{}
+			after := g.GetAction(next.tID, next.xID, next.aID)
+			if after == nil {
+				break
+			}
+			next = after.kvNext
+			if after.beforeWrite != self {
+				continue
+			}
+			after.beforeWrite = action.beforeWrite
+			if after.tp.IsRead() {
+				g.AssignPair(pair, after)
+			} else {
+				g.RebaseWrite(after)
+			}
+		}
+	}
+
+	// a cycle may keep the locations of actions which are moved into a later cycle
+	cycles := make(map[*Cycle]struct{})
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			t := timeline.GetTxn(j)
+			for k := 0; k < t.allocID; k++ {
+				other := t.GetAction(k)
+				if other.cycle != nil {
+					cycles[other.cycle] = struct{}{}
+				}
+				if other == action {
+					continue
+				}
+				if isAction(other.beforeLock, tID, xID, aID) {
+					other.beforeLock = action.beforeLock
+				}
+				if other.kvNext != nil && isAction(*other.kvNext, tID, xID, aID) {
+					other.kvNext = nil
+					if action.kvNext != nil {
+						next := *action.kvNext
+						other.kvNext = &next
+					}
+				}
+				if other.abortBlock != nil && isAction(*other.abortBlock, tID, xID, aID) {
+					other.abortBlock = nil
+				}
+				other.ins = removeDepends(other.ins, tID, xID, aID)
+				other.outs = removeDepends(other.outs, tID, xID, aID)
+			}
+		}
+	}
+	location := LocationFromAction(action)
+	for cycle := range cycles {
+		delete(cycle.locations, location)
+		var pairs []RealtimeBlockPair
+		for _, pair := range cycle.realtimeBlockPairs {
+			if pair.from != location && pair.to != location {
+				pairs = append(pairs, pair)
+			}
+		}
+		cycle.realtimeBlockPairs = pairs
+	}
+
+	txn.actions = append(txn.actions[:aID], txn.actions[aID+1:]...)
+	txn.allocID -= 1
+	g.shiftActions(tID, xID, aID)
+}
+
+// RemoveTxn deletes all the actions of a txn, the empty txn is kept to hold the txn level dependencies
+func (g *Graph) RemoveTxn(tID, xID int) {
+	txn := g.GetTxn(tID, xID)
+	for aID := txn.allocID - 1; aID >= 0; aID-- {
+		g.RemoveAction(tID, xID, aID)
+	}
+}
+
+// RemoveTimeline deletes the actions of all txns in a timeline
+func (g *Graph) RemoveTimeline(tID int) {
+	timeline := g.GetTimeline(tID)
+	for xID := 0; xID < timeline.allocID; xID++ {
+		g.RemoveTxn(tID, xID)
+	}
+}
+
+// shiftActions decreases the ids of actions after aID in txn (tID, xID) and the references to them
+func (g *Graph) shiftActions(tID, xID, aID int) {
+	shift := func(d *Depend) {
+		if d.tID == tID && d.xID == xID && d.aID > aID {
+			d.aID -= 1
+		}
+	}
+	// the pointers may be shared, shift each of them once
+	shifted := make(map[*Depend]struct{})
+	shiftPtr := func(d *Depend) {
+		if d == nil {
+			return
+		}
+		if _, ok := shifted[d]; !ok {
+			shifted[d] = struct{}{}
+			shift(d)
+		}
+	}
+	cycles := make(map[*Cycle]struct{})
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			t := timeline.GetTxn(j)
+			for k := 0; k < t.allocID; k++ {
+				action := t.GetAction(k)
+				if i == tID && j == xID && k >= aID {
+					action.id = k
+				}
+				for n := range action.ins {
+					shift(&action.ins[n])
+				}
+				for n := range action.outs {
+					shift(&action.outs[n])
+				}
+				shift(&action.beforeWrite)
+				shift(&action.beforeLock)
+				shiftPtr(action.kvNext)
+				shiftPtr(action.abortBlock)
+				if action.cycle != nil {
+					cycles[action.cycle] = struct{}{}
+				}
+			}
+		}
+	}
+	for cycle := range cycles {
+		locations := make(map[Location]struct{}, len(cycle.locations))
+		for location := range cycle.locations {
+			locations[shiftLocation(location, tID, xID, aID)] = struct{}{}
+		}
+		cycle.locations = locations
+		for n := range cycle.realtimeBlockPairs {
+			pair := &cycle.realtimeBlockPairs[n]
+			pair.from = shiftLocation(pair.from, tID, xID, aID)
+			pair.to = shiftLocation(pair.to, tID, xID, aID)
+		}
+	}
+}
+
+func isAction(d Depend, tID, xID, aID int) bool {
+	return d.tID == tID && d.xID == xID && d.aID == aID
+}
+
+func removeDepends(depends []Depend, tID, xID, aID int) []Depend {
+	var kept []Depend
+	for _, d := range depends {
+		if !isAction(d, tID, xID, aID) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+func shiftLocation(l Location, tID, xID, aID int) Location {
+	if l.tID == tID && l.xID == xID && l.aID > aID {
+		l.aID -= 1
+	}
+	return l
+}
@@ -1,13 +1,15 @@
 package graph
 
 import (
+	"database/sql"
 	"strings"
 )
 
 type Timeline struct {
-	id      int
-	allocID int
-	txns    []Txn
+	id        int
+	allocID   int
+	txns      []Txn
+	isolation Isolation
 }
 
 func NewTimeline(id int) Timeline {
@@ -20,11 +22,11 @@ func NewTimeline(id int) Timeline {
 
 func (t *Timeline) String() string {
 	var b strings.Builder
-	for i, t := range t.txns {
+	for i := range t.txns {
 		if i != 0 {
 			b.WriteString("\n")
 		}
-		b.WriteString(t.String())
+		b.WriteString(t.txns[i].String())
 	}
 	return b.String()
 }
@@ -50,3 +52,22 @@ func (t *Timeline) GetAction(xID, aID int) *Action {
 	}
 	return txn.GetAction(aID)
 }
+
+func (t *Timeline) SetIsolation(isolation Isolation) {
+	t.isolation = isolation
+}
+
+// Isolation returns the isolation level of txns in this timeline,
+// repeatable read by default
+func (t *Timeline) Isolation() Isolation {
+	if t.isolation == "" {
+		return RepeatableRead
+	}
+	return t.isolation
+}
+
+func (t *Timeline) TxOptions() *sql.TxOptions {
+	return &sql.TxOptions{
+		Isolation: t.Isolation().Level(),
+	}
+}
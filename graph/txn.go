@@ -7,8 +7,6 @@ import (
 	"sync/atomic"
 )
 
-const DEADLOCK_ERROR_MESSAGE = "Deadlock"
-
 type Txn struct {
 	sync.RWMutex
 	id         int
@@ -24,6 +22,8 @@ type Txn struct {
 	ifStart    bool
 	ifEnd      bool
 	ifReady    bool
+	startEvent *event
+	endEvent   *event
 	abortByErr bool
 	lockSQLs   []string
 }
@@ -62,6 +62,9 @@ func (t *Txn) GetAction(n int) *Action {
 func (t *Txn) SetStart(r bool) {
 	t.Lock()
 	t.ifStart = r
+	if r {
+		t.startEvent.fire()
+	}
 	t.Unlock()
 }
 
@@ -74,6 +77,9 @@ func (t *Txn) GetStart() bool {
 func (t *Txn) SetEnd(r bool) {
 	t.Lock()
 	t.ifEnd = r
+	if r {
+		t.endEvent.fire()
+	}
 	t.Unlock()
 }
 
@@ -180,10 +186,8 @@ type Cycle struct {
 	// 0: not done
 	// 1: done
 	phase int64
-	// has error?
-	// 0: not yet
-	// 1: has
-	err                int64
+	// err is the class of error which interrupts the cycle, nil if not yet
+	err                atomic.Value
 	graph              *Graph
 	locations          map[Location]struct{}
 	realtimeBlockPairs []RealtimeBlockPair
@@ -192,7 +196,6 @@ type Cycle struct {
 func EmptyCycle(g *Graph) Cycle {
 	return Cycle{
 		phase:              0,
-		err:                0,
 		graph:              g,
 		locations:          make(map[Location]struct{}),
 		realtimeBlockPairs: []RealtimeBlockPair{},
@@ -209,12 +212,18 @@ func (c *Cycle) IfAbort() bool {
 	return false
 }
 
-func (c *Cycle) SetErr() {
-	atomic.StoreInt64(&c.err, 1)
+func (c *Cycle) SetErr(class ErrorClass) {
+	c.err.Store(class)
 }
 
 func (c *Cycle) GetErr() bool {
-	return atomic.LoadInt64(&c.err) >= 1
+	return c.err.Load() != nil
+}
+
+// Err returns the class of error which interrupts the cycle
+func (c *Cycle) Err() ErrorClass {
+	class, _ := c.err.Load().(ErrorClass)
+	return class
 }
 
 func (c *Cycle) SetDone() {
@@ -260,6 +269,9 @@ func (c *Cycle) String() string {
 		i++
 	}
 	b.WriteByte(']')
+	if class := c.Err(); class != "" {
+		fmt.Fprintf(&b, " interrupted by %s", class)
+	}
 	return b.String()
 }
 
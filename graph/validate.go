@@ -0,0 +1,526 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+// ProblemTp is the kind of problem which makes a graph invalid
+type ProblemTp string
+
+var (
+	// OrderCycle means the realtime, dependency and lock wait orders form a cycle,
+	// the graph can't be executed in the expected order
+	OrderCycle ProblemTp = "OrderCycle"
+	// AmbiguousWW means the writes overwriting the same write are not ordered,
+	// the value after them can't be inferred, see the ambiguous graph in doc/graph.md
+	AmbiguousWW ProblemTp = "AmbiguousWW"
+	// InvisibleRead means a read expects a value which can't be visible to it,
+	// see the impossible graph in doc/graph.md
+	InvisibleRead ProblemTp = "InvisibleRead"
+)
+
+// Problem is a reason why a graph is invalid
+type Problem struct {
+	Tp ProblemTp
+	// Actions are the involved actions, the last one is removed by Repair
+	Actions []Location
+	Detail  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Tp, p.Detail)
+}
+
+// InvalidGraphError is returned by Validate with all the problems found
+type InvalidGraphError struct {
+	Problems []Problem
+}
+
+func (e *InvalidGraphError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid graph, %d problems", len(e.Problems))
+	for _, problem := range e.Problems {
+		b.WriteString("\n")
+		b.WriteString(problem.String())
+	}
+	return b.String()
+}
+
+// orderNode is a point of execution, the begin and end of a txn, or the execution of an action,
+// an action is issued at exec and returns at done, which differ when it waits for locks
+type orderNode struct {
+	tID  int
+	xID  int
+	aID  int
+	done bool
+}
+
+func (n orderNode) String() string {
+	switch n.aID {
+	case BEGIN_NODE:
+		return fmt.Sprintf("(%d, %d) begin", n.tID, n.xID)
+	case END_NODE:
+		return fmt.Sprintf("(%d, %d) end", n.tID, n.xID)
+	}
+	if n.done {
+		return fmt.Sprintf("(%d, %d, %d) done", n.tID, n.xID, n.aID)
+	}
+	return fmt.Sprintf("(%d, %d, %d)", n.tID, n.xID, n.aID)
+}
+
+// the aID of txn begin and end nodes
+const (
+	BEGIN_NODE = -1
+	END_NODE   = -2
+)
+
+type orderEdge struct {
+	to int
+	tp string
+}
+
+// orderGraph is the happen-before order of the execution points, which IterateGraph and database obey
+type orderGraph struct {
+	g     *Graph
+	nodes []orderNode
+	edges [][]orderEdge
+	// begins[t][x] is the begin node of txn, followed by the exec and done nodes of actions and the end node
+	begins [][]int
+	// reaches caches the nodes reachable from a node
+	reaches map[int][]bool
+}
+
+func (o *orderGraph) begin(tID, xID int) int {
+	return o.begins[tID][xID]
+}
+
+func (o *orderGraph) end(tID, xID int) int {
+	return o.begins[tID][xID] + 2*o.g.GetTxn(tID, xID).allocID + 1
+}
+
+func (o *orderGraph) exec(tID, xID, aID int) int {
+	return o.begins[tID][xID] + 2*aID + 1
+}
+
+func (o *orderGraph) done(tID, xID, aID int) int {
+	return o.begins[tID][xID] + 2*aID + 2
+}
+
+// txnPoint is the point of txn which a txn level dependency is from
+func (o *orderGraph) txnPoint(depend Depend) int {
+	if o.g.fromBegin(depend.tID, depend.tp) {
+		return o.begin(depend.tID, depend.xID)
+	}
+	return o.end(depend.tID, depend.xID)
+}
+
+// executed is the point after which the waiters for the exec event of action go on,
+// the event of a blocked action is fired after a while unless the database locks as a whole
+func (o *orderGraph) executed(action *Action) int {
+	if o.g.execMode == DatabaseLock {
+		return o.done(action.tID, action.xID, action.id)
+	}
+	return o.exec(action.tID, action.xID, action.id)
+}
+
+func (o *orderGraph) connect(from, to int, tp string) {
+	o.edges[from] = append(o.edges[from], orderEdge{to: to, tp: tp})
+}
+
+func (o *orderGraph) reach(from, to int) bool {
+	reached, ok := o.reaches[from]
+	if !ok {
+		reached = make([]bool, len(o.nodes))
+		stack := []int{from}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, e := range o.edges[n] {
+				if !reached[e.to] {
+					reached[e.to] = true
+					stack = append(stack, e.to)
+				}
+			}
+		}
+		o.reaches[from] = reached
+	}
+	return reached[to]
+}
+
+// newOrderGraph collects the orders which IterateGraph waits for, and the lock waits in database
+func (g *Graph) newOrderGraph() *orderGraph {
+	o := orderGraph{
+		g:       g,
+		begins:  make([][]int, g.allocID),
+		reaches: make(map[int][]bool),
+	}
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		o.begins[i] = make([]int, timeline.allocID)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			o.begins[i][j] = len(o.nodes)
+			o.nodes = append(o.nodes, orderNode{tID: i, xID: j, aID: BEGIN_NODE})
+			for k := 0; k < txn.allocID; k++ {
+				o.nodes = append(o.nodes, orderNode{tID: i, xID: j, aID: k}, orderNode{tID: i, xID: j, aID: k, done: true})
+			}
+			o.nodes = append(o.nodes, orderNode{tID: i, xID: j, aID: END_NODE})
+		}
+	}
+	o.edges = make([][]orderEdge, len(o.nodes))
+
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			// realtime order in timeline
+			begin, end := o.begin(i, j), o.end(i, j)
+			for n := begin; n < end-1; n++ {
+				o.connect(n, n+1, string(Realtime))
+			}
+			if j > 0 {
+				o.connect(o.end(i, j-1), begin, string(Realtime))
+			}
+			for _, depend := range txn.startIns {
+				o.connect(o.txnPoint(depend), begin, string(depend.tp))
+			}
+			// the txn in anomaly cycles may end by the expected deadlock once the first action which may abort executes,
+			// without waiting for the later actions and the end dependencies
+			if k := txn.firstMayAbort(); k >= 0 {
+				o.connect(o.exec(i, j, k), end, "abort")
+			} else {
+				o.connect(end-1, end, string(Realtime))
+				for _, depend := range txn.endIns {
+					o.connect(o.txnPoint(depend), end, string(depend.tp))
+				}
+			}
+			for k := 0; k < txn.allocID; k++ {
+				g.connectActionOrder(&o, txn.GetAction(k))
+			}
+		}
+	}
+	return &o
+}
+
+// connectActionOrder adds the orders before executing the action, the same as the waits in IterateGraph,
+// and the lock waits until the lock holders end
+func (g *Graph) connectActionOrder(o *orderGraph, action *Action) {
+	exec, done := o.exec(action.tID, action.xID, action.id), o.done(action.tID, action.xID, action.id)
+	if action.abortBlock != nil {
+		before := g.GetAction(action.abortBlock.tID, action.abortBlock.xID, action.abortBlock.aID)
+		o.connect(o.executed(before), exec, "abort block")
+		return
+	}
+	var holders []*Action
+	if action.tp.IsRead() && g.GetTimeline(action.tID).Isolation().StatementSnapshot() {
+		for _, depend := range action.ins {
+			if depend.tp != WR || (depend.tID == action.tID && depend.xID == action.xID) {
+				continue
+			}
+			o.connect(o.end(depend.tID, depend.xID), exec, "wr commit")
+		}
+	}
+	if g.isLock(action) {
+		for _, depend := range action.ins {
+			before := g.GetAction(depend.tID, depend.xID, depend.aID)
+			if g.isLock(before) {
+				o.connect(o.executed(before), exec, "lock dependency")
+				holders = append(holders, before)
+			}
+		}
+	}
+	if action.tp.IsWrite() && action.beforeLock != INVALID_DEPEND {
+		before := g.GetAction(action.beforeLock.tID, action.beforeLock.xID, action.beforeLock.aID)
+		o.connect(o.executed(before), exec, string(WW))
+		holders = append(holders, before)
+	}
+	// the busy writers abort instead of waiting, and the expected deadlocks end the txns in cycles early
+	if g.execMode == DatabaseLock || g.GetTxn(action.tID, action.xID).mayAbort() {
+		return
+	}
+	for _, holder := range holders {
+		if holder.kID == action.kID && (holder.tID != action.tID || holder.xID != action.xID) &&
+			!g.GetTxn(holder.tID, holder.xID).mayAbort() {
+			o.connect(o.end(holder.tID, holder.xID), done, "lock wait")
+		}
+	}
+}
+
+// mayAbort reports if the txn is in an anomaly cycle, which may be aborted by the expected deadlock
+func (t *Txn) mayAbort() bool {
+	return t.firstMayAbort() >= 0
+}
+
+// firstMayAbort returns the id of the first action which may be aborted by the expected deadlock, -1 if none
+func (t *Txn) firstMayAbort() int {
+	for i := 0; i < t.allocID; i++ {
+		if t.GetAction(i).mayAbortSelf {
+			return i
+		}
+	}
+	return -1
+}
+
+// Validate checks if the graph can be executed and its results can be inferred,
+// it returns InvalidGraphError with the problems found
+func (g *Graph) Validate() error {
+	o := g.newOrderGraph()
+	problems := o.cycles()
+	problems = append(problems, o.ambiguousWrites()...)
+	problems = append(problems, o.invisibleReads()...)
+	if len(problems) == 0 {
+		return nil
+	}
+	return &InvalidGraphError{Problems: problems}
+}
+
+// cycles reports a cycle in each group of nodes which wait for each other
+func (o *orderGraph) cycles() []Problem {
+	var (
+		problems []Problem
+		// 0: not visited, 1: in stack, 2: finished
+		state    = make([]int, len(o.nodes))
+		stack    []int
+		inEdge   = make([]string, len(o.nodes))
+		reported = make([]bool, len(o.nodes))
+		dfs      func(int)
+	)
+	dfs = func(n int) {
+		state[n] = 1
+		stack = append(stack, n)
+		for _, e := range o.edges[n] {
+			switch state[e.to] {
+			case 0:
+				inEdge[e.to] = e.tp
+				dfs(e.to)
+			case 1:
+				if !reported[e.to] {
+					problems = append(problems, o.cycleProblem(stack, inEdge, e, reported))
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[n] = 2
+	}
+	for n := range o.nodes {
+		if state[n] == 0 {
+			dfs(n)
+		}
+	}
+	return problems
+}
+
+func (o *orderGraph) cycleProblem(stack []int, inEdge []string, back orderEdge, reported []bool) Problem {
+	start := len(stack) - 1
+	for stack[start] != back.to {
+		start--
+	}
+	var (
+		b       strings.Builder
+		actions []Location
+	)
+	for _, n := range stack[start:] {
+		reported[n] = true
+		node := o.nodes[n]
+		if n != back.to {
+			fmt.Fprintf(&b, " -%s-> ", inEdge[n])
+		}
+		b.WriteString(node.String())
+		if node.aID >= 0 && !node.done {
+			actions = append(actions, Location{tID: node.tID, xID: node.xID, aID: node.aID})
+		}
+	}
+	fmt.Fprintf(&b, " -%s-> %s", back.tp, o.nodes[back.to])
+	return Problem{Tp: OrderCycle, Actions: actions, Detail: b.String()}
+}
+
+// ambiguousWrites reports the writes overwriting the same write without an order between them
+func (o *orderGraph) ambiguousWrites() []Problem {
+	var (
+		problems []Problem
+		g        = o.g
+		writes   = make(map[Depend][]*Action)
+		befores  []Depend
+	)
+	g.eachAction(func(action *Action) {
+		if !action.tp.IsWrite() || action.beforeLock == INVALID_DEPEND {
+			return
+		}
+		before := action.beforeLock
+		// the references may be left behind when an anomaly changes the key of action
+		if g.GetAction(before.tID, before.xID, before.aID).kID != action.kID {
+			return
+		}
+		if _, ok := writes[before]; !ok {
+			befores = append(befores, before)
+		}
+		writes[before] = append(writes[before], action)
+	})
+	for _, before := range befores {
+		after := writes[before]
+		for i := 0; i < len(after); i++ {
+			for j := i + 1; j < len(after); j++ {
+				a1, a2 := after[i], after[j]
+				if o.reach(o.exec(a1.tID, a1.xID, a1.id), o.exec(a2.tID, a2.xID, a2.id)) ||
+					o.reach(o.exec(a2.tID, a2.xID, a2.id), o.exec(a1.tID, a1.xID, a1.id)) {
+					continue
+				}
+				problems = append(problems, Problem{
+					Tp:      AmbiguousWW,
+					Actions: []Location{LocationFromAction(a1), LocationFromAction(a2)},
+					Detail: fmt.Sprintf("(%d, %d, %d) and (%d, %d, %d) overwrite (%d, %d, %d) on key %d in no order",
+						a1.tID, a1.xID, a1.id, a2.tID, a2.xID, a2.id, before.tID, before.xID, before.aID, a1.kID),
+				})
+			}
+		}
+	}
+	return problems
+}
+
+// invisibleReads reports the reads expecting a write which is not committed before, or overwritten before
+func (o *orderGraph) invisibleReads() []Problem {
+	var (
+		problems []Problem
+		g        = o.g
+		writes   = make(map[int][]*Action)
+		reads    []*Action
+	)
+	g.eachAction(func(action *Action) {
+		switch {
+		case action.tp.IsWrite():
+			writes[action.kID] = append(writes[action.kID], action)
+		case action.tp.IsRead():
+			reads = append(reads, action)
+		}
+	})
+	for _, read := range reads {
+		// the reads in anomaly cycles may not be executed, and the aborts rebase them during execution
+		if g.GetTxn(read.tID, read.xID).mayAbort() {
+			continue
+		}
+		expect, ok := g.expectedWrite(read, writes[read.kID])
+		if !ok {
+			continue
+		}
+		var (
+			exec = o.exec(read.tID, read.xID, read.id)
+			// the point where the read takes snapshot, locking reads see the latest committed values
+			snapshot = exec
+			reason   string
+			actions  = []Location{LocationFromAction(read)}
+			expectID = "nothing"
+		)
+		if !g.isLock(read) && !g.GetTimeline(read.tID).Isolation().StatementSnapshot() {
+			snapshot = o.begin(read.tID, read.xID)
+		}
+		sameTxn := func(action *Action) bool {
+			return action.tID == read.tID && action.xID == read.xID
+		}
+		if expect != nil {
+			actions = append([]Location{LocationFromAction(expect)}, actions...)
+			expectID = fmt.Sprintf("(%d, %d, %d)", expect.tID, expect.xID, expect.id)
+			switch {
+			case sameTxn(expect):
+				if expect.id > read.id {
+					reason = "is after it"
+				}
+			case g.GetTxn(expect.tID, expect.xID).status != Committed:
+				reason = "is not committed"
+			case o.reach(exec, o.end(expect.tID, expect.xID)):
+				reason = "is committed after it"
+			}
+		}
+		for _, write := range writes[read.kID] {
+			if reason != "" {
+				break
+			}
+			// the writes in anomaly cycles may be aborted
+			if write == expect || g.GetTxn(write.tID, write.xID).mayAbort() ||
+				(expect != nil && !o.reach(o.exec(expect.tID, expect.xID, expect.id), o.exec(write.tID, write.xID, write.id))) {
+				continue
+			}
+			visible := false
+			if sameTxn(write) {
+				visible = write.id < read.id
+			} else {
+				visible = g.GetTxn(write.tID, write.xID).status == Committed &&
+					o.reach(o.end(write.tID, write.xID), snapshot)
+			}
+			if visible {
+				reason = fmt.Sprintf("is overwritten by visible (%d, %d, %d)", write.tID, write.xID, write.id)
+				actions = append([]Location{LocationFromAction(write)}, actions...)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		problems = append(problems, Problem{
+			Tp:      InvisibleRead,
+			Actions: actions,
+			Detail: fmt.Sprintf("(%d, %d, %d) reads %s on key %d which %s",
+				read.tID, read.xID, read.id, expectID, read.kID, reason),
+		})
+	}
+	return problems
+}
+
+// expectedWrite returns the write of the value which the read expects, nil if it expects nothing,
+// the write is found by value if the reference is left behind by an anomaly, it returns false if not found
+func (g *Graph) expectedWrite(read *Action, writes []*Action) (*Action, bool) {
+	if read.beforeWrite != INVALID_DEPEND {
+		write := g.GetAction(read.beforeWrite.tID, read.beforeWrite.xID, read.beforeWrite.aID)
+		if write != nil && write.kID == read.kID {
+			return write, true
+		}
+	} else if read.vID == kv.NULL_VALUE_ID {
+		return nil, true
+	}
+	if read.vID == kv.NULL_VALUE_ID {
+		return nil, false
+	}
+	for _, write := range writes {
+		if write.vID == read.vID {
+			return write, true
+		}
+	}
+	return nil, false
+}
+
+func (g *Graph) eachAction(f func(*Action)) {
+	for i := 0; i < g.allocID; i++ {
+		timeline := g.GetTimeline(i)
+		for j := 0; j < timeline.allocID; j++ {
+			txn := timeline.GetTxn(j)
+			for k := 0; k < txn.allocID; k++ {
+				f(txn.GetAction(k))
+			}
+		}
+	}
+}
+
+// Repair removes the last involved action of a problem until the graph is valid,
+// it returns the error of Validate if the problems involve no action
+func (g *Graph) Repair() error {
+	for {
+		err := g.Validate()
+		if err == nil {
+			return nil
+		}
+		var problem *Problem
+		for i, p := range err.(*InvalidGraphError).Problems {
+			if len(p.Actions) > 0 {
+				problem = &err.(*InvalidGraphError).Problems[i]
+				break
+			}
+		}
+		if problem == nil {
+			return err
+		}
+		l := problem.Actions[len(problem.Actions)-1]
+		fmt.Printf("repair %s by removing (%d, %d, %d)\n", problem, l.tID, l.xID, l.aID)
+		g.RemoveAction(l.tID, l.xID, l.aID)
+	}
+}
@@ -0,0 +1,145 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WATCHDOG_INTERVAL is the interval of checking the wait-for graph of workers
+const WATCHDOG_INTERVAL = 500 * time.Millisecond
+
+// WATCHDOG_HANG_MARGIN is added to the statement timeout for the hang time of watchdog,
+// so that the hanging statement is diagnosed by the statement timeout with the lock views first
+const WATCHDOG_HANG_MARGIN = 30 * time.Second
+
+// WatchdogError is reported by the watchdog of IterateGraph when the workers can never make progress
+type WatchdogError struct {
+	// Unschedulable means the graph can't be executed in the expected order,
+	// the workers wait for each other, or the statements are blocked by the locks of waiting workers,
+	// otherwise the database hangs on the executing statements
+	Unschedulable bool
+	Report        string
+}
+
+func (e *WatchdogError) Error() string {
+	if e.Unschedulable {
+		return "unschedulable graph, the workers can't go on in the expected order\n" + e.Report
+	}
+	return "database hangs, the workers wait for the executing statements\n" + e.Report
+}
+
+// watchdog checks the wait-for graph of workers every WATCHDOG_INTERVAL until stop is closed,
+// fail is called when the workers can never make progress
+func (g *Graph) watchdog(stop <-chan struct{}, fail func(error)) {
+	ticker := time.NewTicker(WATCHDOG_INTERVAL)
+	defer ticker.Stop()
+	var (
+		lastChanges int64 = -1
+		lastChange        = time.Now()
+	)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		states, done, changes := g.workers.snapshot()
+		if changes != lastChanges {
+			lastChanges, lastChange = changes, time.Now()
+			continue
+		}
+		if err := g.stuck(states, done, time.Since(lastChange)); err != nil {
+			fail(err)
+			return
+		}
+	}
+}
+
+// stuck checks the worker states which are not changed for stalled,
+// it returns WatchdogError if the workers can never make progress
+func (g *Graph) stuck(states []workerState, done []bool, stalled time.Duration) error {
+	var waiting, executing []int
+	for i, state := range states {
+		switch {
+		case done[i]:
+		case state.waiting:
+			waiting = append(waiting, i)
+		case state.doing != "":
+			executing = append(executing, i)
+		default:
+			// the running worker changes its state soon
+			return nil
+		}
+	}
+	if len(waiting) == 0 {
+		return nil
+	}
+	// the events are only fired by workers, nothing changes if all of them are waiting
+	if len(executing) == 0 {
+		return &WatchdogError{Unschedulable: true, Report: g.waitForReport(states, done)}
+	}
+	hangTime := g.hangTime()
+	if hangTime == 0 || stalled < hangTime {
+		return nil
+	}
+	// the statements blocked by the locks of waiting workers are never finished by database
+	unschedulable := true
+	for _, i := range executing {
+		if !g.blockedByWaiting(states, states[i].action) {
+			unschedulable = false
+			break
+		}
+	}
+	return &WatchdogError{Unschedulable: unschedulable, Report: g.waitForReport(states, done)}
+}
+
+// hangTime is how long the workers make no progress with executing statements before the watchdog fails the execution,
+// it's 0 if the statement timeout is disabled, the lock waits may be as long as the database allows
+func (g *Graph) hangTime() time.Duration {
+	timeout := g.cfg.Global.StmtTimeout.Duration
+	if timeout == 0 {
+		return 0
+	}
+	return timeout + WATCHDOG_HANG_MARGIN
+}
+
+// blockedByWaiting reports if the action is blocked by the locks of waiting workers
+func (g *Graph) blockedByWaiting(states []workerState, action *Action) bool {
+	if action == nil || action.kID < 0 {
+		return false
+	}
+	for _, holder := range g.lockHolders(action) {
+		if states[holder.tID].waiting {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForReport reports the cycles in wait-for graph of waiting workers, followed by the diagnosis
+func (g *Graph) waitForReport(states []workerState, done []bool) string {
+	var b strings.Builder
+	// each waiting worker waits for one owner, a walk ends at a cycle or a worker not waiting
+	walked := make([]int, len(states))
+	for i := range walked {
+		walked[i] = -1
+	}
+	for start := range states {
+		i := start
+		for i >= 0 && walked[i] == -1 && states[i].waiting && !done[i] {
+			walked[i] = start
+			i = states[i].owner
+		}
+		if i < 0 || walked[i] != start {
+			continue
+		}
+		cycle := []string{fmt.Sprint(i)}
+		for j := states[i].owner; j != i; j = states[j].owner {
+			cycle = append(cycle, fmt.Sprint(j))
+		}
+		fmt.Fprintf(&b, "wait-for cycle: timeline %s -> %d\n", strings.Join(cycle, " -> "), i)
+	}
+	b.WriteString(g.describeWorkers(states, done))
+	return strings.TrimRight(b.String(), "\n")
+}
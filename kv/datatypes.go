@@ -46,8 +46,8 @@ const (
 	// JSON
 )
 
-func RdType() DataType {
-	return DataType(rand.Intn(int(Text)))
+func RdType(rd *rand.Rand) DataType {
+	return DataType(rd.Intn(int(Text)))
 }
 
 func (d DataType) String() string {
@@ -85,53 +85,53 @@ func (d DataType) String() string {
 	}
 }
 
-func (d DataType) RandValue() interface{} {
+func (d DataType) RandValue(rd *rand.Rand) interface{} {
 	switch d {
 	case TinyInt:
-		return util.RdRange(-128, 127)
+		return util.RdRange(rd, -128, 127)
 	// case SmallInt:
 	// 	return "SMALLINT"
 	// case MediumInt:
 	// 	return "MEDIUMINT"
 	case Int:
-		return util.RdRange(-2147483648, 2147483647)
+		return util.RdRange(rd, -2147483648, 2147483647)
 	case BigInt:
-		return util.RdRange(-9223372036854775808, 9223372036854775807)
+		return util.RdRange(rd, -9223372036854775808, 9223372036854775807)
 	case Date:
-		return util.RdDate()
+		return util.RdDate(rd)
 	case Datetime:
-		return util.RdDateTime()
+		return util.RdDateTime(rd)
 	case Timestamp:
-		return util.RdTimestamp()
+		return util.RdTimestamp(rd)
 	case Char:
-		return util.RdName()
+		return util.RdName(rd)
 	case Varchar:
-		return util.RdName()
+		return util.RdName(rd)
 	// case TinyText:
 	// 	return "TINYTEXT"
 	case Text:
-		return util.RdName()
+		return util.RdName(rd)
 	default:
 		panic(fmt.Sprintf("unimplement type %s", d))
 	}
 }
 
 // use default size by now
-func (d DataType) Size() int {
+func (d DataType) Size(rd *rand.Rand) int {
 	switch d {
 	case Varchar:
-		return util.RdRange(127, 511)
+		return util.RdRange(rd, 127, 511)
 	case Char:
-		return util.RdRange(31, 255)
+		return util.RdRange(rd, 31, 255)
 	}
 	return 0
 }
 
-func (d DataType) ToHashString(data interface{}) string {
+func (d DataType) ToHashString(rd *rand.Rand, data interface{}) string {
 	// null value will not lead to duplicated unique key
 	// here we use random hash string to avoid it
 	if _, ok := data.(Null); ok {
-		return util.RdHash()
+		return util.RdHash(rd)
 	}
 	switch d {
 	case TinyInt, Int, BigInt:
@@ -147,24 +147,10 @@ func (d DataType) ToHashString(data interface{}) string {
 	}
 }
 
+// ValToString returns the literal in standard SQL,
+// use Dialect.Literal for the database specified one
 func (d DataType) ValToString(data interface{}) string {
-	// null value will not lead to duplicated unique key
-	// here we use random hash string to avoid it
-	if _, ok := data.(Null); ok {
-		return "NULL"
-	}
-	switch d {
-	case TinyInt, Int, BigInt:
-		return strconv.Itoa(data.(int))
-	case Date:
-		return fmt.Sprintf(`"%s"`, data.(time.Time).Format(DATE_FORMAT))
-	case Datetime, Timestamp:
-		return fmt.Sprintf(`"%s"`, data.(time.Time).Format(DATETIME_FORMAT))
-	case Char, Varchar, Text:
-		return fmt.Sprintf(`"%s"`, data.(string))
-	default:
-		panic(fmt.Sprintf("unimplement type %s", d))
-	}
+	return literal(d, data, quoteString)
 }
 
 func (d DataType) ValToPureString(data interface{}) string {
@@ -0,0 +1,225 @@
+package kv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/you06/go-mikadzuki/config"
+)
+
+const (
+	TARGET_MYSQL    = config.TARGET_MYSQL
+	TARGET_TIDB     = config.TARGET_TIDB
+	TARGET_POSTGRES = config.TARGET_POSTGRES
+	TARGET_SQLITE   = config.TARGET_SQLITE
+	// TARGET_MEMORY is the in-memory reference database, which speaks MySQL
+	TARGET_MEMORY = config.TARGET_MEMORY
+)
+
+// Dialect renders the schema and statements in the syntax of a database
+type Dialect interface {
+	Name() string
+	// TypeName returns the column type, size is 0 when not specified
+	TypeName(tp DataType, size int) string
+	// Literal returns quoted and escaped value
+	Literal(tp DataType, data interface{}) string
+	// UniqueIndex returns the unique index definition in CREATE TABLE
+	UniqueIndex(table string, id int, columns []string) string
+	// FalseCondition is the WHERE condition which matches nothing
+	FalseCondition() string
+	// Replace returns the statement which deletes conflict row and inserts values
+	Replace(table string, values, primary, others []string) string
+	// Upsert appends update clause to insert statement
+	Upsert(insert string, primary, patches []string) string
+	// LockingRead is the suffix of locking read, empty if unsupported
+	LockingRead() string
+}
+
+// NewDialect returns dialect of given target
+func NewDialect(target string) Dialect {
+	switch target {
+	case TARGET_MYSQL, TARGET_MEMORY:
+		return MySQL{}
+	case TARGET_TIDB:
+		return TiDB{}
+	case TARGET_POSTGRES:
+		return PostgreSQL{}
+	case TARGET_SQLITE:
+		return SQLite{}
+	default:
+		panic(fmt.Sprintf("Unsupported target %s", target))
+	}
+}
+
+// literal formats integers as it is and quotes the others
+func literal(tp DataType, data interface{}, quote func(string) string) string {
+	if _, ok := data.(Null); ok {
+		return "NULL"
+	}
+	switch tp {
+	case TinyInt, Int, BigInt:
+		return strconv.Itoa(data.(int))
+	default:
+		return quote(tp.ValToPureString(data))
+	}
+}
+
+// quoteString quotes string in standard SQL
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func replaceInto(table string, values []string) string {
+	return fmt.Sprintf("REPLACE INTO %s VALUES(%s)", table, strings.Join(values, ", "))
+}
+
+func onConflict(insert string, primary, patches []string) string {
+	if len(patches) == 0 {
+		return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING", insert, strings.Join(primary, ", "))
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", insert, strings.Join(primary, ", "), strings.Join(patches, ", "))
+}
+
+func excluded(others []string) []string {
+	patches := make([]string, len(others))
+	for i, column := range others {
+		patches[i] = fmt.Sprintf("%s=EXCLUDED.%s", column, column)
+	}
+	return patches
+}
+
+type MySQL struct{}
+
+func (MySQL) Name() string {
+	return TARGET_MYSQL
+}
+
+func (MySQL) TypeName(tp DataType, size int) string {
+	if size > 0 {
+		return fmt.Sprintf("%s(%d)", tp, size)
+	}
+	return tp.String()
+}
+
+// Literal escapes backslash as well, so that it works without NO_BACKSLASH_ESCAPES
+func (MySQL) Literal(tp DataType, data interface{}) string {
+	return literal(tp, data, func(s string) string {
+		return quoteString(strings.ReplaceAll(s, `\`, `\\`))
+	})
+}
+
+func (MySQL) UniqueIndex(table string, id int, columns []string) string {
+	return fmt.Sprintf("UNIQUE u_%d(%s)", id, strings.Join(columns, ", "))
+}
+
+func (MySQL) FalseCondition() string {
+	return "0"
+}
+
+func (MySQL) Replace(table string, values, primary, others []string) string {
+	return replaceInto(table, values)
+}
+
+func (MySQL) Upsert(insert string, primary, patches []string) string {
+	return fmt.Sprintf("%s ON DUPLICATE KEY UPDATE %s", insert, strings.Join(patches, ", "))
+}
+
+func (MySQL) LockingRead() string {
+	return " FOR UPDATE"
+}
+
+// TiDB is compatible with MySQL protocol and syntax
+type TiDB struct {
+	MySQL
+}
+
+func (TiDB) Name() string {
+	return TARGET_TIDB
+}
+
+type PostgreSQL struct{}
+
+func (PostgreSQL) Name() string {
+	return TARGET_POSTGRES
+}
+
+func (PostgreSQL) TypeName(tp DataType, size int) string {
+	var name string
+	switch tp {
+	case TinyInt:
+		name = "SMALLINT"
+	case Datetime:
+		name = "TIMESTAMP"
+	default:
+		name = tp.String()
+	}
+	// display width of integers is not supported
+	if size > 0 && (tp == Char || tp == Varchar) {
+		return fmt.Sprintf("%s(%d)", name, size)
+	}
+	return name
+}
+
+func (PostgreSQL) Literal(tp DataType, data interface{}) string {
+	return literal(tp, data, quoteString)
+}
+
+// UniqueIndex names the constraint with table, since constraint names are unique in a PostgreSQL schema
+func (PostgreSQL) UniqueIndex(table string, id int, columns []string) string {
+	return fmt.Sprintf("CONSTRAINT %s_u_%d UNIQUE(%s)", table, id, strings.Join(columns, ", "))
+}
+
+func (PostgreSQL) FalseCondition() string {
+	return "FALSE"
+}
+
+// Replace upserts on primary key, the replaced value always shares the primary key
+func (PostgreSQL) Replace(table string, values, primary, others []string) string {
+	insert := fmt.Sprintf("INSERT INTO %s VALUES(%s)", table, strings.Join(values, ", "))
+	return onConflict(insert, primary, excluded(others))
+}
+
+func (PostgreSQL) Upsert(insert string, primary, patches []string) string {
+	return onConflict(insert, primary, patches)
+}
+
+func (PostgreSQL) LockingRead() string {
+	return " FOR UPDATE"
+}
+
+type SQLite struct{}
+
+func (SQLite) Name() string {
+	return TARGET_SQLITE
+}
+
+// TypeName keeps the MySQL type names, they are mapped to SQLite type affinities
+func (SQLite) TypeName(tp DataType, size int) string {
+	return MySQL{}.TypeName(tp, size)
+}
+
+func (SQLite) Literal(tp DataType, data interface{}) string {
+	return literal(tp, data, quoteString)
+}
+
+func (SQLite) UniqueIndex(table string, id int, columns []string) string {
+	return fmt.Sprintf("CONSTRAINT u_%d UNIQUE(%s)", id, strings.Join(columns, ", "))
+}
+
+func (SQLite) FalseCondition() string {
+	return "0"
+}
+
+func (SQLite) Replace(table string, values, primary, others []string) string {
+	return replaceInto(table, values)
+}
+
+func (SQLite) Upsert(insert string, primary, patches []string) string {
+	return onConflict(insert, primary, patches)
+}
+
+// LockingRead is empty because SQLite locks the whole database when writing
+func (SQLite) LockingRead() string {
+	return ""
+}
@@ -0,0 +1,187 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// schemaJSON is the serialized Schema, sets are sorted so that the output is stable
+type schemaJSON struct {
+	SchemaID   int         `json:"schema_id"`
+	Columns    []Column    `json:"columns"`
+	Primary    []int       `json:"primary"`
+	Unique     [][]int     `json:"unique"`
+	PrimarySet []string    `json:"primary_set"`
+	UniqueSet  [][]string  `json:"unique_set"`
+	AllocKID   int         `json:"alloc_kid"`
+	AllocVID   int         `json:"alloc_vid"`
+	VID2KID    map[int]int `json:"vid2kid"`
+	KVs        []kvJSON    `json:"kvs"`
+	// Data is encoded by column type, nil for NULL
+	Data [][]*string `json:"data"`
+}
+
+type kvJSON struct {
+	ID        int   `json:"id"`
+	Values    []int `json:"values"`
+	Latest    int   `json:"latest"`
+	DeleteVal int   `json:"delete_val"`
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	data := schemaJSON{
+		SchemaID:   s.SchemaID,
+		Columns:    s.Columns,
+		Primary:    s.Primary,
+		Unique:     s.Unique,
+		PrimarySet: sortedKeys(s.PrimarySet),
+		UniqueSet:  make([][]string, len(s.UniqueSet)),
+		AllocKID:   s.AllocKID,
+		AllocVID:   s.AllocVID,
+		VID2KID:    s.VID2KID,
+		KVs:        make([]kvJSON, len(s.KVs)),
+		Data:       make([][]*string, len(s.Data)),
+	}
+	for i, set := range s.UniqueSet {
+		data.UniqueSet[i] = sortedKeys(set)
+	}
+	for i, kv := range s.KVs {
+		values := make([]int, 0, len(kv.Values))
+		for v := range kv.Values {
+			values = append(values, v)
+		}
+		sort.Ints(values)
+		data.KVs[i] = kvJSON{
+			ID:        kv.ID,
+			Values:    values,
+			Latest:    kv.Latest,
+			DeleteVal: kv.DeleteVal,
+		}
+	}
+	for i, row := range s.Data {
+		data.Data[i] = make([]*string, len(row))
+		for j, value := range row {
+			data.Data[i][j] = encodeValue(value)
+		}
+	}
+	return json.Marshal(data)
+}
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var data schemaJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return errors.Trace(err)
+	}
+	s.SchemaID = data.SchemaID
+	s.Columns = data.Columns
+	s.Primary = data.Primary
+	s.Unique = data.Unique
+	s.PrimarySet = make(map[string]struct{}, len(data.PrimarySet))
+	for _, key := range data.PrimarySet {
+		s.PrimarySet[key] = struct{}{}
+	}
+	s.UniqueSet = make([]map[string]struct{}, len(data.UniqueSet))
+	for i, keys := range data.UniqueSet {
+		s.UniqueSet[i] = make(map[string]struct{}, len(keys))
+		for _, key := range keys {
+			s.UniqueSet[i][key] = struct{}{}
+		}
+	}
+	s.AllocKID = data.AllocKID
+	s.AllocVID = data.AllocVID
+	s.VID2KID = data.VID2KID
+	if s.VID2KID == nil {
+		s.VID2KID = make(map[int]int)
+	}
+	s.KVs = make([]KV, len(data.KVs))
+	for i, item := range data.KVs {
+		kv := NewKV(item.ID)
+		for _, v := range item.Values {
+			kv.Values[v] = struct{}{}
+		}
+		kv.Latest = item.Latest
+		kv.DeleteVal = item.DeleteVal
+		s.KVs[i] = kv
+	}
+	s.Data = make([][]interface{}, len(data.Data))
+	for i, row := range data.Data {
+		if len(row) != len(s.Columns) {
+			return errors.Errorf("data %d has %d values, expect %d", i, len(row), len(s.Columns))
+		}
+		s.Data[i] = make([]interface{}, len(row))
+		for j, value := range row {
+			v, err := decodeValue(s.Columns[j].Tp, value)
+			if err != nil {
+				return errors.Annotatef(err, "data %d column %d", i, j)
+			}
+			s.Data[i][j] = v
+		}
+	}
+	return nil
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeValue keeps the time as unix seconds, the same as how it's generated
+func encodeValue(value interface{}) *string {
+	var s string
+	switch v := value.(type) {
+	case Null:
+		return nil
+	case int:
+		s = strconv.Itoa(v)
+	case time.Time:
+		s = strconv.FormatInt(v.Unix(), 10)
+	case string:
+		s = v
+	default:
+		panic(fmt.Sprintf("unsupported value %v", value))
+	}
+	return &s
+}
+
+func decodeValue(tp DataType, value *string) (interface{}, error) {
+	if value == nil {
+		return Null{}, nil
+	}
+	switch tp {
+	case TinyInt, Int, BigInt:
+		v, err := strconv.Atoi(*value)
+		return v, errors.Trace(err)
+	case Date, Datetime, Timestamp:
+		sec, err := strconv.ParseInt(*value, 10, 64)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return time.Unix(sec, 0), nil
+	case Char, Varchar, Text:
+		return *value, nil
+	default:
+		return nil, errors.Errorf("unimplement type %s", tp)
+	}
+}
+
+// LoadSchema adds a serialized schema, rd is kept by the schema for generating values
+func (m *Manager) LoadSchema(b []byte, dialect Dialect, rd *rand.Rand) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, errors.Trace(err)
+	}
+	schema.dialect = dialect
+	schema.rd = rd
+	m.schemas = append(m.schemas, schema)
+	return &m.schemas[len(m.schemas)-1], nil
+}
@@ -202,10 +202,10 @@ func (ts *Txns) Rollback() {
 	}
 }
 
-func (ts *Txns) Rand() *Txn {
+func (ts *Txns) Rand(rd *rand.Rand) *Txn {
 	l := len(*ts)
 	if l == 0 {
 		panic("get rand txn from empty txns")
 	}
-	return (*ts)[rand.Intn(l)]
+	return (*ts)[rd.Intn(l)]
 }
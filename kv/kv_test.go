@@ -1,7 +1,9 @@
 package kv
 
 import (
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -9,6 +11,7 @@ import (
 var (
 	schema = Schema{
 		SchemaID: 1,
+		rd:       rand.New(rand.NewSource(1)),
 		Columns: []Column{
 			{
 				Name:    "id",
@@ -51,12 +54,20 @@ var (
 			},
 		},
 		Data: [][]interface{}{
-			{17, "kaeru", "2020-08-31"},
-			{18, "kaeru", "1919-08-10"},
+			{17, "kaeru", date("2020-08-31")},
+			{18, "kaeru", date("1919-08-10")},
 		},
 	}
 )
 
+func date(s string) time.Time {
+	d, err := time.Parse(DATE_FORMAT, s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
 func TestSchema(t *testing.T) {
 	require.Equal(t, schema.CreateTable(), `CREATE TABLE t1(
 id INT(11) NOT NULL,
@@ -70,19 +81,19 @@ func TestIfKeyDuplicated(t *testing.T) {
 	primaryKey := make([]string, 2)
 	uniqueKeys := make([][]string, 1)
 	var value []interface{}
-	value = []interface{}{17, "kaeru", "2020-08-31"}
+	value = []interface{}{17, "kaeru", date("2020-08-31")}
 	schema.MakePrimaryKey(value, &primaryKey)
 	schema.MakeUniqueKey(value, &uniqueKeys)
 	require.True(t, schema.IfKeyDuplicated(value, &primaryKey, &uniqueKeys))
-	value = []interface{}{17, "kaeru", "2020-08-17"}
+	value = []interface{}{17, "kaeru", date("2020-08-17")}
 	schema.MakePrimaryKey(value, &primaryKey)
 	schema.MakeUniqueKey(value, &uniqueKeys)
 	require.True(t, schema.IfKeyDuplicated(value, &primaryKey, &uniqueKeys))
-	value = []interface{}{10, "kaeru", "2020-08-31"}
+	value = []interface{}{10, "kaeru", date("2020-08-31")}
 	schema.MakePrimaryKey(value, &primaryKey)
 	schema.MakeUniqueKey(value, &uniqueKeys)
 	require.True(t, schema.IfKeyDuplicated(value, &primaryKey, &uniqueKeys))
-	value = []interface{}{10, "kaeru", "2020-08-17"}
+	value = []interface{}{10, "kaeru", date("2020-08-17")}
 	schema.MakePrimaryKey(value, &primaryKey)
 	schema.MakeUniqueKey(value, &uniqueKeys)
 	require.False(t, schema.IfKeyDuplicated(value, &primaryKey, &uniqueKeys))
@@ -92,30 +103,30 @@ func TestIfKeyDuplicated(t *testing.T) {
 
 func TestSelectSQL(t *testing.T) {
 	selectSQL := schema.SelectSQL(0)
-	require.True(t, selectSQL == `SELECT * FROM t1 WHERE id=17 AND val="kaeru"` ||
-		selectSQL == `SELECT * FROM t1 WHERE val="kaeru" AND k="2020-08-31"`)
+	require.True(t, selectSQL == `SELECT * FROM t1 WHERE id=17 AND val='kaeru'` ||
+		selectSQL == `SELECT * FROM t1 WHERE val='kaeru' AND k='2020-08-31'`)
 }
 
 func TestUpdateSQL(t *testing.T) {
 	updateSQL := schema.UpdateSQL(0, 1)
-	require.True(t, updateSQL == `UPDATE t1 SET id=18, k="1919-08-10" WHERE id=17 AND val="kaeru"` ||
-		updateSQL == `UPDATE t1 SET id=18, k="1919-08-10" WHERE val="kaeru" AND k="2020-08-31"`)
+	require.True(t, updateSQL == `UPDATE t1 SET id=18, k='1919-08-10' WHERE id=17 AND val='kaeru'` ||
+		updateSQL == `UPDATE t1 SET id=18, k='1919-08-10' WHERE val='kaeru' AND k='2020-08-31'`)
 }
 
 func TestDeleteSQL(t *testing.T) {
 	deleteSQL := schema.DeleteSQL(0)
-	require.True(t, deleteSQL == `DELETE FROM t1 WHERE id=17 AND val="kaeru"` ||
-		deleteSQL == `DELETE FROM t1 WHERE val="kaeru" AND k="2020-08-31"`)
+	require.True(t, deleteSQL == `DELETE FROM t1 WHERE id=17 AND val='kaeru'` ||
+		deleteSQL == `DELETE FROM t1 WHERE val='kaeru' AND k='2020-08-31'`)
 }
 
 func TestInsertSQL(t *testing.T) {
 	insertSQL := schema.InsertSQL(1)
-	require.Equal(t, insertSQL, `INSERT INTO t1 VALUES(18, "kaeru", "1919-08-10")`)
+	require.Equal(t, insertSQL, `INSERT INTO t1 VALUES(18, 'kaeru', '1919-08-10')`)
 }
 
 func TestInsertUpdateSQL(t *testing.T) {
 	insertUpdateSQL := schema.InsertUpdateSQL(0, 1)
-	require.Equal(t, insertUpdateSQL, `INSERT INTO t1 VALUES(18, "kaeru", "1919-08-10") ON DUPLICATE KEY UPDATE id=18, k="1919-08-10"`)
+	require.Equal(t, insertUpdateSQL, `INSERT INTO t1 VALUES(18, 'kaeru', '1919-08-10') ON DUPLICATE KEY UPDATE id=18, k='1919-08-10'`)
 }
 
 func TestReplace(t *testing.T) {
@@ -123,3 +134,28 @@ func TestReplace(t *testing.T) {
 	require.Equal(t, schema.Data[newID][0], 17)
 	require.Equal(t, schema.Data[newID][1], "kaeru")
 }
+
+func TestDialect(t *testing.T) {
+	pgSchema := schema
+	pgSchema.dialect = PostgreSQL{}
+	require.Equal(t, pgSchema.CreateTable(), `CREATE TABLE t1(
+id INT NOT NULL,
+val VARCHAR(255) NOT NULL,
+k DATE NULL,
+PRIMARY KEY(id, val),
+CONSTRAINT t1_u_0 UNIQUE(val, k))`)
+	require.Equal(t, pgSchema.SelectSQL(-1), `SELECT * FROM t1 WHERE FALSE`)
+	require.Equal(t, pgSchema.InsertSQL(1), `INSERT INTO t1 VALUES(18, 'kaeru', '1919-08-10')`)
+	require.Equal(t, pgSchema.ReplaceSQL(1), `INSERT INTO t1 VALUES(18, 'kaeru', '1919-08-10') ON CONFLICT (id, val) DO UPDATE SET k=EXCLUDED.k`)
+	require.Equal(t, pgSchema.InsertUpdateSQL(0, 1), `INSERT INTO t1 VALUES(18, 'kaeru', '1919-08-10') ON CONFLICT (id, val) DO UPDATE SET id=18, k='1919-08-10'`)
+
+	sqliteSchema := schema
+	sqliteSchema.dialect = SQLite{}
+	require.Equal(t, sqliteSchema.SelectForUpdateSQL(-1), `SELECT * FROM t1 WHERE 0`)
+	require.Equal(t, sqliteSchema.ReplaceSQL(1), `REPLACE INTO t1 VALUES(18, 'kaeru', '1919-08-10')`)
+
+	require.Equal(t, MySQL{}.Literal(Varchar, `it's \`), `'it''s \\'`)
+	require.Equal(t, PostgreSQL{}.Literal(Varchar, `it's \`), `'it''s \'`)
+	require.Equal(t, TiDB{}.Literal(Int, Null{}), "NULL")
+	require.Equal(t, NewDialect("tidb").Name(), "tidb")
+}
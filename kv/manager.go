@@ -1,6 +1,8 @@
 package kv
 
 import (
+	"math/rand"
+
 	"github.com/you06/go-mikadzuki/config"
 )
 
@@ -23,10 +25,13 @@ func (m *Manager) Reset() {
 	m.schemas = []Schema{}
 }
 
-func (m *Manager) NewSchema() *Schema {
+// NewSchema creates a random schema, rd is kept by the schema for generating values
+func (m *Manager) NewSchema(dialect Dialect, rd *rand.Rand) *Schema {
 	id := m.allocID
 	schema := Schema{
 		SchemaID:   id,
+		dialect:    dialect,
+		rd:         rd,
 		Columns:    []Column{},
 		Primary:    []int{},
 		Unique:     [][]int{},
@@ -2,6 +2,10 @@ package kv
 
 import (
 	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/juju/errors"
 )
@@ -34,8 +38,7 @@ func ParseFromSQLResult(rows *sql.Rows) ([][]*QueryItem, error) {
 				ValType: columnTypes[index],
 			}
 			if r != nil {
-				bytes := r.([]byte)
-				item.ValString = string(bytes)
+				item.ValString = valToString(r, columnTypes[index])
 			} else {
 				item.Null = true
 			}
@@ -45,3 +48,23 @@ func ParseFromSQLResult(rows *sql.Rows) ([][]*QueryItem, error) {
 	}
 	return result, nil
 }
+
+// valToString formats the scanned value, MySQL driver always returns bytes,
+// while other drivers may return typed values
+func valToString(r interface{}, columnType *sql.ColumnType) string {
+	switch v := r.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		if strings.ToUpper(columnType.DatabaseTypeName()) == "DATE" {
+			return v.Format(DATE_FORMAT)
+		}
+		return v.Format(DATETIME_FORMAT)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -1,7 +1,6 @@
 package kv
 
 import (
-	"database/sql"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -19,6 +18,8 @@ const (
 
 type Schema struct {
 	SchemaID   int
+	dialect    Dialect
+	rd         *rand.Rand
 	Columns    []Column
 	Primary    []int
 	Unique     [][]int
@@ -32,19 +33,19 @@ type Schema struct {
 }
 
 type Column struct {
-	Name    string
-	Tp      DataType
-	Size    int
-	Null    bool
-	Primary bool
+	Name    string   `json:"name"`
+	Tp      DataType `json:"tp"`
+	Size    int      `json:"size"`
+	Null    bool     `json:"null"`
+	Primary bool     `json:"primary"`
 }
 
 func (s *Schema) AddColumn(mustPrimary bool) {
-	tp := RdType()
-	notnull := mustPrimary || util.RdBool()
+	tp := RdType(s.rd)
+	notnull := mustPrimary || util.RdBool(s.rd)
 	primary := mustPrimary
 	if notnull && !mustPrimary {
-		primary = util.RdBoolRatio(PRIMARY_RATIO)
+		primary = util.RdBoolRatio(s.rd, PRIMARY_RATIO)
 	}
 	if primary {
 		// TODO: the key length should not over 3072 bytes
@@ -53,7 +54,7 @@ func (s *Schema) AddColumn(mustPrimary bool) {
 	column := Column{
 		Name:    fmt.Sprintf("col_%d", len(s.Columns)),
 		Tp:      tp,
-		Size:    tp.Size(),
+		Size:    tp.Size(s.rd),
 		Null:    !notnull,
 		Primary: primary,
 	}
@@ -65,12 +66,12 @@ func (s *Schema) AddColumn(mustPrimary bool) {
 func (s *Schema) AddUnique() {
 	var unique []int
 	for i := 0; i < len(s.Columns); i++ {
-		if util.RdBoolRatio(UNIQUE_RATIO) {
+		if util.RdBoolRatio(s.rd, UNIQUE_RATIO) {
 			unique = append(unique, i)
 		}
 	}
 	if len(unique) == 0 {
-		unique = append(unique, rand.Intn(len(s.Columns)))
+		unique = append(unique, s.rd.Intn(len(s.Columns)))
 	}
 	s.Unique = append(s.Unique, unique)
 	s.UniqueSet = append(s.UniqueSet, make(map[string]struct{}))
@@ -84,11 +85,7 @@ func (s *Schema) CreateTable() string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "CREATE TABLE %s(\n", s.TableName())
 	for i, column := range s.Columns {
-		if column.Size > 0 {
-			fmt.Fprintf(&b, "%s %s(%d)", column.Name, column.Tp, column.Size)
-		} else {
-			fmt.Fprintf(&b, "%s %s", column.Name, column.Tp)
-		}
+		fmt.Fprintf(&b, "%s %s", column.Name, s.Dialect().TypeName(column.Tp, column.Size))
 		if !column.Null {
 			b.WriteString(" NOT")
 		}
@@ -114,7 +111,7 @@ func (s *Schema) CreateTable() string {
 		for j := 0; j < us; j++ {
 			columns[j] = s.Columns[unique[j]].Name
 		}
-		indexes = append(indexes, fmt.Sprintf("UNIQUE u_%d(%s)", i, strings.Join(columns, ", ")))
+		indexes = append(indexes, s.Dialect().UniqueIndex(s.TableName(), i, columns))
 	}
 
 	for _, index := range indexes {
@@ -210,7 +207,7 @@ func (s *Schema) DelUniqueKeys(uniqueKeys [][]string) {
 func (s *Schema) MakePrimaryKey(value []interface{}, primaryKey *[]string) {
 	for i := 0; i < len(s.Primary); i++ {
 		pos := s.Primary[i]
-		(*primaryKey)[i] = s.Columns[pos].Tp.ToHashString(value[pos])
+		(*primaryKey)[i] = s.Columns[pos].Tp.ToHashString(s.rd, value[pos])
 	}
 }
 
@@ -233,7 +230,7 @@ func (s *Schema) AssignPrimaryKeyPart(oldValue, newValue *[]interface{}) {
 }
 
 func (s *Schema) AssignUniqueKey(oldValue, newValue *[]interface{}) {
-	unique := s.Unique[util.RdRange(0, len(s.Unique))]
+	unique := s.Unique[util.RdRange(s.rd, 0, len(s.Unique))]
 	for i := 0; i < len(unique); i++ {
 		pos := unique[i]
 		(*newValue)[pos] = (*oldValue)[pos]
@@ -245,7 +242,7 @@ func (s *Schema) MakeUniqueKey(value []interface{}, uniqueKeys *[][]string) {
 		uniqueKey := make([]string, len(s.Unique[i]))
 		for j := 0; j < len(s.Unique[i]); j++ {
 			pos := s.Unique[i][j]
-			uniqueKey[j] = s.Columns[pos].Tp.ToHashString(value[pos])
+			uniqueKey[j] = s.Columns[pos].Tp.ToHashString(s.rd, value[pos])
 		}
 		(*uniqueKeys)[i] = uniqueKey
 	}
@@ -255,7 +252,7 @@ func (s *Schema) MakeValue() []interface{} {
 	cols := len(s.Columns)
 	value := make([]interface{}, cols)
 	for i := 0; i < cols; i++ {
-		value[i] = s.Columns[i].Tp.RandValue()
+		value[i] = s.Columns[i].Tp.RandValue(s.rd)
 	}
 	return value
 }
@@ -395,12 +392,12 @@ func (s *Schema) DeleteValue(vID int) {
 func (s *Schema) SelectSQL(id int) string {
 	util.AssertNE(id, INVALID_VALUE_ID)
 	if id == -1 {
-		return fmt.Sprintf("SELECT * FROM %s WHERE 0", s.TableName())
+		return fmt.Sprintf("SELECT * FROM %s WHERE %s", s.TableName(), s.Dialect().FalseCondition())
 	}
 	data := s.Data[id]
 	var b strings.Builder
 	fmt.Fprintf(&b, "SELECT * FROM %s WHERE ", s.TableName())
-	indexID := rand.Intn(1 + len(s.Unique))
+	indexID := s.rd.Intn(1 + len(s.Unique))
 	var indexes []int
 	if indexID == 0 {
 		indexes = s.Primary
@@ -411,13 +408,13 @@ func (s *Schema) SelectSQL(id int) string {
 		if i != 0 {
 			b.WriteString(" AND ")
 		}
-		fmt.Fprintf(&b, "%s=%s", s.Columns[index].Name, s.Columns[index].Tp.ValToString(data[index]))
+		fmt.Fprintf(&b, "%s=%s", s.Columns[index].Name, s.Dialect().Literal(s.Columns[index].Tp, data[index]))
 	}
 	return b.String()
 }
 
 func (s *Schema) SelectForUpdateSQL(id int) string {
-	return s.SelectSQL(id) + " FOR UPDATE"
+	return s.SelectSQL(id) + s.Dialect().LockingRead()
 }
 
 func (s *Schema) UpdateSQL(oldID, newID int) string {
@@ -432,14 +429,14 @@ func (s *Schema) UpdateSQL(oldID, newID int) string {
 	var patches []string
 	for i := 0; i < ds; i++ {
 		if oldData[i] != newData[i] {
-			patches = append(patches, fmt.Sprintf("%s=%s", s.Columns[i].Name, s.Columns[i].Tp.ValToString(newData[i])))
+			patches = append(patches, fmt.Sprintf("%s=%s", s.Columns[i].Name, s.Dialect().Literal(s.Columns[i].Tp, newData[i])))
 		}
 	}
 	b.WriteString(strings.Join(patches, ", "))
 
 	b.WriteString(" WHERE ")
 
-	indexID := rand.Intn(1 + len(s.Unique))
+	indexID := s.rd.Intn(1 + len(s.Unique))
 	var indexes []int
 	if indexID == 0 {
 		indexes = s.Primary
@@ -450,19 +447,19 @@ func (s *Schema) UpdateSQL(oldID, newID int) string {
 		if i != 0 {
 			b.WriteString(" AND ")
 		}
-		fmt.Fprintf(&b, "%s=%s", s.Columns[index].Name, s.Columns[index].Tp.ValToString(oldData[index]))
+		fmt.Fprintf(&b, "%s=%s", s.Columns[index].Name, s.Dialect().Literal(s.Columns[index].Tp, oldData[index]))
 	}
 	return b.String()
 }
 
 func (s *Schema) DeleteSQL(id int) string {
 	if id == -1 {
-		return fmt.Sprintf("DELETE FROM %s WHERE 0", s.TableName())
+		return fmt.Sprintf("DELETE FROM %s WHERE %s", s.TableName(), s.Dialect().FalseCondition())
 	}
 	data := s.Data[id]
 	var b strings.Builder
 	fmt.Fprintf(&b, "DELETE FROM %s WHERE ", s.TableName())
-	indexID := rand.Intn(1 + len(s.Unique))
+	indexID := s.rd.Intn(1 + len(s.Unique))
 	var indexes []int
 	if indexID == 0 {
 		indexes = s.Primary
@@ -473,7 +470,7 @@ func (s *Schema) DeleteSQL(id int) string {
 		if i != 0 {
 			b.WriteString(" AND ")
 		}
-		fmt.Fprintf(&b, "%s=%s", s.Columns[index].Name, s.Columns[index].Tp.ValToString(data[index]))
+		fmt.Fprintf(&b, "%s=%s", s.Columns[index].Name, s.Dialect().Literal(s.Columns[index].Tp, data[index]))
 	}
 	return b.String()
 }
@@ -486,7 +483,7 @@ func (s *Schema) InsertSQL(id int) string {
 		if i != 0 {
 			b.WriteString(", ")
 		}
-		b.WriteString(s.Columns[i].Tp.ValToString(item))
+		b.WriteString(s.Dialect().Literal(s.Columns[i].Tp, item))
 	}
 	b.WriteString(")")
 	return b.String()
@@ -494,16 +491,21 @@ func (s *Schema) InsertSQL(id int) string {
 
 func (s *Schema) ReplaceSQL(id int) string {
 	data := s.Data[id]
-	var b strings.Builder
-	fmt.Fprintf(&b, "REPLACE INTO %s VALUES(", s.TableName())
+	values := make([]string, len(data))
 	for i, item := range data {
-		if i != 0 {
-			b.WriteString(", ")
+		values[i] = s.Dialect().Literal(s.Columns[i].Tp, item)
+	}
+	primary := make(map[int]struct{}, len(s.Primary))
+	for _, pos := range s.Primary {
+		primary[pos] = struct{}{}
+	}
+	var others []string
+	for i, column := range s.Columns {
+		if _, ok := primary[i]; !ok {
+			others = append(others, column.Name)
 		}
-		b.WriteString(s.Columns[i].Tp.ValToString(item))
 	}
-	b.WriteString(")")
-	return b.String()
+	return s.Dialect().Replace(s.TableName(), values, s.primaryColumns(), others)
 }
 
 func (s *Schema) InsertUpdateSQL(oldID, newID int) string {
@@ -511,26 +513,35 @@ func (s *Schema) InsertUpdateSQL(oldID, newID int) string {
 		return s.ReplaceSQL(newID)
 	}
 	oldData, newData := s.Data[oldID], s.Data[newID]
-	var b strings.Builder
-	b.WriteString(s.InsertSQL(newID))
-	b.WriteString(" ON DUPLICATE KEY UPDATE ")
 
 	ds := len(s.Columns)
 	var patches []string
 	for i := 0; i < ds; i++ {
 		if oldData[i] != newData[i] {
-			patches = append(patches, fmt.Sprintf("%s=%s", s.Columns[i].Name, s.Columns[i].Tp.ValToString(newData[i])))
+			patches = append(patches, fmt.Sprintf("%s=%s", s.Columns[i].Name, s.Dialect().Literal(s.Columns[i].Tp, newData[i])))
 		}
 	}
-	b.WriteString(strings.Join(patches, ", "))
-	return b.String()
+	return s.Dialect().Upsert(s.InsertSQL(newID), s.primaryColumns(), patches)
 }
 
-func (s *Schema) CompareData(vID int, rows *sql.Rows) (bool, error) {
-	data, err := ParseFromSQLResult(rows)
-	if err != nil {
-		return false, err
+// Dialect returns the dialect of schema, MySQL by default
+func (s *Schema) Dialect() Dialect {
+	if s.dialect == nil {
+		return MySQL{}
 	}
+	return s.dialect
+}
+
+func (s *Schema) primaryColumns() []string {
+	columns := make([]string, len(s.Primary))
+	for i, pos := range s.Primary {
+		columns[i] = s.Columns[pos].Name
+	}
+	return columns
+}
+
+// CompareData checks if the rows parsed by ParseFromSQLResult are the data of vID
+func (s *Schema) CompareData(vID int, data [][]*QueryItem) (bool, error) {
 	if vID == NULL_VALUE_ID {
 		if len(data) != 0 {
 			return false, fmt.Errorf("expect read nothing, but got %d rows", len(data))
@@ -550,6 +561,10 @@ func (s *Schema) CompareData(vID int, rows *sql.Rows) (bool, error) {
 	)
 	for i, column := range s.Columns {
 		left, right := data[0][i].ValString, column.Tp.ValToPureString(correct[i])
+		if column.Tp == Char {
+			// PostgreSQL returns CHAR with padding spaces
+			left = strings.TrimRight(left, " ")
+		}
 		if left != right {
 			same = false
 			errMsg = fmt.Sprintf("expect %s, got %s", left, right)
@@ -575,7 +590,7 @@ func (s *Schema) GetData(vID int) string {
 		if i != 0 {
 			b.WriteString(", ")
 		}
-		b.WriteString(column.Tp.ValToString(data[i]))
+		b.WriteString(s.Dialect().Literal(column.Tp, data[i]))
 	}
 
 	return b.String()
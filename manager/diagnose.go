@@ -0,0 +1,133 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/db"
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+// LOCK_VIEW_TIMEOUT limits the queries of lock views, the server may hang as well
+const LOCK_VIEW_TIMEOUT = 10 * time.Second
+
+// lockViews are the queries of the server's lock views for each target
+var lockViews = map[string][]string{
+	kv.TARGET_MYSQL: {
+		"SELECT * FROM information_schema.INNODB_TRX",
+		"SELECT * FROM performance_schema.data_lock_waits",
+	},
+	kv.TARGET_TIDB: {
+		"SELECT * FROM information_schema.TIDB_TRX",
+		"SELECT * FROM information_schema.DATA_LOCK_WAITS",
+	},
+	kv.TARGET_POSTGRES: {
+		"SELECT * FROM pg_locks WHERE NOT granted",
+		"SELECT pid, state, wait_event_type, wait_event, query FROM pg_stat_activity WHERE datname = current_database()",
+	},
+}
+
+// stmtContext returns the context of a statement, which is limited by the statement timeout
+func (m *Manager) stmtContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := m.cfg.Global.StmtTimeout.Duration; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// endTxn commits or rolls back by end within the statement timeout,
+// database/sql can't stop them by context, so the hanging one is left behind
+func (m *Manager) endTxn(end func() error) error {
+	timeout := m.cfg.Global.StmtTimeout.Duration
+	if timeout == 0 {
+		return end()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- end()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errors.Trace(context.DeadlineExceeded)
+	}
+}
+
+// hangError is the error of a statement running over the statement timeout,
+// it reports what the timelines are blocked on and the lock views of server if enabled
+func (m *Manager) hangError(conn db.DB, g *graph.Graph, tID int, stmt string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "thread %d hangs over %s in %s\n", tID, m.cfg.Global.StmtTimeout.Duration, stmt)
+	b.WriteString(g.Diagnose())
+	if m.cfg.Global.LockViews {
+		m.dumpLockViews(conn, &b)
+	}
+	diagnosis := strings.TrimRight(b.String(), "\n")
+	fmt.Println(diagnosis)
+	return errors.Annotate(context.DeadlineExceeded, diagnosis)
+}
+
+// dumpLockViews writes the rows of lock views in "column=value" format
+func (m *Manager) dumpLockViews(conn db.DB, b *strings.Builder) {
+	queries, ok := lockViews[m.cfg.Global.Target]
+	if !ok {
+		fmt.Fprintf(b, "lock views are not supported by %s\n", m.cfg.Global.Target)
+		return
+	}
+	for _, query := range queries {
+		fmt.Fprintf(b, "%s:\n", query)
+		if err := queryLockView(conn, query, b); err != nil {
+			fmt.Fprintf(b, "  query failed %v\n", err)
+		}
+	}
+}
+
+func queryLockView(conn db.DB, query string, b *strings.Builder) error {
+	// the lock views are read in a txn for the statement timeout
+	ctx, cancel := context.WithTimeout(context.Background(), LOCK_VIEW_TIMEOUT)
+	defer cancel()
+	txn, err := conn.Begin(nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		_ = txn.Rollback()
+	}()
+	rows, err := txn.QueryContext(ctx, query)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return errors.Trace(err)
+		}
+		b.WriteString(" ")
+		for i, column := range columns {
+			value := "NULL"
+			if values[i].Valid {
+				value = values[i].String
+			}
+			fmt.Fprintf(b, " %s=%s", column, value)
+		}
+		b.WriteString("\n")
+	}
+	return errors.Trace(rows.Err())
+}
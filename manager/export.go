@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/juju/errors"
+)
+
+// export formats of merged thread logs
+const (
+	// EXPORT_SQLZ marks the thread of each statement in a comment
+	EXPORT_SQLZ = "sqlz"
+	// EXPORT_SQL is the plain statements
+	EXPORT_SQL = "sql"
+	// EXPORT_MYSQLTEST is a mysql-test case, see ExportMySQLTest
+	EXPORT_MYSQLTEST = "mysqltest"
+)
+
+// Export merges the thread logs in logPath by start time and writes the interleaving as sqlz or sql into w,
+// the failed and unfinished statements are skipped because they are rejected by database
+func Export(logPath, format string, w io.Writer) error {
+	var pattern string
+	switch format {
+	case EXPORT_SQLZ:
+		pattern = "/* %[1]s */ %[2]s;\n"
+	case EXPORT_SQL:
+		pattern = "%[2]s;\n"
+	default:
+		return errors.Errorf("unsupported export format %s", format)
+	}
+	writer := bufio.NewWriter(w)
+	if err := mergeThreadLogs(logPath, nil, func(record *threadRecord) error {
+		if !record.status {
+			return nil
+		}
+		_, err := fmt.Fprintf(writer, pattern, connectionName(record.thread), record.sql)
+		return err
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(writer.Flush())
+}
+
+func connectionName(thread int) string {
+	return fmt.Sprintf("thread_%d", thread)
+}
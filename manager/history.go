@@ -0,0 +1,170 @@
+package manager
+
+import (
+	"path"
+
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/check"
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+// LoadHistory builds the executed txns from graph.json and thread logs in logPath,
+// the statements are matched with the actions in graph by the recorded action ids in JSONL logs,
+// or by SQL in text logs to know the keys and values.
+// The lock SQLs and the SQLs regenerated during execution are not matched by SQL, they are left out.
+// The observed values of reads are found by comparing the recorded rows with the values written to the same key.
+func (m *Manager) LoadHistory(logPath string) (*check.History, error) {
+	g, err := m.LoadGraph(path.Join(logPath, "graph.json"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	records, err := readThreadRecords(logPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var (
+		h      check.History
+		values = writtenValues(g)
+	)
+	for tID := 0; tID < g.TimelineNum(); tID++ {
+		var (
+			timeline = g.GetTimeline(tID)
+			matches  = matchRecords(g, tID, records[tID])
+			txn      *check.Txn
+		)
+		finish := func() {
+			if txn != nil {
+				h.Txns = append(h.Txns, *txn)
+				txn = nil
+			}
+		}
+		for i, record := range records[tID] {
+			switch {
+			case record.tp.IsTxnBegin():
+				// an aborted txn ends without Commit or Rollback
+				finish()
+				txn = &check.Txn{
+					Thread:    tID,
+					ID:        matches[i].xID,
+					Isolation: timeline.Isolation(),
+					Start:     record.startTime,
+					End:       record.endTime,
+				}
+			case record.tp.IsTxnEnd():
+				if txn == nil {
+					continue
+				}
+				txn.End = record.endTime
+				txn.Committed = record.tp == graph.Commit && record.status
+				finish()
+			default:
+				if txn == nil {
+					continue
+				}
+				txn.End = record.endTime
+				action := matches[i].action
+				if action == nil || !record.status {
+					continue
+				}
+				op := check.Op{
+					Key: action.KID(),
+					SQL: record.sql,
+				}
+				if action.Tp().IsRead() {
+					op.Tp, op.Value = check.Read, observedValue(g.Schema(), values[action.KID()], record.rows)
+				} else {
+					op.Tp, op.Value = check.Write, action.VID()
+				}
+				txn.Ops = append(txn.Ops, op)
+			}
+		}
+		finish()
+	}
+	return &h, nil
+}
+
+// recordMatch is the txn and action of a statement in thread logs, action is nil if it's not matched
+type recordMatch struct {
+	xID    int
+	action *graph.Action
+}
+
+// matchRecords matches the statements of thread tID with the actions in graph by the recorded ids in JSONL logs,
+// in text logs, txns are counted by Begin and actions are matched by SQL
+func matchRecords(g *graph.Graph, tID int, records []SQLLog) []recordMatch {
+	var (
+		timeline = g.GetTimeline(tID)
+		matches  = make([]recordMatch, len(records))
+		xID      = -1
+		aID      = 0
+	)
+	for i, record := range records {
+		switch {
+		case record.tp.IsTxnBegin():
+			// empty txns are not executed
+			for xID++; timeline.GetTxn(xID) != nil && timeline.GetTxn(xID).GetAction(0) == nil; xID++ {
+			}
+			aID = 0
+		case record.tp.IsTxnEnd():
+		case record.aID >= 0:
+			matches[i].action = g.GetAction(tID, record.xID, record.aID)
+		case xID >= 0:
+			if action, next := matchAction(timeline.GetTxn(xID), aID, record.sql); action != nil {
+				matches[i].action, aID = action, next
+			}
+		}
+		matches[i].xID = xID
+		if record.xID >= 0 {
+			matches[i].xID = record.xID
+		}
+	}
+	return matches
+}
+
+// matchAction finds the first action from aID in txn which has the same SQL, and the position after it
+func matchAction(txn *graph.Txn, aID int, sql string) (*graph.Action, int) {
+	if txn == nil {
+		return nil, aID
+	}
+	for ; txn.GetAction(aID) != nil; aID++ {
+		if action := txn.GetAction(aID); action.SQL == sql {
+			return action, aID + 1
+		}
+	}
+	return nil, aID
+}
+
+// writtenValues lists the values written to each key in graph
+func writtenValues(g *graph.Graph) map[int][]int {
+	values := make(map[int][]int)
+	for tID := 0; tID < g.TimelineNum(); tID++ {
+		timeline := g.GetTimeline(tID)
+		for xID := 0; timeline.GetTxn(xID) != nil; xID++ {
+			txn := timeline.GetTxn(xID)
+			for aID := 0; txn.GetAction(aID) != nil; aID++ {
+				action := txn.GetAction(aID)
+				if action.Tp().IsWrite() && action.VID() != kv.NULL_VALUE_ID {
+					values[action.KID()] = append(values[action.KID()], action.VID())
+				}
+			}
+		}
+	}
+	return values
+}
+
+// observedValue finds the value of rows from the written values, it's unknown if the rows are not recorded or not found
+func observedValue(schema *kv.Schema, values []int, rows [][]*kv.QueryItem) int {
+	if rows == nil {
+		return check.UNKNOWN_VALUE
+	}
+	if len(rows) == 0 {
+		return kv.NULL_VALUE_ID
+	}
+	for _, vID := range values {
+		if same, _ := schema.CompareData(vID, rows); same {
+			return vID
+		}
+	}
+	return check.UNKNOWN_VALUE
+}
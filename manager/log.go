@@ -2,17 +2,21 @@ package manager
 
 import (
 	"bufio"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/db"
 	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/kv"
 	"github.com/you06/go-mikadzuki/util"
 )
 
@@ -20,13 +24,19 @@ const LOGTIME_FORMAT = "2006-01-02 15:04:05.00000"
 
 var (
 	EMPTY_TIME    = time.Time{}
-	TIME_MAX      = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
-	threadPattern = regexp.MustCompile(`thread-(\d+)\.log`)
-	startPattern  = regexp.MustCompile(`.*\[(\d{4}-\d{2}-\d{2}\s\d{2}:\d{2}:\d{2}\.\d{5}).*`)
+	threadPattern = regexp.MustCompile(`^thread-(\d+)\.(log|jsonl)$`)
+	// recordPattern matches a statement in thread logs, the error of a failed statement may take multiple lines
+	recordPattern = regexp.MustCompile(`(?s)^\[(SUCCESS|UNFINISHED|FAILED (.*))\] \[(\w+)\] \[(\d{4}-\d{2}-\d{2}\s\d{2}:\d{2}:\d{2}\.\d{5})-(\d{4}-\d{2}-\d{2}\s\d{2}:\d{2}:\d{2}\.\d{5})\] (.*)$`)
+	// resultPattern matches the result appended to the SQL as a comment, rows are in JSON with null for NULL
+	resultPattern = regexp.MustCompile(`^(.*) -- (rows|affected): (.*)$`)
 )
 
 func (m *Manager) DumpGraph(g *graph.Graph, startTime string) {
-	logPath := path.Join(m.cfg.Global.LogPath, startTime)
+	WriteGraph(g, path.Join(m.cfg.Global.LogPath, startTime))
+}
+
+// WriteGraph writes graph.txt, graph.dot and graph.mmd for reading and graph.json for loading into logPath
+func WriteGraph(g *graph.Graph, logPath string) {
 	if err := os.MkdirAll(logPath, 0755); err != nil {
 		fmt.Println("error create log dir", err)
 		return
@@ -37,7 +47,7 @@ func (m *Manager) DumpGraph(g *graph.Graph, startTime string) {
 		return
 	}
 	graphWriter := bufio.NewWriter(graphFile)
-	if _, err := graphWriter.WriteString(g.String()); err != nil {
+	if _, err := graphWriter.WriteString(fmt.Sprintf("seed: %d\n\n%s", g.Seed(), g.String())); err != nil {
 		fmt.Println("write graph log failed")
 		return
 	}
@@ -49,18 +59,39 @@ func (m *Manager) DumpGraph(g *graph.Graph, startTime string) {
 		fmt.Println("close graph log failed")
 		return
 	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		fmt.Println("marshal graph failed", err)
+		return
+	}
+	if err := ioutil.WriteFile(path.Join(logPath, "graph.json"), data, 0644); err != nil {
+		fmt.Println("write graph json failed", err)
+		return
+	}
+	if err := ioutil.WriteFile(path.Join(logPath, "graph.dot"), []byte(g.DOT()), 0644); err != nil {
+		fmt.Println("write graph dot failed", err)
+		return
+	}
+	if err := ioutil.WriteFile(path.Join(logPath, "graph.mmd"), []byte(g.Mermaid()), 0644); err != nil {
+		fmt.Println("write graph mermaid failed", err)
+		return
+	}
 }
 
 func (m *Manager) DumpResult(logs *ExecutionLog, startTime string) {
 	logPath := path.Join(m.cfg.Global.LogPath, startTime)
 	for i := 0; i < logs.thread; i++ {
-		logFile, err := os.Create(path.Join(logPath, fmt.Sprintf("thread-%d.log", i)))
+		name, content := fmt.Sprintf("thread-%d.log", i), logs.LogN(i)
+		if m.cfg.Global.LogFormat == config.LOG_FORMAT_JSONL {
+			name, content = fmt.Sprintf("thread-%d.jsonl", i), logs.LogJSONL(i)
+		}
+		logFile, err := os.Create(path.Join(logPath, name))
 		if err != nil {
 			fmt.Printf("create thread-%d log failed\n", i)
 			continue
 		}
 		logWriter := bufio.NewWriter(logFile)
-		if _, err := logWriter.WriteString(logs.LogN(i)); err != nil {
+		if _, err := logWriter.WriteString(content); err != nil {
 			fmt.Printf("write thread-%d log failed\n", i)
 			continue
 		}
@@ -88,8 +119,49 @@ type SQLLog struct {
 	sql       string
 	status    bool
 	err       error
+	// code is the error code reported by database driver
+	code string
+	// xID is the txn of statement, aID, kID and vID are -1 if it's not an action in graph,
+	// they are not recorded in text format
+	xID int
+	aID int
+	kID int
+	vID int
+	// rows is the result of a successful read, nil if not recorded
+	rows [][]*kv.QueryItem
+	// affected is the number of affected rows of a successful write, -1 if not recorded
+	affected int64
 }
 
+// jsonLog is a line of thread logs in JSONL format, times are unix nanoseconds, end is 0 if unfinished
+type jsonLog struct {
+	Version  int            `json:"version"`
+	Thread   int            `json:"thread"`
+	Txn      int            `json:"txn"`
+	Action   int            `json:"action"`
+	KID      int            `json:"kid"`
+	VID      int            `json:"vid"`
+	Tp       graph.ActionTp `json:"type"`
+	SQL      string         `json:"sql"`
+	Start    int64          `json:"start"`
+	End      int64          `json:"end"`
+	Status   string         `json:"status"`
+	Error    string         `json:"error,omitempty"`
+	Code     string         `json:"code,omitempty"`
+	Rows     [][]*string    `json:"rows"`
+	Affected int64          `json:"affected"`
+}
+
+// JSONL_VERSION is the version of jsonLog, it's increased when the fields change incompatibly
+const JSONL_VERSION = 1
+
+// statuses of statements in JSONL format
+const (
+	STATUS_SUCCESS    = "success"
+	STATUS_FAILED     = "failed"
+	STATUS_UNFINISHED = "unfinished"
+)
+
 func NewExecutionLog(thread, action int) *ExecutionLog {
 	e := ExecutionLog{
 		thread: thread,
@@ -99,15 +171,25 @@ func NewExecutionLog(thread, action int) *ExecutionLog {
 	return &e
 }
 
-func (e *ExecutionLog) LogStart(tID int, tp graph.ActionTp, sql string) int {
-	e.logs[tID] = append(e.logs[tID], SQLLog{
+// LogStart records the start of a statement in txn xID, action is nil for txn statements and locks
+func (e *ExecutionLog) LogStart(tID, xID int, action *graph.Action, tp graph.ActionTp, sql string) int {
+	log := SQLLog{
 		startTime: time.Now(),
 		endTime:   EMPTY_TIME,
 		tp:        tp,
 		sql:       sql,
 		status:    false,
 		err:       nil,
-	})
+		xID:       xID,
+		aID:       -1,
+		kID:       -1,
+		vID:       -1,
+		affected:  -1,
+	}
+	if action != nil {
+		log.aID, log.kID, log.vID = action.ID(), action.KID(), action.VID()
+	}
+	e.logs[tID] = append(e.logs[tID], log)
 	return len(e.logs[tID]) - 1
 }
 
@@ -116,6 +198,23 @@ func (e *ExecutionLog) LogSuccess(tID, aID int) {
 	e.logs[tID][aID].status = true
 }
 
+// LogResult records the rows returned by a read or the affected rows of a write
+func (e *ExecutionLog) LogResult(tID, aID int, rows [][]*kv.QueryItem, res *sql.Result) {
+	log := &e.logs[tID][aID]
+	if log.tp.IsRead() {
+		if rows == nil {
+			// distinguish reading nothing from not recorded
+			rows = [][]*kv.QueryItem{}
+		}
+		log.rows = rows
+	}
+	if res != nil {
+		if affected, err := (*res).RowsAffected(); err == nil {
+			log.affected = affected
+		}
+	}
+}
+
 func (e *ExecutionLog) LogFail(tID, aID int, err error) {
 	e.logs[tID][aID].endTime = time.Now()
 	e.logs[tID][aID].status = false
@@ -123,101 +222,153 @@ func (e *ExecutionLog) LogFail(tID, aID int, err error) {
 		panic("err should not be nil when log a failed stmt")
 	}
 	e.logs[tID][aID].err = err
+	e.logs[tID][aID].code = db.ErrorCode(err)
 }
 
-func (e *ExecutionLog) LogN(n int) string {
-	var (
-		b    strings.Builder
-		logs = e.logs[n]
-	)
-	for _, log := range logs {
-		if log.status {
-			fmt.Fprintf(&b, "[SUCCESS]")
-		} else {
+// Count returns the number of finished statements and the failed ones in them
+func (e *ExecutionLog) Count() (int, int) {
+	finished, failed := 0, 0
+	for _, logs := range e.logs {
+		for _, log := range logs {
 			if log.err != nil {
-				fmt.Fprintf(&b, "[FAILED %s]", log.err.Error())
-			} else {
-				fmt.Fprintf(&b, "[UNFINISHED]")
+				failed++
+			} else if !log.status {
+				continue
 			}
+			finished++
 		}
-		fmt.Fprintf(&b, " [%s]", log.tp)
-		fmt.Fprintf(&b, " [%s-%s] ", log.startTime.Format(LOGTIME_FORMAT), log.endTime.Format(LOGTIME_FORMAT))
-		b.WriteString(log.sql)
+	}
+	return finished, failed
+}
+
+func (e *ExecutionLog) LogN(n int) string {
+	var b strings.Builder
+	for _, log := range e.logs[n] {
+		b.WriteString(log.String())
 		b.WriteString("\n")
 	}
 	return b.String()
 }
 
-func ParseLog(logPath string) error {
-	var files []string
-	if err := filepath.Walk(logPath, func(path string, info os.FileInfo, err error) error {
-		name := info.Name()
-		if threadPattern.MatchString(name) {
-			files = append(files, name)
-		}
-		return nil
-	}); err != nil {
-		return err
+// LogJSONL formats the logs of thread n in JSONL format
+func (e *ExecutionLog) LogJSONL(n int) string {
+	var b strings.Builder
+	for _, log := range e.logs[n] {
+		data, err := json.Marshal(log.toJSON(n))
+		util.AssertNil(err)
+		b.Write(data)
+		b.WriteString("\n")
 	}
+	return b.String()
+}
 
-	logs := make(map[int][]string)
-	for _, file := range files {
-		matches := threadPattern.FindStringSubmatch(file)
-		thread, err := strconv.Atoi(matches[1])
-		util.AssertNil(err)
-		bs, err := ioutil.ReadFile(path.Join(logPath, file))
-		if err != nil {
-			return err
+// String formats the log in text format, the result is appended to the SQL as a comment
+func (log *SQLLog) String() string {
+	var b strings.Builder
+	if log.status {
+		fmt.Fprintf(&b, "[SUCCESS]")
+	} else {
+		if log.err != nil {
+			fmt.Fprintf(&b, "[FAILED %s]", log.err.Error())
+		} else {
+			fmt.Fprintf(&b, "[UNFINISHED]")
 		}
-		content := string(bs)
-		logs[thread] = strings.Split(content, "\n")
 	}
+	fmt.Fprintf(&b, " [%s]", log.tp)
+	fmt.Fprintf(&b, " [%s-%s] ", log.startTime.Format(LOGTIME_FORMAT), log.endTime.Format(LOGTIME_FORMAT))
+	b.WriteString(log.sql)
+	if log.rows != nil {
+		data, err := json.Marshal(encodeRows(log.rows))
+		util.AssertNil(err)
+		fmt.Fprintf(&b, " -- rows: %s", data)
+	} else if log.affected >= 0 {
+		fmt.Fprintf(&b, " -- affected: %d", log.affected)
+	}
+	return b.String()
+}
 
-	combineFile, err := os.Create(path.Join(logPath, "combine.log"))
-	if err != nil {
-		return err
+func (log *SQLLog) toJSON(thread int) jsonLog {
+	j := jsonLog{
+		Version:  JSONL_VERSION,
+		Thread:   thread,
+		Txn:      log.xID,
+		Action:   log.aID,
+		KID:      log.kID,
+		VID:      log.vID,
+		Tp:       log.tp,
+		SQL:      log.sql,
+		Start:    log.startTime.UnixNano(),
+		Status:   STATUS_SUCCESS,
+		Code:     log.code,
+		Affected: log.affected,
+	}
+	if !log.endTime.Equal(EMPTY_TIME) {
+		j.End = log.endTime.UnixNano()
 	}
-	defer func() {
-		if err := combineFile.Close(); err != nil {
-			fmt.Println(err)
+	if !log.status {
+		j.Status = STATUS_UNFINISHED
+		if log.err != nil {
+			j.Status, j.Error = STATUS_FAILED, log.err.Error()
 		}
-	}()
-	combineWriter := bufio.NewWriter(combineFile)
-
-	// TODO: use min-heap
-	for {
-		least, leastThread := TIME_MAX, -1
-		for i, log := range logs {
-			startMatch := startPattern.FindStringSubmatch(log[0])
-			if len(startMatch) == 0 {
-				logs[i] = log[1:]
-				if len(logs[i]) == 0 {
-					delete(logs, i)
-				}
-				continue
-			}
-			startTime, err := time.Parse(LOGTIME_FORMAT, startMatch[1])
-			util.AssertNil(err)
-			if startTime.Before(least) {
-				least = startTime
-				leastThread = i
+	}
+	if log.rows != nil {
+		j.Rows = encodeRows(log.rows)
+	}
+	return j
+}
+
+func (j *jsonLog) toSQLLog() SQLLog {
+	log := SQLLog{
+		startTime: time.Unix(0, j.Start),
+		endTime:   EMPTY_TIME,
+		tp:        j.Tp,
+		sql:       j.SQL,
+		status:    j.Status == STATUS_SUCCESS,
+		code:      j.Code,
+		xID:       j.Txn,
+		aID:       j.Action,
+		kID:       j.KID,
+		vID:       j.VID,
+		affected:  j.Affected,
+	}
+	if j.End != 0 {
+		log.endTime = time.Unix(0, j.End)
+	}
+	if j.Status == STATUS_FAILED {
+		log.err = errors.New(j.Error)
+	}
+	if j.Rows != nil {
+		log.rows = decodeRows(j.Rows)
+	}
+	return log
+}
+
+// encodeRows converts the rows into strings, NULL is nil
+func encodeRows(rows [][]*kv.QueryItem) [][]*string {
+	values := make([][]*string, len(rows))
+	for i, row := range rows {
+		values[i] = make([]*string, len(row))
+		for j, item := range row {
+			if !item.Null {
+				val := item.ValString
+				values[i][j] = &val
 			}
 		}
-		if !least.Equal(TIME_MAX) {
-			if _, err := combineWriter.WriteString(fmt.Sprintf("[THREAD %d] ", leastThread)); err != nil {
-				return err
-			}
-			if _, err := combineWriter.WriteString(logs[leastThread][0] + "\n"); err != nil {
-				return err
-			}
-			logs[leastThread] = logs[leastThread][1:]
-			if len(logs[leastThread]) == 0 {
-				delete(logs, leastThread)
+	}
+	return values
+}
+
+func decodeRows(values [][]*string) [][]*kv.QueryItem {
+	rows := make([][]*kv.QueryItem, len(values))
+	for i, row := range values {
+		rows[i] = make([]*kv.QueryItem, len(row))
+		for j, val := range row {
+			if val == nil {
+				rows[i][j] = &kv.QueryItem{Null: true}
+			} else {
+				rows[i][j] = &kv.QueryItem{ValString: *val}
 			}
-		} else {
-			break
 		}
 	}
-
-	return combineWriter.Flush()
+	return rows
 }
@@ -17,10 +17,13 @@ import (
 )
 
 type Manager struct {
-	opt      Option
-	cfg      *config.Config
-	graphMgr graph.Generator
-	db       db.DB
+	opt       Option
+	cfg       *config.Config
+	kvManager *kv.Manager
+	graphMgr  graph.Generator
+	db        db.DB
+	// candidates is the number of graphs executed by Minimize, used in the database names
+	candidates int
 }
 
 type Option struct {
@@ -31,166 +34,275 @@ type Option struct {
 func NewManager(opt Option) *Manager {
 	kvManager := kv.NewManager(&opt.Cfg.Global)
 	m := Manager{
-		opt:      opt,
-		cfg:      opt.Cfg,
-		graphMgr: graph.NewGenerator(&kvManager, opt.Cfg),
+		opt:       opt,
+		cfg:       opt.Cfg,
+		kvManager: &kvManager,
+		graphMgr:  graph.NewGenerator(&kvManager, opt.Cfg),
 	}
 	return &m
 }
 
-func (m *Manager) Run(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if err := m.Once(ctx); err != nil {
-				fmt.Println("mikadzuki failed", err)
-				return
-			}
-		}
-	}
+// Seed returns the seed of the next graph
+func (m *Manager) Seed() int64 {
+	return m.graphMgr.Seed()
+}
+
+// NewGraph generates a graph by the config without executing it
+func (m *Manager) NewGraph() (*graph.Graph, error) {
+	g, err := m.graphMgr.NewGraph(m.cfg.Global.Thread, m.cfg.Global.Action)
+	return g, errors.Trace(err)
 }
 
 func (m *Manager) Once(ctx context.Context) error {
-	startTime := util.NowStr()
+	_, err := m.round(ctx, util.NowStr())
+	return err
+}
+
+// round generates and executes a graph, the logs are written into logDir under log path,
+// it returns the execution logs, which are nil in dry run mode
+func (m *Manager) round(ctx context.Context, logDir string) (*ExecutionLog, error) {
+	g, err := m.NewGraph()
+	if err != nil {
+		return nil, err
+	}
 	if !m.opt.Dryrun {
 		if err := m.initDB(); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	g := m.graphMgr.NewGraph(m.cfg.Global.Thread, m.cfg.Global.Action)
 	if m.cfg.Global.LogPath != "" {
-		m.DumpGraph(g, startTime)
+		m.DumpGraph(g, logDir)
 	}
 
 	if m.opt.Dryrun {
 		for _, stmt := range g.GetSchemas() {
 			fmt.Println(stmt)
 		}
-		return nil
+		return nil, nil
 	}
 
+	return m.execGraph(ctx, g, logDir, nil)
+}
+
+// execGraph creates the schema and executes the graph, it returns the execution logs,
+// the statements start in the order of interleaving if it's not nil
+func (m *Manager) execGraph(ctx context.Context, g *graph.Graph, startTime string, interleaving *Interleaving) (*ExecutionLog, error) {
 	for _, stmt := range g.GetSchemas() {
 		fmt.Println(stmt)
 		if _, err := m.db.Exec(stmt); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	logs := NewExecutionLog(m.cfg.Global.Thread, g.MaxAction())
-	txns := make([]db.Txn, m.cfg.Global.Thread)
-	doneCh := make(chan struct{}, 1)
-	errCh := make(chan error, 1)
-
-	go func() {
-		if err := g.IterateGraph(func(tID int, tp graph.ActionTp, sqlStmt string) (*sql.Rows, *sql.Result, error) {
-			var (
-				rows *sql.Rows
-				res  *sql.Result
-				err  error
-				aID  int
-			)
-			if tID >= 0 {
-				aID = logs.LogStart(tID, tp, sqlStmt)
+	conn := m.db
+	logs := NewExecutionLog(g.TimelineNum(), g.MaxAction())
+	txns := make([]db.Txn, g.TimelineNum())
+
+	progress, err := g.IterateGraph(ctx, func(tID, xID, aID int, tp graph.ActionTp, sqlStmt string) ([][]*kv.QueryItem, *sql.Result, error) {
+		var (
+			rows   *sql.Rows
+			result [][]*kv.QueryItem
+			res    *sql.Result
+			err    error
+			id     int
+		)
+		stmtCtx, cancel := m.stmtContext(ctx)
+		defer cancel()
+		if tID >= 0 {
+			if interleaving != nil {
+				interleaving.Wait(tID, tp)
 			}
-			switch tp {
-			case graph.Begin:
-				txns[tID], err = m.db.Begin()
-			case graph.Commit:
-				if txns[tID] == nil {
-					fmt.Printf("nil txn (%d, %d)\n", tID, aID)
-				}
-				err = txns[tID].Commit()
-				txns[tID] = nil
-			case graph.Rollback:
-				if txns[tID] == nil {
-					fmt.Printf("nil txn (%d, %d)\n", tID, aID)
-				}
-				err = txns[tID].Rollback()
-				txns[tID] = nil
-			case graph.Select, graph.SelectForUpdate:
-				// -1 tID is for tracing bug
-				if tID == -1 {
-					rows, err = m.db.Query(sqlStmt)
-					return rows, res, err
-				} else {
-					txn := txns[tID]
-					util.AssertNotNil(txn)
-					rows, err = txns[tID].Query(sqlStmt)
-				}
-			default:
+			var action *graph.Action
+			if aID >= 0 {
+				action = g.GetAction(tID, xID, aID)
+			}
+			id = logs.LogStart(tID, xID, action, tp, sqlStmt)
+		}
+		switch tp {
+		case graph.Begin:
+			txns[tID], err = conn.Begin(g.GetTimeline(tID).TxOptions())
+		case graph.Commit:
+			if txns[tID] == nil {
+				fmt.Printf("nil txn (%d, %d)\n", tID, xID)
+			}
+			err = m.endTxn(txns[tID].Commit)
+			txns[tID] = nil
+		case graph.Rollback:
+			if txns[tID] == nil {
+				fmt.Printf("nil txn (%d, %d)\n", tID, xID)
+			}
+			err = m.endTxn(txns[tID].Rollback)
+			txns[tID] = nil
+		case graph.Select, graph.SelectForUpdate:
+			// -1 tID is for tracing bug
+			if tID == -1 {
+				rows, err = conn.Query(sqlStmt)
+			} else {
 				txn := txns[tID]
 				util.AssertNotNil(txn)
-				res, err = txn.Exec(sqlStmt)
+				rows, err = txns[tID].QueryContext(stmtCtx, sqlStmt)
 			}
-			if tID >= 0 {
-				if err != nil {
-					logs.LogFail(tID, aID, err)
-				} else {
-					logs.LogSuccess(tID, aID)
-				}
+			if err == nil {
+				result, err = kv.ParseFromSQLResult(rows)
+				_ = rows.Close()
 			}
-			return rows, res, err
-		}); err != nil {
-			if m.cfg.Global.LogPath != "" {
-				m.DumpResult(logs, startTime)
+			if tID == -1 {
+				return result, res, err
+			}
+		default:
+			txn := txns[tID]
+			util.AssertNotNil(txn)
+			res, err = txn.ExecContext(stmtCtx, sqlStmt)
+		}
+		if tID >= 0 {
+			if err != nil {
+				logs.LogFail(tID, id, err)
+			} else {
+				logs.LogResult(tID, id, result, res)
+				logs.LogSuccess(tID, id)
 			}
-			_ = m.closeDB()
-			errCh <- err
-		} else {
-			if m.cfg.Global.LogPath != "" {
-				m.DumpResult(logs, startTime)
+		}
+		// the statements are stopped by stmtCtx, and the txn ends are given up by endTxn
+		if err != nil && ctx.Err() == nil && (stmtCtx.Err() == context.DeadlineExceeded || errors.Cause(err) == context.DeadlineExceeded) {
+			err = m.hangError(conn, g, tID, sqlStmt)
+		}
+		return result, res, err
+	})
+	// the workers roll back their own unfinished txns, the ones begun for other threads are left
+	for tID, txn := range txns {
+		if txn != nil {
+			if err := txn.Rollback(); err != nil {
+				fmt.Printf("rollback txn of thread %d failed %v\n", tID, err)
 			}
-			_ = m.closeDB()
-			doneCh <- struct{}{}
 		}
-	}()
-	select {
-	case <-ctx.Done():
+	}
+	if m.cfg.Global.LogPath != "" {
 		m.DumpResult(logs, startTime)
-		return nil
-	case err := <-errCh:
-		return err
-	case <-doneCh:
-		return nil
 	}
+	_ = m.closeDB()
+	if err != nil || !progress.Finished() {
+		fmt.Println("progress:", progress)
+	}
+	// the cancellation is not a failure of the graph
+	if ctx.Err() != nil && errors.Cause(err) == ctx.Err() {
+		return logs, nil
+	}
+	return logs, err
 }
 
+// initDB creates the database, the connections of the previous round are closed
 func (m *Manager) initDB() error {
+	if err := m.closeDB(); err != nil {
+		return errors.Trace(err)
+	}
 	var err error
-	m.db, err = m.connectDB(m.cfg.Global.Target, m.cfg.Global.DSN)
+	target := m.cfg.Global.Target
+	m.db, err = m.connectDB(target, m.cfg.Global.DSN)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	dbname := m.cfg.Global.Database
-	_, err = m.db.Exec(`SET @@GLOBAL.SQL_MODE="NO_ENGINE_SUBSTITUTION"`)
+	switch target {
+	case kv.TARGET_SQLITE:
+		// the database file is the database, clean up the tables in it
+		return errors.Trace(m.dropTables())
+	case kv.TARGET_POSTGRES:
+		_, err = m.db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", dbname))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	default:
+		_, err = m.db.Exec(`SET @@GLOBAL.SQL_MODE="NO_ENGINE_SUBSTITUTION"`)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, err = m.db.Exec(fmt.Sprintf("DROP DATABASE %s", dbname))
+		if err != nil && !strings.Contains(err.Error(), "database doesn't exist") {
+			return errors.Trace(err)
+		}
+	}
+	_, err = m.db.Exec(fmt.Sprintf("CREATE DATABASE %s", dbname))
 	if err != nil {
 		return errors.Trace(err)
 	}
-	_, err = m.db.Exec(fmt.Sprintf("DROP DATABASE %s", dbname))
-	if err != nil && !strings.Contains(err.Error(), "database doesn't exist") {
+	if err := m.closeDB(); err != nil {
 		return errors.Trace(err)
 	}
-	_, err = m.db.Exec(fmt.Sprintf("CREATE DATABASE %s", dbname))
+	m.db, err = m.connectDB(target, m.databaseDSN(target, dbname))
+	return errors.Trace(err)
+}
+
+// dropTables drops all the tables of a SQLite database
+func (m *Manager) dropTables() error {
+	rows, err := m.db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
 	if err != nil {
 		return errors.Trace(err)
 	}
-	m.db, err = m.connectDB(m.cfg.Global.Target, m.cfg.Global.DSN+dbname)
-	return errors.Trace(err)
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return errors.Trace(err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	for _, table := range tables {
+		if _, err := m.db.Exec(fmt.Sprintf("DROP TABLE %s", table)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// databaseDSN returns the dsn connects to the created database
+func (m *Manager) databaseDSN(target, dbname string) string {
+	switch target {
+	case kv.TARGET_POSTGRES:
+		return db.PostgresDSN(m.cfg.Global.DSN, dbname)
+	default:
+		return m.cfg.Global.DSN + dbname
+	}
 }
 
 func (m *Manager) closeDB() error {
 	if m.db == nil {
 		return nil
 	}
-	return errors.Trace(m.db.Close())
+	err := m.db.Close()
+	m.db = nil
+	return errors.Trace(err)
+}
+
+// dropDatabase drops the database created by initDB, the connections to it should be closed before.
+// The SQLite database file is not removed, its tables are dropped by the next initDB.
+func (m *Manager) dropDatabase() error {
+	target := m.cfg.Global.Target
+	if target == kv.TARGET_SQLITE {
+		return nil
+	}
+	conn, err := m.connectDB(target, m.cfg.Global.DSN)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = conn.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", m.cfg.Global.Database))
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Trace(err)
 }
 
 func (m *Manager) connectDB(target, dsn string) (db.DB, error) {
 	switch target {
-	case "mysql":
+	case kv.TARGET_MYSQL, kv.TARGET_TIDB:
 		return db.NewMySQL(dsn)
+	case kv.TARGET_POSTGRES:
+		return db.NewPostgres(dsn)
+	case kv.TARGET_MEMORY:
+		return db.NewMemory(dsn)
+	case kv.TARGET_SQLITE:
+		return db.NewSQLite(dsn)
 	default:
 		panic(fmt.Sprintf("Unsupported target %s", target))
 	}
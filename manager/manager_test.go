@@ -0,0 +1,454 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/you06/go-mikadzuki/check"
+	"github.com/you06/go-mikadzuki/config"
+	"github.com/you06/go-mikadzuki/db"
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+func newMemoryConfig(name string) *config.Config {
+	cfg := config.NewConfig()
+	cfg.Global.Target = "memory"
+	cfg.Global.DSN = name + "/"
+	cfg.Global.Database = "test"
+	cfg.Global.Thread = 4
+	cfg.Global.Action = 5
+	return &cfg
+}
+
+func TestOnceWithMemory(t *testing.T) {
+	for _, isolation := range []string{config.REPEATABLE_READ, config.READ_COMMITTED, config.SERIALIZABLE} {
+		cfg := newMemoryConfig("once-" + isolation)
+		cfg.Global.Isolation = isolation
+		m := NewManager(Option{Cfg: cfg})
+		for i := 0; i < 3; i++ {
+			require.Nil(t, m.Once(context.Background()), isolation)
+		}
+	}
+}
+
+func TestOnceWithMemoryIsolationMix(t *testing.T) {
+	cfg := newMemoryConfig("once-mix")
+	cfg.Global.IsolationMix = []string{config.REPEATABLE_READ, config.READ_COMMITTED, config.SERIALIZABLE}
+	m := NewManager(Option{Cfg: cfg})
+	for i := 0; i < 3; i++ {
+		require.Nil(t, m.Once(context.Background()))
+	}
+}
+
+func TestOnceWithSQLite(t *testing.T) {
+	cfg := newMemoryConfig("once-sqlite")
+	cfg.Global.Target = "sqlite"
+	cfg.Global.DSN = db.SQLITE_MEMORY
+	m := NewManager(Option{Cfg: cfg})
+	for i := 0; i < 3; i++ {
+		require.Nil(t, m.Once(context.Background()))
+	}
+}
+
+func TestStmtTimeout(t *testing.T) {
+	cfg := newMemoryConfig("timeout")
+	// all the statements run over the timeout
+	cfg.Global.StmtTimeout.Duration = time.Nanosecond
+	cfg.Global.LockViews = true
+	m := NewManager(Option{Cfg: cfg})
+	err := m.Once(context.Background())
+	require.Equal(t, errors.Cause(err), context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "hangs over 1ns in ")
+	require.Contains(t, err.Error(), "timeline 0: ")
+	require.Contains(t, err.Error(), ": exec (")
+	require.Contains(t, err.Error(), "lock views are not supported by memory")
+}
+
+func TestEndTxnTimeout(t *testing.T) {
+	cfg := newMemoryConfig("end-timeout")
+	m := NewManager(Option{Cfg: cfg})
+	release := make(chan struct{})
+	defer close(release)
+	hang := func() error {
+		<-release
+		return nil
+	}
+	// no timeout, the commit errors are returned as is
+	require.Nil(t, m.endTxn(func() error { return nil }))
+	cfg.Global.StmtTimeout.Duration = 10 * time.Millisecond
+	require.Equal(t, errors.Cause(m.endTxn(hang)), context.DeadlineExceeded)
+}
+
+func TestReplayWithMemory(t *testing.T) {
+	cfg := newMemoryConfig("replay")
+	cfg.Global.LogPath = t.TempDir()
+	m := NewManager(Option{Cfg: cfg})
+	require.Nil(t, m.Once(context.Background()))
+	dirs, err := ioutil.ReadDir(cfg.Global.LogPath)
+	require.Nil(t, err)
+	require.Len(t, dirs, 1)
+	logPath := path.Join(cfg.Global.LogPath, dirs[0].Name())
+
+	g, err := m.LoadGraph(path.Join(logPath, "graph.json"))
+	require.Nil(t, err)
+	require.Equal(t, g.TimelineNum(), cfg.Global.Thread)
+	interleaving, err := LoadInterleaving(logPath)
+	require.Nil(t, err)
+	cfg.Global.LogPath = ""
+	require.Nil(t, m.Replay(context.Background(), g, interleaving))
+}
+
+func TestRunWithMemory(t *testing.T) {
+	cfg := newMemoryConfig("run")
+	cfg.Global.LogPath = t.TempDir()
+	m := NewManager(Option{Cfg: cfg})
+	summary := m.Run(context.Background(), RunOption{Rounds: 3})
+	require.Equal(t, summary.Rounds, 3)
+	require.Empty(t, summary.Failures)
+	require.NotZero(t, summary.Statements)
+	dirs, err := ioutil.ReadDir(cfg.Global.LogPath)
+	require.Nil(t, err)
+	require.Len(t, dirs, 1)
+	for i := 0; i < 3; i++ {
+		_, err := ioutil.ReadFile(path.Join(cfg.Global.LogPath, dirs[0].Name(), fmt.Sprintf("round-%d", i), "graph.json"))
+		require.Nil(t, err)
+	}
+	// the connections left by the previous round are closed
+	previous, err := m.connectDB(cfg.Global.Target, cfg.Global.DSN)
+	require.Nil(t, err)
+	m.db = previous
+	require.Nil(t, m.initDB())
+	_, err = previous.Exec("SELECT 1")
+	require.Contains(t, err.Error(), "database is closed")
+
+	// the rounds go on after failures
+	cfg = newMemoryConfig("run-failed")
+	cfg.Global.Target = "sqlite"
+	cfg.Global.DSN = path.Join(t.TempDir(), "not-exist", "test.db")
+	m = NewManager(Option{Cfg: cfg})
+	summary = m.Run(context.Background(), RunOption{Rounds: 2})
+	require.Equal(t, summary.Rounds, 2)
+	require.Len(t, summary.Failures, 2)
+	require.Equal(t, summary.Failures[1].Round, 1)
+	require.Contains(t, summary.String(), "failures: 2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Zero(t, m.Run(ctx, RunOption{}).Rounds)
+}
+
+func TestLoadHistory(t *testing.T) {
+	cfg := newMemoryConfig("history")
+	cfg.Global.LogPath = t.TempDir()
+	cfg.Global.LogFormat = config.LOG_FORMAT_JSONL
+	m := NewManager(Option{Cfg: cfg})
+	require.Nil(t, m.Once(context.Background()))
+	dirs, err := ioutil.ReadDir(cfg.Global.LogPath)
+	require.Nil(t, err)
+	require.Len(t, dirs, 1)
+
+	h, err := m.LoadHistory(path.Join(cfg.Global.LogPath, dirs[0].Name()))
+	require.Nil(t, err)
+	require.NotEmpty(t, h.Txns)
+	writes, reads := 0, 0
+	for _, txn := range h.Txns {
+		require.True(t, txn.ID >= 0)
+		require.False(t, txn.End.Before(txn.Start))
+		for _, op := range txn.Ops {
+			if op.Tp == check.Write {
+				writes++
+			} else if op.Value != check.UNKNOWN_VALUE {
+				reads++
+			}
+		}
+	}
+	require.NotZero(t, writes)
+	require.NotZero(t, reads)
+	for _, anomaly := range check.Check(h) {
+		require.False(t, anomaly.Prohibited(), anomaly.String())
+	}
+}
+
+func TestLogResult(t *testing.T) {
+	logs := NewExecutionLog(1, 5)
+	row := []*kv.QueryItem{{ValString: "1"}, {Null: true}, {ValString: "a -- b"}}
+	id := logs.LogStart(0, 0, nil, graph.Select, "SELECT * FROM t WHERE id = 1")
+	logs.LogResult(0, id, [][]*kv.QueryItem{row}, nil)
+	logs.LogSuccess(0, id)
+	id = logs.LogStart(0, 0, nil, graph.Select, "SELECT * FROM t WHERE id = 2")
+	logs.LogResult(0, id, nil, nil)
+	logs.LogSuccess(0, id)
+	var res sql.Result = driver.RowsAffected(1)
+	id = logs.LogStart(0, 0, nil, graph.Update, "UPDATE t SET v = 1 WHERE id = 1")
+	logs.LogResult(0, id, nil, &res)
+	logs.LogSuccess(0, id)
+	id = logs.LogStart(0, 0, nil, graph.Delete, "DELETE FROM t WHERE id = 1")
+	logs.LogFail(0, id, errors.New("Deadlock found\ntry restarting transaction"))
+	logs.LogStart(0, 0, nil, graph.Commit, "COMMIT")
+
+	for _, file := range []string{"thread-0.log", "thread-0.jsonl"} {
+		content := logs.LogN(0)
+		if strings.HasSuffix(file, ".jsonl") {
+			content = logs.LogJSONL(0)
+		}
+		logPath := t.TempDir()
+		require.Nil(t, ioutil.WriteFile(path.Join(logPath, file), []byte(content), 0644))
+		records, err := readThreadRecords(logPath)
+		require.Nil(t, err)
+		require.Len(t, records[0], 5)
+		require.Equal(t, records[0][0].sql, "SELECT * FROM t WHERE id = 1")
+		require.Equal(t, records[0][0].rows, [][]*kv.QueryItem{row})
+		require.Equal(t, records[0][1].sql, "SELECT * FROM t WHERE id = 2")
+		require.Equal(t, records[0][1].rows, [][]*kv.QueryItem{})
+		require.Equal(t, records[0][2].sql, "UPDATE t SET v = 1 WHERE id = 1")
+		require.Nil(t, records[0][2].rows)
+		require.Equal(t, records[0][2].affected, int64(1))
+		require.False(t, records[0][3].status)
+		require.Equal(t, records[0][3].err.Error(), "Deadlock found\ntry restarting transaction")
+		require.False(t, records[0][4].status)
+		require.Nil(t, records[0][4].err)
+		require.Equal(t, records[0][4].affected, int64(-1))
+
+		var combined bytes.Buffer
+		require.Nil(t, ParseLog(logPath, &LogFilter{}, &combined))
+		require.Equal(t, strings.Count(combined.String(), "[THREAD 0] "), 5)
+	}
+	require.True(t, strings.HasPrefix(logs.logs[0][3].String(), "[FAILED Deadlock found\n"))
+}
+
+func TestParseLogFilter(t *testing.T) {
+	logs := NewExecutionLog(2, 5)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local)
+	log := func(tID, xID, kID int, tp graph.ActionTp, sql string, second int, err error) {
+		id := logs.LogStart(tID, xID, nil, tp, sql)
+		if err != nil {
+			logs.LogFail(tID, id, err)
+		} else {
+			logs.LogSuccess(tID, id)
+		}
+		logs.logs[tID][id].kID = kID
+		logs.logs[tID][id].startTime = base.Add(time.Duration(second) * time.Second)
+		logs.logs[tID][id].endTime = logs.logs[tID][id].startTime
+	}
+	log(0, 0, -1, graph.Begin, "BEGIN", 0, nil)
+	log(1, 0, -1, graph.Begin, "BEGIN", 1, nil)
+	log(0, 0, 1, graph.Update, "UPDATE 1", 2, nil)
+	log(1, 0, 1, graph.Update, "UPDATE 2", 3, errors.New("Deadlock found"))
+	log(0, 0, -1, graph.Commit, "COMMIT", 4, nil)
+	log(1, 1, -1, graph.Begin, "BEGIN", 5, nil)
+	log(1, 1, 2, graph.Select, "SELECT 3", 6, nil)
+
+	logPath := t.TempDir()
+	for i := 0; i < 2; i++ {
+		require.Nil(t, ioutil.WriteFile(path.Join(logPath, fmt.Sprintf("thread-%d.jsonl", i)), []byte(logs.LogJSONL(i)), 0644))
+	}
+	parse := func(filter LogFilter) []string {
+		var b bytes.Buffer
+		require.Nil(t, ParseLog(logPath, &filter, &b))
+		var sqls []string
+		for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+			if line != "" {
+				sqls = append(sqls, line[:len("[THREAD 0]")]+" "+line[strings.LastIndex(line, "] ")+2:])
+			}
+		}
+		return sqls
+	}
+	require.Equal(t, parse(LogFilter{}), []string{
+		"[THREAD 0] BEGIN",
+		"[THREAD 1] BEGIN",
+		"[THREAD 0] UPDATE 1",
+		"[THREAD 1] UPDATE 2",
+		"[THREAD 0] COMMIT",
+		"[THREAD 1] BEGIN",
+		"[THREAD 1] SELECT 3",
+	})
+	require.Equal(t, parse(LogFilter{Threads: []int{1}, Txns: []int{1}}), []string{
+		"[THREAD 1] BEGIN",
+		"[THREAD 1] SELECT 3",
+	})
+	require.Equal(t, parse(LogFilter{Keys: []int{1}}), []string{
+		"[THREAD 0] UPDATE 1",
+		"[THREAD 1] UPDATE 2",
+	})
+	require.Equal(t, parse(LogFilter{Since: base.Add(2 * time.Second), Until: base.Add(5 * time.Second)}), []string{
+		"[THREAD 0] UPDATE 1",
+		"[THREAD 1] UPDATE 2",
+		"[THREAD 0] COMMIT",
+	})
+	require.Equal(t, parse(LogFilter{FailedOnly: true}), []string{
+		"[THREAD 1] UPDATE 2",
+	})
+	require.Error(t, ParseLog(t.TempDir(), &LogFilter{}, &bytes.Buffer{}))
+}
+
+func TestExport(t *testing.T) {
+	logPath := t.TempDir()
+	thread0 := `[SUCCESS] [Begin] [2021-01-01 00:00:00.00001-2021-01-01 00:00:00.00002] BEGIN
+[SUCCESS] [Update] [2021-01-01 00:00:00.00003-2021-01-01 00:00:00.00006] UPDATE t SET v = 1 WHERE id = 1 -- affected: 1
+[SUCCESS] [Commit] [2021-01-01 00:00:00.00007-2021-01-01 00:00:00.00008] COMMIT
+`
+	thread1 := `[SUCCESS] [Begin] [2021-01-01 00:00:00.00002-2021-01-01 00:00:00.00003] BEGIN
+[FAILED Deadlock found
+try restarting transaction] [Update] [2021-01-01 00:00:00.00004-2021-01-01 00:00:00.00005] UPDATE t SET v = 2 WHERE id = 1
+[SUCCESS] [Rollback] [2021-01-01 00:00:00.00009-2021-01-01 00:00:00.00010] ROLLBACK
+`
+	require.Nil(t, ioutil.WriteFile(path.Join(logPath, "thread-0.log"), []byte(thread0), 0644))
+	require.Nil(t, ioutil.WriteFile(path.Join(logPath, "thread-1.log"), []byte(thread1), 0644))
+
+	var out bytes.Buffer
+	require.Nil(t, Export(logPath, EXPORT_SQLZ, &out))
+	require.Equal(t, out.String(), `/* thread_0 */ BEGIN;
+/* thread_1 */ BEGIN;
+/* thread_0 */ UPDATE t SET v = 1 WHERE id = 1;
+/* thread_0 */ COMMIT;
+/* thread_1 */ ROLLBACK;
+`)
+	out.Reset()
+	require.Nil(t, Export(logPath, EXPORT_SQL, &out))
+	require.Equal(t, out.String(), `BEGIN;
+BEGIN;
+UPDATE t SET v = 1 WHERE id = 1;
+COMMIT;
+ROLLBACK;
+`)
+	require.Error(t, Export(logPath, "csv", &out))
+	require.Error(t, Export(t.TempDir(), EXPORT_SQL, &out))
+}
+
+func TestExportMySQLTest(t *testing.T) {
+	cfg := newMemoryConfig("mysqltest")
+	cfg.Global.LogPath = t.TempDir()
+	m := NewManager(Option{Cfg: cfg})
+	require.Nil(t, m.Once(context.Background()))
+	dirs, err := ioutil.ReadDir(cfg.Global.LogPath)
+	require.Nil(t, err)
+	require.Len(t, dirs, 1)
+
+	mysqlTest, err := m.ExportMySQLTest(path.Join(cfg.Global.LogPath, dirs[0].Name()))
+	require.Nil(t, err)
+	for i := 0; i < cfg.Global.Thread; i++ {
+		require.Contains(t, mysqlTest.Test, fmt.Sprintf("connect (thread_%d,localhost,root,,);\n", i))
+		require.Contains(t, mysqlTest.Result, fmt.Sprintf("connect  thread_%d,localhost,root,,;\n", i))
+	}
+	require.Contains(t, mysqlTest.Result, "CREATE TABLE t0(")
+	require.Equal(t, strings.Count(mysqlTest.Test, "\nsend "), strings.Count(mysqlTest.Test, "\nreap;\n"))
+	require.Equal(t, strings.Count(mysqlTest.Test, "\nconnection "), strings.Count(mysqlTest.Result, "\nconnection "))
+	require.True(t, strings.HasSuffix(mysqlTest.Result, "connection default;\nDROP TABLE t0;\n"))
+
+	for i := 0; i < cfg.Global.Thread; i++ {
+		require.Contains(t, mysqlTest.Test, fmt.Sprintf("connect (thread_%d,localhost,root,,);\nSET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ;\n", i))
+	}
+
+	record := func(err error, code string) *threadRecord {
+		return &threadRecord{SQLLog: SQLLog{err: err, code: code}}
+	}
+	code, message := mysqlError(record(errors.New("Error 1213: Deadlock found when trying to get lock; try restarting transaction"), ""))
+	require.Equal(t, code, "1213")
+	require.Equal(t, message, "Deadlock found when trying to get lock; try restarting transaction")
+	code, message = mysqlError(record(errors.New("Error 1213: Deadlock found when trying to get lock; try restarting transaction"), "1205"))
+	require.Equal(t, code, "1205")
+	require.Equal(t, message, "Deadlock found when trying to get lock; try restarting transaction")
+	code, message = mysqlError(record(errors.New("Lock wait timeout exceeded; try restarting transaction"), "1205"))
+	require.Equal(t, code, "1205")
+	require.Equal(t, message, "Lock wait timeout exceeded; try restarting transaction")
+	code, message = mysqlError(record(errors.New("database is locked\nmore"), ""))
+	require.Equal(t, code, "")
+	require.Equal(t, message, "database is locked")
+}
+
+// corruptGraph changes the expected values of the first non-primary column, so the reads of them fail
+func corruptGraph(t *testing.T, m *Manager, g *graph.Graph) *graph.Graph {
+	b, err := json.Marshal(g)
+	require.Nil(t, err)
+	var data, schema map[string]json.RawMessage
+	require.Nil(t, json.Unmarshal(b, &data))
+	require.Nil(t, json.Unmarshal(data["schema"], &schema))
+	var (
+		columns []kv.Column
+		rows    [][]*string
+	)
+	require.Nil(t, json.Unmarshal(schema["columns"], &columns))
+	require.Nil(t, json.Unmarshal(schema["data"], &rows))
+	col := -1
+	for i, column := range columns {
+		if !column.Primary {
+			col = i
+			break
+		}
+	}
+	require.NotEqual(t, col, -1)
+	for _, row := range rows {
+		if row == nil || row[col] == nil {
+			continue
+		}
+		value := *row[col]
+		switch columns[col].Tp {
+		case kv.TinyInt, kv.Int, kv.BigInt:
+			v, err := strconv.Atoi(value)
+			require.Nil(t, err)
+			value = strconv.Itoa(v + 1)
+		case kv.Date, kv.Datetime, kv.Timestamp:
+			v, err := strconv.ParseInt(value, 10, 64)
+			require.Nil(t, err)
+			value = strconv.FormatInt(v+2*24*3600, 10)
+		default:
+			value += "_"
+		}
+		row[col] = &value
+	}
+	schema["data"], err = json.Marshal(rows)
+	require.Nil(t, err)
+	data["schema"], err = json.Marshal(schema)
+	require.Nil(t, err)
+	b, err = json.Marshal(data)
+	require.Nil(t, err)
+	m.kvManager.Reset()
+	g, err = graph.LoadGraph(b, m.kvManager, m.cfg)
+	require.Nil(t, err)
+	return g
+}
+
+func TestMinimizeWithMemory(t *testing.T) {
+	cfg := newMemoryConfig("minimize")
+	cfg.Global.Seed = 1
+	m := NewManager(Option{Cfg: cfg})
+	opt := MinimizeOption{Runs: 1, Timeout: time.Second}
+
+	g, err := m.graphMgr.NewGraph(cfg.Global.Thread, cfg.Global.Action)
+	require.Nil(t, err)
+	_, err = m.Minimize(context.Background(), g, opt)
+	require.Error(t, err)
+
+	g = corruptGraph(t, m, g)
+	failure := m.runCandidate(context.Background(), g, opt.Timeout)
+	require.Error(t, failure)
+	minimized, err := m.Minimize(context.Background(), g, opt)
+	require.Nil(t, err)
+	require.True(t, len(graphUnits(minimized, actionLevel)) < len(graphUnits(g, actionLevel)))
+	err = m.runCandidate(context.Background(), minimized, opt.Timeout)
+	require.Error(t, err)
+	require.Equal(t, FailureKind(err), FailureKind(failure))
+
+	// the candidate databases are dropped
+	require.True(t, m.candidates > 0)
+	conn, err := db.NewMemory(cfg.Global.DSN)
+	require.Nil(t, err)
+	defer conn.Close()
+	for i := 0; i < m.candidates; i++ {
+		_, err = conn.Exec(fmt.Sprintf("DROP DATABASE %s_%d", cfg.Global.Database, i))
+		require.Error(t, err)
+	}
+}
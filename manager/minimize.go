@@ -0,0 +1,257 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/graph"
+)
+
+var (
+	quotedPattern = regexp.MustCompile(`'[^']*'`)
+	numberPattern = regexp.MustCompile(`-?\d+`)
+)
+
+// MinimizeOption controls how a candidate graph is checked
+type MinimizeOption struct {
+	// Runs is how many times a candidate is executed, it reproduces the failure if any of them fails
+	Runs int
+	// Timeout is the longest time of an execution, a hanging candidate is considered passed,
+	// the executions are not limited if it's 0
+	Timeout time.Duration
+}
+
+// unit is the part of graph removed together, it's a timeline, a txn or an action
+type unit [3]int
+
+type unitLevel int
+
+const (
+	timelineLevel unitLevel = iota
+	txnLevel
+	actionLevel
+)
+
+// FailureKind is the check broken by an execution, the SQLs and values in the error are ignored
+func FailureKind(err error) string {
+	msg := strings.SplitN(errors.Cause(err).Error(), "\n", 2)[0]
+	// read results are reported as "<SQL> got <reason>"
+	if strings.HasPrefix(msg, "SELECT") {
+		if i := strings.Index(msg, " got "); i >= 0 {
+			msg = msg[i+len(" got "):]
+			if strings.HasPrefix(msg, "expect ") && strings.Contains(msg, ", got ") {
+				msg = "expect value"
+			}
+		}
+	}
+	return numberPattern.ReplaceAllString(quotedPattern.ReplaceAllString(msg, "?"), "N")
+}
+
+// Minimize removes timelines, txns and actions from a failed graph by delta debugging,
+// it returns the smallest graph found which still fails with the same kind.
+func (m *Manager) Minimize(ctx context.Context, g *graph.Graph, opt MinimizeOption) (*graph.Graph, error) {
+	kind := ""
+	for i := 0; i < opt.Runs && kind == ""; i++ {
+		err := m.runCandidate(ctx, g, opt.Timeout)
+		if err != nil {
+			kind = FailureKind(err)
+		}
+	}
+	if kind == "" {
+		return nil, errors.New("the graph does not fail")
+	}
+	fmt.Println("minimize failure:", kind)
+
+	for _, level := range []unitLevel{timelineLevel, txnLevel, actionLevel} {
+		units := graphUnits(g, level)
+		test := func(keep []unit) bool {
+			candidate, err := m.removeUnits(g, level, complement(units, keep))
+			if err != nil {
+				return false
+			}
+			for i := 0; i < opt.Runs; i++ {
+				if ctx.Err() != nil {
+					return false
+				}
+				if err := m.runCandidate(ctx, candidate, opt.Timeout); err != nil && FailureKind(err) == kind {
+					return true
+				}
+			}
+			return false
+		}
+		keep := ddmin(units, test)
+		reduced, err := m.removeUnits(g, level, complement(units, keep))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		fmt.Printf("minimize level %d, %d units are kept from %d\n", level, len(keep), len(units))
+		g = reduced
+	}
+	return g, nil
+}
+
+// ddmin finds a small subset of units which still passes test,
+// it tests subsets and their complements with increasing granularity
+func ddmin(units []unit, test func([]unit) bool) []unit {
+	n := 2
+	for len(units) >= 2 {
+		chunks := split(units, n)
+		reduced := false
+		for _, chunk := range chunks {
+			if test(chunk) {
+				units, n, reduced = chunk, 2, true
+				break
+			}
+		}
+		if !reduced && n > 2 {
+			for _, chunk := range chunks {
+				rest := complement(units, chunk)
+				if test(rest) {
+					units, reduced = rest, true
+					if n -= 1; n < 2 {
+						n = 2
+					}
+					break
+				}
+			}
+		}
+		if !reduced {
+			if n >= len(units) {
+				break
+			}
+			if n *= 2; n > len(units) {
+				n = len(units)
+			}
+		}
+	}
+	if len(units) == 1 && test([]unit{}) {
+		return []unit{}
+	}
+	return units
+}
+
+func split(units []unit, n int) [][]unit {
+	var chunks [][]unit
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + (len(units)-start)/(n-i)
+		if end > start {
+			chunks = append(chunks, units[start:end])
+		}
+		start = end
+	}
+	return chunks
+}
+
+func complement(units, removed []unit) []unit {
+	set := make(map[unit]struct{}, len(removed))
+	for _, u := range removed {
+		set[u] = struct{}{}
+	}
+	var rest []unit
+	for _, u := range units {
+		if _, ok := set[u]; !ok {
+			rest = append(rest, u)
+		}
+	}
+	return rest
+}
+
+// graphUnits lists the non-empty timelines, txns or actions of graph
+func graphUnits(g *graph.Graph, level unitLevel) []unit {
+	var units []unit
+	for i := 0; i < g.TimelineNum(); i++ {
+		timeline := g.GetTimeline(i)
+		timelineEmpty := true
+		for j := 0; timeline.GetTxn(j) != nil; j++ {
+			txn := timeline.GetTxn(j)
+			for k := 0; txn.GetAction(k) != nil; k++ {
+				timelineEmpty = false
+				if level == actionLevel {
+					units = append(units, unit{i, j, k})
+				}
+			}
+			if level == txnLevel && txn.GetAction(0) != nil {
+				units = append(units, unit{i, j, 0})
+			}
+		}
+		if level == timelineLevel && !timelineEmpty {
+			units = append(units, unit{i, 0, 0})
+		}
+	}
+	return units
+}
+
+// removeUnits returns a copy of graph without the given units
+func (m *Manager) removeUnits(g *graph.Graph, level unitLevel, units []unit) (*graph.Graph, error) {
+	candidate, err := m.cloneGraph(g)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// remove the later actions first, so that the ids of others are not changed
+	units = append([]unit{}, units...)
+	sort.Slice(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		if a[0] != b[0] {
+			return a[0] > b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] > b[1]
+		}
+		return a[2] > b[2]
+	})
+	for _, u := range units {
+		switch level {
+		case timelineLevel:
+			candidate.RemoveTimeline(u[0])
+		case txnLevel:
+			candidate.RemoveTxn(u[0], u[1])
+		case actionLevel:
+			candidate.RemoveAction(u[0], u[1], u[2])
+		}
+	}
+	return candidate, nil
+}
+
+func (m *Manager) cloneGraph(g *graph.Graph) (*graph.Graph, error) {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	m.kvManager.Reset()
+	clone, err := graph.LoadGraph(b, m.kvManager, m.cfg)
+	return clone, errors.Trace(err)
+}
+
+// runCandidate executes a copy of graph, because the execution changes the states in graph.
+// A hanging candidate leaves its txns holding locks, so each candidate runs in a new database,
+// which is dropped after the connections to it are closed.
+func (m *Manager) runCandidate(ctx context.Context, g *graph.Graph, timeout time.Duration) error {
+	candidate, err := m.cloneGraph(g)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	database := m.cfg.Global.Database
+	m.cfg.Global.Database = fmt.Sprintf("%s_%d", database, m.candidates)
+	m.candidates++
+	defer func() {
+		if closeErr := m.closeDB(); closeErr != nil {
+			fmt.Println("close candidate database failed", closeErr)
+		} else if dropErr := m.dropDatabase(); dropErr != nil {
+			fmt.Println("drop candidate database failed", dropErr)
+		}
+		m.cfg.Global.Database = database
+	}()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return m.Replay(ctx, candidate, nil)
+}
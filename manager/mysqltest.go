@@ -0,0 +1,242 @@
+package manager
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/kv"
+)
+
+// mysqlErrorPattern matches the errors reported by MySQL driver, it gives the error code of text logs
+var mysqlErrorPattern = regexp.MustCompile(`^Error (\d+): (.*)$`)
+
+// mysqlSQLStates are the SQL states of the errors expected in tests, the others are HY000
+var mysqlSQLStates = map[string]string{
+	"1062": "23000",
+	"1213": "40001",
+}
+
+// MySQLTest is a mysql-test case, Test is the .test file and Result is the expected .result file
+type MySQLTest struct {
+	Test   string
+	Result string
+}
+
+// mysqlTestWriter writes the commands into .test and the echo and outputs of them into .result
+type mysqlTestWriter struct {
+	test    strings.Builder
+	result  strings.Builder
+	current int
+}
+
+// ExportMySQLTest converts the graph and the interleaving recorded in logPath into a mysql-test case.
+// Each thread runs in its own connection, the statements overlapped with the ones of other threads are sent
+// and reaped before the next statements of their threads, so the blocked statements don't block the test.
+// The results of reads are computed from the values expected by graph, the errors are the recorded ones.
+func (m *Manager) ExportMySQLTest(logPath string) (*MySQLTest, error) {
+	g, err := m.LoadGraph(path.Join(logPath, "graph.json"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	records, err := readThreadRecords(logPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(records) == 0 {
+		return nil, errors.Errorf("no thread log found in %s", logPath)
+	}
+	var (
+		schema  = g.Schema()
+		threads = make([]int, 0, len(records))
+		matches = make(map[int][]recordMatch, len(records))
+		w       = mysqlTestWriter{current: -1}
+	)
+	for thread := range records {
+		threads = append(threads, thread)
+		matches[thread] = matchRecords(g, thread, records[thread])
+	}
+	sort.Ints(threads)
+	merged := mergeRecords(records)
+
+	// output is the expected result of a statement
+	output := func(record *threadRecord) string {
+		if record.err != nil {
+			code, message := mysqlError(record)
+			state, ok := mysqlSQLStates[code]
+			if !ok {
+				state = "HY000"
+			}
+			return fmt.Sprintf("ERROR %s: %s\n", state, message)
+		}
+		if !record.tp.IsRead() {
+			return ""
+		}
+		var rows [][]string
+		if action := matches[record.thread][record.index].action; action != nil {
+			if action.VID() != kv.NULL_VALUE_ID {
+				data := schema.Data[action.VID()]
+				row := make([]string, len(schema.Columns))
+				for i, column := range schema.Columns {
+					row[i] = column.Tp.ValToPureString(data[i])
+				}
+				rows = append(rows, row)
+			}
+		} else {
+			// the statements out of graph return what they returned
+			for _, items := range record.rows {
+				row := make([]string, len(items))
+				for i, item := range items {
+					row[i] = item.ValString
+					if item.Null {
+						row[i] = "NULL"
+					}
+				}
+				rows = append(rows, row)
+			}
+		}
+		if len(rows) == 0 {
+			return ""
+		}
+		var b strings.Builder
+		for i, column := range schema.Columns {
+			if i != 0 {
+				b.WriteString("\t")
+			}
+			b.WriteString(column.Name)
+		}
+		b.WriteString("\n")
+		for _, row := range rows {
+			b.WriteString(strings.Join(row, "\t"))
+			b.WriteString("\n")
+		}
+		return b.String()
+	}
+
+	fmt.Fprintf(&w.test, "# generated by mikadzuki from %s, seed %d\n", logPath, g.Seed())
+	w.test.WriteString("--disable_warnings\n")
+	w.stmt(fmt.Sprintf("DROP TABLE IF EXISTS %s", schema.TableName()))
+	w.test.WriteString("--enable_warnings\n")
+	for _, stmt := range g.GetSchemas() {
+		w.stmt(stmt)
+	}
+	for _, thread := range threads {
+		fmt.Fprintf(&w.test, "connect (%s,localhost,root,,);\n", connectionName(thread))
+		fmt.Fprintf(&w.result, "connect  %s,localhost,root,,;\n", connectionName(thread))
+		// the connection becomes the current one after connected
+		if timeline := g.GetTimeline(thread); timeline != nil {
+			level := strings.ToUpper(timeline.Isolation().Level().String())
+			w.stmt(fmt.Sprintf("SET SESSION TRANSACTION ISOLATION LEVEL %s", level))
+		}
+	}
+
+	var (
+		pending = make(map[int]*threadRecord)
+		reap    = func(record *threadRecord) {
+			w.expectError(record)
+			w.test.WriteString("reap;\n")
+			w.allowError(record)
+			w.result.WriteString(output(record))
+		}
+	)
+	for i := range merged {
+		record := &merged[i]
+		w.connection(record.thread)
+		if before, ok := pending[record.thread]; ok {
+			reap(before)
+			delete(pending, record.thread)
+		}
+		unfinished := !record.status && record.err == nil
+		overlapped := unfinished
+		for j := i + 1; j < len(merged) && merged[j].startTime.Before(record.endTime); j++ {
+			if merged[j].thread != record.thread {
+				overlapped = true
+				break
+			}
+		}
+		if !overlapped {
+			w.expectError(record)
+			w.stmt(record.sql)
+			w.allowError(record)
+			w.result.WriteString(output(record))
+			continue
+		}
+		fmt.Fprintf(&w.test, "send %s;\n", record.sql)
+		fmt.Fprintf(&w.result, "%s;\n", record.sql)
+		// the unfinished statements hang, they are not reaped
+		if !unfinished {
+			pending[record.thread] = record
+		}
+	}
+	for _, thread := range threads {
+		if record, ok := pending[thread]; ok {
+			w.connection(thread)
+			reap(record)
+		}
+	}
+	for _, thread := range threads {
+		w.command(fmt.Sprintf("disconnect %s;\n", connectionName(thread)))
+	}
+	w.command("connection default;\n")
+	w.stmt(fmt.Sprintf("DROP TABLE %s", schema.TableName()))
+	return &MySQLTest{
+		Test:   w.test.String(),
+		Result: w.result.String(),
+	}, nil
+}
+
+// command writes a command which is echoed in result
+func (w *mysqlTestWriter) command(cmd string) {
+	w.test.WriteString(cmd)
+	w.result.WriteString(cmd)
+}
+
+func (w *mysqlTestWriter) stmt(sql string) {
+	w.command(sql + ";\n")
+}
+
+func (w *mysqlTestWriter) connection(thread int) {
+	if w.current != thread {
+		w.current = thread
+		w.command(fmt.Sprintf("connection %s;\n", connectionName(thread)))
+	}
+}
+
+// expectError expects the recorded error of the following statement,
+// the error without code is allowed by disabling abort on error until allowError
+func (w *mysqlTestWriter) expectError(record *threadRecord) {
+	if record.err == nil {
+		return
+	}
+	if code, _ := mysqlError(record); code != "" {
+		fmt.Fprintf(&w.test, "--error %s\n", code)
+	} else {
+		w.test.WriteString("--disable_abort_on_error\n")
+	}
+}
+
+func (w *mysqlTestWriter) allowError(record *threadRecord) {
+	if record.err == nil {
+		return
+	}
+	if code, _ := mysqlError(record); code == "" {
+		w.test.WriteString("--enable_abort_on_error\n")
+	}
+}
+
+// mysqlError returns the error code and message of a recorded error,
+// the code is parsed from the message if it's not recorded
+func mysqlError(record *threadRecord) (string, string) {
+	code := record.code
+	message := strings.SplitN(record.err.Error(), "\n", 2)[0]
+	if match := mysqlErrorPattern.FindStringSubmatch(message); len(match) != 0 {
+		if code == "" {
+			code = match[1]
+		}
+		message = match[2]
+	}
+	return code, message
+}
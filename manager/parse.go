@@ -0,0 +1,409 @@
+package manager
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/util"
+)
+
+// recordSource gives the statements of a thread in order, it returns nil at the end
+type recordSource interface {
+	next() (*SQLLog, error)
+}
+
+// sliceSource gives the parsed statements
+type sliceSource struct {
+	logs []SQLLog
+}
+
+func (s *sliceSource) next() (*SQLLog, error) {
+	if len(s.logs) == 0 {
+		return nil, nil
+	}
+	log := &s.logs[0]
+	s.logs = s.logs[1:]
+	return log, nil
+}
+
+// threadReader reads the statements from a thread log file one by one,
+// so the large logs are not loaded into memory
+type threadReader struct {
+	file   *os.File
+	reader *bufio.Reader
+	jsonl  bool
+	// line is the first line of the next text record
+	line string
+	eof  bool
+}
+
+func openThreadReader(file string) (*threadReader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &threadReader{
+		file:   f,
+		reader: bufio.NewReader(f),
+		jsonl:  strings.HasSuffix(file, ".jsonl"),
+	}, nil
+}
+
+// readLine reads a line without the line break, it returns io.EOF if there is no more line
+func (r *threadReader) readLine() (string, error) {
+	if r.eof {
+		return "", io.EOF
+	}
+	line, err := r.reader.ReadString('\n')
+	if err == io.EOF {
+		r.eof = true
+		if line == "" {
+			return "", io.EOF
+		}
+	} else if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (r *threadReader) next() (*SQLLog, error) {
+	if r.jsonl {
+		return r.nextJSONL()
+	}
+	return r.nextText()
+}
+
+func (r *threadReader) nextJSONL() (*SQLLog, error) {
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var j jsonLog
+		if err := json.Unmarshal([]byte(line), &j); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if j.Version > JSONL_VERSION {
+			return nil, errors.Errorf("unsupported log version %d", j.Version)
+		}
+		log := j.toSQLLog()
+		return &log, nil
+	}
+}
+
+// nextText reads the lines until the start of next record, because the error of a failed statement may take multiple lines
+func (r *threadReader) nextText() (*SQLLog, error) {
+	for {
+		text := r.line
+		r.line = ""
+		for {
+			line, err := r.readLine()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if text != "" && isRecordStart(line) {
+				r.line = line
+				break
+			}
+			if text == "" {
+				text = line
+			} else {
+				text += "\n" + line
+			}
+		}
+		if text == "" && r.line == "" {
+			return nil, nil
+		}
+		log, err := parseTextRecord(text)
+		if err != nil || log != nil {
+			return log, err
+		}
+	}
+}
+
+func (r *threadReader) close() {
+	if err := r.file.Close(); err != nil {
+		fmt.Println("close thread log failed", err)
+	}
+}
+
+func isRecordStart(line string) bool {
+	return strings.HasPrefix(line, "[SUCCESS]") ||
+		strings.HasPrefix(line, "[UNFINISHED]") ||
+		strings.HasPrefix(line, "[FAILED ")
+}
+
+// parseTextRecord parses a statement in text format, it returns nil if text is not a statement
+func parseTextRecord(text string) (*SQLLog, error) {
+	match := recordPattern.FindStringSubmatch(strings.TrimRight(text, "\n"))
+	if len(match) == 0 {
+		return nil, nil
+	}
+	// the times are formatted in local time zone
+	startTime, err := time.ParseInLocation(LOGTIME_FORMAT, match[4], time.Local)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	endTime, err := time.ParseInLocation(LOGTIME_FORMAT, match[5], time.Local)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	record := SQLLog{
+		startTime: startTime,
+		endTime:   endTime,
+		tp:        graph.ActionTp(match[3]),
+		sql:       match[6],
+		status:    match[1] == "SUCCESS",
+		xID:       -1,
+		aID:       -1,
+		kID:       -1,
+		vID:       -1,
+		affected:  -1,
+	}
+	if strings.HasPrefix(match[1], "FAILED ") {
+		record.err = errors.New(match[2])
+	}
+	if result := resultPattern.FindStringSubmatch(record.sql); len(result) != 0 {
+		record.sql = result[1]
+		if result[2] == "rows" {
+			var values [][]*string
+			if err := json.Unmarshal([]byte(result[3]), &values); err != nil {
+				return nil, errors.Trace(err)
+			}
+			record.rows = decodeRows(values)
+		} else if record.affected, err = strconv.ParseInt(result[3], 10, 64); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return &record, nil
+}
+
+// openThreadReaders opens thread-N.log or thread-N.jsonl files in logPath, the key is N,
+// only the threads in filter are opened if it's not empty
+func openThreadReaders(logPath string, threads []int) (map[int]*threadReader, error) {
+	infos, err := ioutil.ReadDir(logPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	readers := make(map[int]*threadReader)
+	for _, info := range infos {
+		matches := threadPattern.FindStringSubmatch(info.Name())
+		if len(matches) == 0 {
+			continue
+		}
+		thread, err := strconv.Atoi(matches[1])
+		util.AssertNil(err)
+		if len(threads) != 0 && !containsInt(threads, thread) {
+			continue
+		}
+		reader, err := openThreadReader(path.Join(logPath, info.Name()))
+		if err != nil {
+			closeThreadReaders(readers)
+			return nil, err
+		}
+		readers[thread] = reader
+	}
+	return readers, nil
+}
+
+func closeThreadReaders(readers map[int]*threadReader) {
+	for _, reader := range readers {
+		reader.close()
+	}
+}
+
+// readThreadRecords parses the statements in thread-N.log or thread-N.jsonl files in logPath, the key is N
+func readThreadRecords(logPath string) (map[int][]SQLLog, error) {
+	readers, err := openThreadReaders(logPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closeThreadReaders(readers)
+	records := make(map[int][]SQLLog, len(readers))
+	for thread, reader := range readers {
+		for {
+			log, err := reader.next()
+			if err != nil {
+				return nil, errors.Annotatef(err, "parse thread-%d log", thread)
+			}
+			if log == nil {
+				break
+			}
+			records[thread] = append(records[thread], *log)
+		}
+	}
+	return records, nil
+}
+
+// threadRecord is a statement in merged thread logs, index is the position in its thread
+type threadRecord struct {
+	thread int
+	index  int
+	SQLLog
+}
+
+// recordHeap is the min-heap of the next statements of threads, ordered by start time and thread
+type recordHeap []threadRecord
+
+func (h recordHeap) Len() int { return len(h) }
+
+func (h recordHeap) Less(i, j int) bool {
+	if !h[i].startTime.Equal(h[j].startTime) {
+		return h[i].startTime.Before(h[j].startTime)
+	}
+	return h[i].thread < h[j].thread
+}
+
+func (h recordHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *recordHeap) Push(x interface{}) {
+	*h = append(*h, x.(threadRecord))
+}
+
+func (h *recordHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// mergeSources merges the statements of threads by start time, fn is called on each statement in order,
+// only the next statement of each thread is kept in memory
+func mergeSources(sources map[int]recordSource, fn func(*threadRecord) error) error {
+	h := make(recordHeap, 0, len(sources))
+	for thread, source := range sources {
+		log, err := source.next()
+		if err != nil {
+			return errors.Annotatef(err, "parse thread-%d log", thread)
+		}
+		if log != nil {
+			h = append(h, threadRecord{thread, 0, *log})
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		record := h[0]
+		if err := fn(&record); err != nil {
+			return err
+		}
+		log, err := sources[record.thread].next()
+		if err != nil {
+			return errors.Annotatef(err, "parse thread-%d log", record.thread)
+		}
+		if log == nil {
+			heap.Pop(&h)
+			continue
+		}
+		h[0] = threadRecord{record.thread, record.index + 1, *log}
+		heap.Fix(&h, 0)
+	}
+	return nil
+}
+
+// mergeRecords merges the parsed statements of threads by start time
+func mergeRecords(records map[int][]SQLLog) []threadRecord {
+	var merged []threadRecord
+	sources := make(map[int]recordSource, len(records))
+	for thread, logs := range records {
+		sources[thread] = &sliceSource{logs}
+	}
+	_ = mergeSources(sources, func(record *threadRecord) error {
+		merged = append(merged, *record)
+		return nil
+	})
+	return merged
+}
+
+// mergeThreadLogs merges the thread logs in logPath by start time without loading them into memory,
+// only the threads in filter are read if it's not empty
+func mergeThreadLogs(logPath string, threads []int, fn func(*threadRecord) error) error {
+	readers, err := openThreadReaders(logPath, threads)
+	if err != nil {
+		return err
+	}
+	defer closeThreadReaders(readers)
+	if len(readers) == 0 {
+		return errors.Errorf("no thread log found in %s", logPath)
+	}
+	sources := make(map[int]recordSource, len(readers))
+	for thread, reader := range readers {
+		sources[thread] = reader
+	}
+	return mergeSources(sources, fn)
+}
+
+// LogFilter selects the statements in thread logs, the empty conditions match all
+type LogFilter struct {
+	Threads []int
+	// Txns and Keys match the ids recorded in JSONL logs, text logs don't record them
+	Txns []int
+	Keys []int
+	// Since and Until limit the start time of statements
+	Since time.Time
+	Until time.Time
+	// FailedOnly selects the statements failed with error
+	FailedOnly bool
+}
+
+func (f *LogFilter) Match(record *threadRecord) bool {
+	if len(f.Threads) != 0 && !containsInt(f.Threads, record.thread) {
+		return false
+	}
+	if len(f.Txns) != 0 && !containsInt(f.Txns, record.xID) {
+		return false
+	}
+	if len(f.Keys) != 0 && !containsInt(f.Keys, record.kID) {
+		return false
+	}
+	if !f.Since.IsZero() && record.startTime.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !record.startTime.Before(f.Until) {
+		return false
+	}
+	return !f.FailedOnly || record.err != nil
+}
+
+// ParseLog merges the thread logs of both formats in logPath by start time,
+// and writes the statements selected by filter into w
+func ParseLog(logPath string, filter *LogFilter, w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	if err := mergeThreadLogs(logPath, filter.Threads, func(record *threadRecord) error {
+		if !filter.Match(record) {
+			return nil
+		}
+		_, err := fmt.Fprintf(writer, "[THREAD %d] %s\n", record.thread, record.String())
+		return err
+	}); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func containsInt(s []int, n int) bool {
+	for _, i := range s {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/you06/go-mikadzuki/graph"
+	"github.com/you06/go-mikadzuki/util"
+)
+
+// INTERLEAVING_TIMEOUT is how long a statement waits for its turn,
+// the recorded order is given up after it, because the execution may differ from the record
+const INTERLEAVING_TIMEOUT = time.Second
+
+// Interleaving forces the statements of threads to start in the recorded order,
+// txn statements are not ordered because they are executed with the txn lock of graph,
+// waiting for other threads inside the lock will block them all
+type Interleaving struct {
+	sync.Mutex
+	// order is the thread of each statement sorted by start time
+	order  []int
+	pos    int
+	remain map[int]int
+	broken bool
+}
+
+func NewInterleaving(order []int) *Interleaving {
+	remain := make(map[int]int)
+	for _, tID := range order {
+		remain[tID]++
+	}
+	return &Interleaving{
+		order:  order,
+		pos:    0,
+		remain: remain,
+		broken: false,
+	}
+}
+
+// LoadInterleaving reads the order of statements from the start time in thread logs
+func LoadInterleaving(logPath string) (*Interleaving, error) {
+	records, err := readThreadRecords(logPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	type start struct {
+		thread int
+		index  int
+		time   time.Time
+	}
+	var starts []start
+	for thread, logs := range records {
+		index := 0
+		for _, log := range logs {
+			if log.tp.IsTxn() {
+				continue
+			}
+			starts = append(starts, start{thread, index, log.startTime})
+			index++
+		}
+	}
+	if len(starts) == 0 {
+		return nil, errors.Errorf("no thread log found in %s", logPath)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		a, b := starts[i], starts[j]
+		if !a.time.Equal(b.time) {
+			return a.time.Before(b.time)
+		}
+		if a.thread != b.thread {
+			return a.thread < b.thread
+		}
+		return a.index < b.index
+	})
+	order := make([]int, len(starts))
+	for i, s := range starts {
+		order[i] = s.thread
+	}
+	return NewInterleaving(order), nil
+}
+
+// Wait blocks until it's the turn of thread tID,
+// txn statements and the statements out of the record are not blocked
+func (i *Interleaving) Wait(tID int, tp graph.ActionTp) {
+	if tp.IsTxn() {
+		return
+	}
+	start := time.Now()
+	for {
+		i.Lock()
+		if i.broken || i.remain[tID] == 0 {
+			i.Unlock()
+			return
+		}
+		if i.order[i.pos] == tID {
+			i.pos++
+			i.remain[tID]--
+			i.Unlock()
+			return
+		}
+		if time.Since(start) > INTERLEAVING_TIMEOUT {
+			fmt.Printf("thread %d waits for thread %d over %s, give up the recorded order\n", tID, i.order[i.pos], INTERLEAVING_TIMEOUT)
+			i.broken = true
+			i.Unlock()
+			return
+		}
+		i.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// LoadGraph reads the graph saved as graph.json
+func (m *Manager) LoadGraph(graphPath string) (*graph.Graph, error) {
+	b, err := ioutil.ReadFile(graphPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	m.kvManager.Reset()
+	g, err := graph.LoadGraph(b, m.kvManager, m.cfg)
+	return g, errors.Trace(err)
+}
+
+// Replay executes a loaded graph in a new database,
+// the statements start in the order of interleaving if it's not nil
+func (m *Manager) Replay(ctx context.Context, g *graph.Graph, interleaving *Interleaving) error {
+	startTime := util.NowStr()
+	if err := m.initDB(); err != nil {
+		return err
+	}
+	if m.cfg.Global.LogPath != "" {
+		m.DumpGraph(g, startTime)
+	}
+	_, err := m.execGraph(ctx, g, startTime, interleaving)
+	return err
+}
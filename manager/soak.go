@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/you06/go-mikadzuki/util"
+)
+
+// RunOption limits the rounds executed by Run, the zero values mean no limit
+type RunOption struct {
+	Rounds   int
+	Duration time.Duration
+}
+
+// Summary is the result of the rounds executed by Run
+type Summary struct {
+	Rounds     int
+	Statements int
+	// Aborts is the number of failed statements, most of them are the expected deadlocks and lock timeouts
+	Aborts   int
+	Failures []Failure
+}
+
+// Failure is a failed round, the graph can be regenerated by Seed or loaded from LogPath
+type Failure struct {
+	Round   int
+	Seed    int64
+	LogPath string
+	Err     error
+}
+
+// Run executes graphs round by round until the limits of opt are reached or ctx is canceled,
+// the rounds are logged into log-path/START/round-N, the failures are collected without stopping the rounds.
+// The duration is checked between rounds, the running round is not interrupted.
+func (m *Manager) Run(ctx context.Context, opt RunOption) *Summary {
+	var (
+		summary  Summary
+		start    = time.Now()
+		startStr = util.NowStr()
+	)
+	for round := 0; opt.Rounds == 0 || round < opt.Rounds; round++ {
+		if opt.Duration != 0 && time.Since(start) >= opt.Duration {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return &summary
+		default:
+		}
+		var (
+			seed   = m.Seed()
+			logDir = path.Join(startStr, fmt.Sprintf("round-%d", round))
+		)
+		fmt.Printf("round %d, seed: %d\n", round, seed)
+		logs, err := m.round(ctx, logDir)
+		summary.Rounds++
+		if logs != nil {
+			statements, aborts := logs.Count()
+			summary.Statements += statements
+			summary.Aborts += aborts
+		}
+		if err != nil {
+			fmt.Printf("round %d failed %v\n", round, err)
+			failure := Failure{
+				Round: round,
+				Seed:  seed,
+				Err:   err,
+			}
+			if m.cfg.Global.LogPath != "" {
+				failure.LogPath = path.Join(m.cfg.Global.LogPath, logDir)
+			}
+			summary.Failures = append(summary.Failures, failure)
+		}
+	}
+	return &summary
+}
+
+func (s *Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "rounds: %d, statements: %d, aborts: %d, failures: %d\n", s.Rounds, s.Statements, s.Aborts, len(s.Failures))
+	for _, failure := range s.Failures {
+		fmt.Fprintf(&b, "round %d failed, seed: %d", failure.Round, failure.Seed)
+		if failure.LogPath != "" {
+			fmt.Fprintf(&b, ", log: %s", failure.LogPath)
+		}
+		fmt.Fprintf(&b, "\n  %s\n", strings.SplitN(failure.Err.Error(), "\n", 2)[0])
+	}
+	return b.String()
+}
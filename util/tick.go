@@ -21,9 +21,11 @@ func NewTicker(duration time.Duration) Ticker {
 func (t *Ticker) Go(f func()) {
 	go func() {
 		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
 		for range ticker.C {
 			t.RLock()
 			if t.end {
+				t.RUnlock()
 				return
 			}
 			if time.Since(t.last) > t.duration {
@@ -1,10 +1,10 @@
 package util
 
 import (
+	"fmt"
 	"math/rand"
+	"sync"
 	"time"
-
-	"github.com/docker/docker/pkg/namesgenerator"
 )
 
 var (
@@ -18,59 +18,99 @@ var (
 	START_TIME = time.Now().Format("2006-01-02_15:04:05")
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// NewRand returns a random source seeded by seed, or by current time if seed is 0
+func NewRand(seed int64) (*rand.Rand, int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return NewLockedRand(seed), seed
+}
+
+// NewLockedRand returns a random source seeded by seed which is safe for concurrent use,
+// the graph regenerates SQLs by it while the workers are executing
+func NewLockedRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// lockedSource is the same as the global source of math/rand
+type lockedSource struct {
+	sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Uint64()
 }
 
-func RdRange(min, max int) int {
+func (s *lockedSource) Seed(seed int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.src.Seed(seed)
+}
+
+func RdRange(rd *rand.Rand, min, max int) int {
 	if min == max {
 		return min
 	} else if min > max {
 		min, max = max, min
 	}
 	if (max-min >= 0x7fffffffffffffff || max-min < 0) && min < 0 && max > 0 {
-		return min + rand.Intn(2) + rand.Intn((-min)-1) + rand.Intn(max)
+		return min + rd.Intn(2) + rd.Intn((-min)-1) + rd.Intn(max)
 	}
-	return min + rand.Intn(max-min)
+	return min + rd.Intn(max-min)
 }
 
-func RdMoment() time.Time {
-	sec := rand.Int63n(TIME_DELTA) + TIME_MIN
+func RdMoment(rd *rand.Rand) time.Time {
+	sec := rd.Int63n(TIME_DELTA) + TIME_MIN
 	return time.Unix(sec, 0)
 }
 
-func RdDate() time.Time {
-	return RdMoment()
+func RdDate(rd *rand.Rand) time.Time {
+	return RdMoment(rd)
 }
 
-func RdDateTime() time.Time {
-	return RdMoment()
+func RdDateTime(rd *rand.Rand) time.Time {
+	return RdMoment(rd)
 }
 
-func RdTimestamp() time.Time {
-	sec := rand.Int63n(TS_DELTA) + TS_MIN
+func RdTimestamp(rd *rand.Rand) time.Time {
+	sec := rd.Int63n(TS_DELTA) + TS_MIN
 	return time.Unix(sec, 0)
 }
 
-func RdName() string {
-	return namesgenerator.GetRandomName(RdRange(0, 1000))
+// RdName returns a name like "adjective_surname3",
+// the words are random letters so that the name only depends on rd
+func RdName(rd *rand.Rand) string {
+	return fmt.Sprintf("%s_%s%d", rdLetters(rd, RdRange(rd, 4, 10)), rdLetters(rd, RdRange(rd, 4, 10)), RdRange(rd, 0, 10))
+}
+
+func RdBool(rd *rand.Rand) bool {
+	return rd.Intn(2) == 0
 }
 
-func RdBool() bool {
-	return rand.Intn(2) == 0
+func RdBoolRatio(rd *rand.Rand, ratio float64) bool {
+	return rd.Float64() < ratio
 }
 
-func RdBoolRatio(ratio float64) bool {
-	return rand.Float64() < ratio
+func RdHash(rd *rand.Rand) string {
+	// TODO: add number into random string
+	return rdLetters(rd, HASH_LEN)
 }
 
-func RdHash() string {
-	hash := make([]rune, HASH_LEN)
-	for i := 0; i < HASH_LEN; i++ {
-		// TODO: add number into random string
-		hash[i] = rune(RdRange(0x61, 0x7a))
+func rdLetters(rd *rand.Rand, n int) string {
+	letters := make([]rune, n)
+	for i := 0; i < n; i++ {
+		letters[i] = rune(RdRange(rd, 0x61, 0x7a))
 	}
-	return string(hash)
+	return string(letters)
 }
 
 func NowStr() string {